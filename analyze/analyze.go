@@ -0,0 +1,320 @@
+// Package analyze reports the distribution of the keys before partitioning.
+package analyze
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"maps"
+	"math"
+	"path/filepath"
+	"slices"
+
+	ha "github.com/hamba/avro/v2"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+	pk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/pkey"
+)
+
+var ErrNoSchema error = errors.New("no schema to compute the record sizes")
+
+// The number of the directories of a fan-out level(2 hex chars).
+const FanOutWidth int = 256
+
+// The minimum number of the counters to find the hottest keys.
+const SpaceSavingCapacityMin int = 1024
+
+// The fan-out levels of the projection.
+const FanOutLevelsMax int = 3
+
+type Config struct {
+	KeyField string
+
+	// Counts the distinct keys exactly if true(uses memory for each key);
+	// otherwise estimates using the HyperLogLog.
+	Exact bool
+
+	// The precision of the HyperLogLog(0: PrecisionDefault).
+	Precision int
+
+	// The number of the hottest keys(0: TopNDefault).
+	TopN int
+
+	// The schema of the records(empty: the schema of the input metadata).
+	Schema string
+
+	// The blob fields(empty: all the bytes fields).
+	BlobFields []string
+
+	// The layout of the partitions.
+	eh.KeyToFilename
+}
+
+// DirSize is the predicted size of a directory.
+type DirSize struct {
+	Dir     string `json:"dir"`
+	Files   uint64 `json:"files"`
+	Records int64  `json:"records"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// FanOut is the projection of the files using the fan-out directories.
+type FanOut struct {
+	Levels      int     `json:"levels"`
+	Dirs        uint64  `json:"dirs"`
+	FilesPerDir float64 `json:"files_per_dir"`
+}
+
+type Report struct {
+	Records     int64 `json:"records"`
+	NullKeys    int64 `json:"null_keys"`
+	InvalidKeys int64 `json:"invalid_keys"`
+
+	DistinctKeys  uint64 `json:"distinct_keys"`
+	DistinctExact bool   `json:"distinct_exact"`
+
+	TopKeys []KeyCount `json:"top_keys"`
+
+	RecordSizes Histogram `json:"record_sizes"`
+	BlobSizes   Histogram `json:"blob_sizes"`
+
+	// The number of the partition files(without rolling).
+	PredictedFiles uint64 `json:"predicted_files"`
+
+	// The sizes are the sizes of the encoded records(uncompressed).
+	Dirs []DirSize `json:"dirs"`
+
+	FanOuts []FanOut `json:"fan_outs"`
+}
+
+// distinct counts the distinct keys exactly or approximately.
+type distinct struct {
+	exact map[string]int64
+	hll   *HyperLogLog
+}
+
+func (c Config) newDistinct() *distinct {
+	switch c.Exact {
+	case true:
+		return &distinct{exact: map[string]int64{}}
+	default:
+		return &distinct{hll: HyperLogLogNew(c.Precision)}
+	}
+}
+
+func (d *distinct) add(key string) {
+	switch nil == d.hll {
+	case true:
+		d.exact[key]++
+	default:
+		d.hll.Add(key)
+	}
+}
+
+func (d *distinct) count() uint64 {
+	if nil == d.hll {
+		return uint64(len(d.exact))
+	}
+	return d.hll.Count()
+}
+
+type dirStat struct {
+	files   *distinct
+	records int64
+	bytes   int64
+}
+
+type Analyzer struct {
+	Config
+
+	schema ha.Schema
+	report Report
+
+	keys   *distinct
+	hot    *SpaceSaving
+	dirs   map[string]*dirStat
+	schErr error
+}
+
+func (c Config) topN() int {
+	if c.TopN <= 0 {
+		return TopNDefault
+	}
+	return c.TopN
+}
+
+func (c Config) New() (*Analyzer, error) {
+	var a *Analyzer = &Analyzer{
+		Config: c,
+		keys:   c.newDistinct(),
+		dirs:   map[string]*dirStat{},
+	}
+	if !c.Exact {
+		a.hot = SpaceSavingNew(max(SpaceSavingCapacityMin, 10*c.topN()))
+	}
+	if "" == c.Schema {
+		return a, nil
+	}
+	parsed, e := ha.Parse(c.Schema)
+	a.schema = parsed
+	return a, e
+}
+
+// OnMetadata uses the schema of the input if no schema is configured.
+func (a *Analyzer) OnMetadata(meta map[string][]byte) {
+	if nil != a.schema {
+		return
+	}
+	a.schema, a.schErr = ha.Parse(string(meta["avro.schema"]))
+}
+
+func (a *Analyzer) blob(field string) bool {
+	return 0 == len(a.BlobFields) || slices.Contains(a.BlobFields, field)
+}
+
+func (a *Analyzer) addSizes(m map[string]any) (int64, error) {
+	for field, val := range m {
+		blob, isBlob := val.([]byte)
+		if isBlob && a.blob(field) {
+			a.report.BlobSizes.Add(int64(len(blob)))
+		}
+	}
+
+	if nil == a.schema {
+		return 0, errors.Join(ErrNoSchema, a.schErr)
+	}
+	encoded, e := ha.Marshal(a.schema, m)
+	if nil != e {
+		return 0, e
+	}
+	var size int64 = int64(len(encoded))
+	a.report.RecordSizes.Add(size)
+	return size, nil
+}
+
+func (a *Analyzer) addPath(
+	ctx context.Context,
+	key pk.PrimaryKey,
+	encoded string,
+	wtr pk.PrimaryKeyWriter,
+	size int64,
+) error {
+	if nil == a.KeyToFilename {
+		return nil
+	}
+	filename, e := a.KeyToFilename(key, wtr)(ctx)
+	if nil != e {
+		return e
+	}
+
+	var dir string = filepath.Dir(filename)
+	stat, found := a.dirs[dir]
+	if !found {
+		stat = &dirStat{files: a.Config.newDistinct()}
+		a.dirs[dir] = stat
+	}
+	stat.files.add(encoded)
+	stat.records++
+	stat.bytes += size
+	return nil
+}
+
+// Add analyzes the record.
+func (a *Analyzer) Add(
+	ctx context.Context,
+	m map[string]any,
+	wtr pk.PrimaryKeyWriter,
+) error {
+	a.report.Records++
+	size, e := a.addSizes(m)
+	if nil != e {
+		return e
+	}
+
+	val, found := m[a.KeyField]
+	if !found || nil == val {
+		a.report.NullKeys++
+		return nil
+	}
+
+	var key pk.PrimaryKey = pk.AnyToKey(val)
+	encoded, e := key(wtr)(ctx)
+	if nil != e {
+		a.report.InvalidKeys++
+		return nil
+	}
+
+	a.keys.add(encoded)
+	if nil != a.hot {
+		a.hot.Add(encoded)
+	}
+	return a.addPath(ctx, key, encoded, wtr, size)
+}
+
+// AddAll analyzes all the records.
+func (a *Analyzer) AddAll(
+	ctx context.Context,
+	m iter.Seq2[map[string]any, error],
+	wtr pk.PrimaryKeyWriter,
+) error {
+	for row, e := range m {
+		if nil != e {
+			return e
+		}
+		e = a.Add(ctx, row, wtr)
+		if nil != e {
+			return e
+		}
+	}
+	return nil
+}
+
+func (a *Analyzer) topKeys() []KeyCount {
+	if nil != a.hot {
+		return a.hot.Top(a.topN())
+	}
+	var counts []KeyCount = make([]KeyCount, 0, len(a.keys.exact))
+	for key, records := range a.keys.exact {
+		counts = append(counts, KeyCount{Key: key, Records: records})
+	}
+	return sortCounts(counts, a.topN())
+}
+
+func fanOuts(files uint64) []FanOut {
+	var ret []FanOut
+	for levels := range FanOutLevelsMax + 1 {
+		var width float64 = math.Pow(float64(FanOutWidth), float64(levels))
+		var dirs uint64 = uint64(max(1, min(width, float64(files))))
+		ret = append(ret, FanOut{
+			Levels:      levels,
+			Dirs:        dirs,
+			FilesPerDir: float64(files) / float64(dirs),
+		})
+	}
+	return ret
+}
+
+// Report returns the report of the analyzed records.
+func (a *Analyzer) Report() Report {
+	var ret Report = a.report
+	ret.DistinctKeys = a.keys.count()
+	ret.DistinctExact = a.Exact
+	ret.TopKeys = a.topKeys()
+	ret.RecordSizes.Finish()
+	ret.BlobSizes.Finish()
+
+	ret.Dirs = []DirSize{}
+	for _, dir := range slices.Sorted(maps.Keys(a.dirs)) {
+		var stat *dirStat = a.dirs[dir]
+		var files uint64 = stat.files.count()
+		ret.PredictedFiles += files
+		ret.Dirs = append(ret.Dirs, DirSize{
+			Dir:     dir,
+			Files:   files,
+			Records: stat.records,
+			Bytes:   stat.bytes,
+		})
+	}
+	ret.FanOuts = fanOuts(ret.PredictedFiles)
+	return ret
+}
@@ -0,0 +1,127 @@
+package analyze_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+
+	az "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/analyze"
+	pk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/pkey"
+)
+
+func TestHyperLogLogCount(t *testing.T) {
+	for _, precision := range []int{10, az.PrecisionDefault} {
+		// the standard error is 1.04 / sqrt(2^precision)
+		var tolerance float64 = 4 * 1.04 / math.Sqrt(math.Ldexp(1, precision))
+		for _, n := range []int{0, 1, 100, 10000, 200000} {
+			var h *az.HyperLogLog = az.HyperLogLogNew(precision)
+			// the duplicates are not counted
+			for range 2 {
+				for i := range n {
+					h.Add(fmt.Sprintf("key-%d", i))
+				}
+			}
+
+			var count uint64 = h.Count()
+			var diff float64 = math.Abs(float64(count) - float64(n))
+			if tolerance*float64(n) < diff || (0 == n && 0 != count) {
+				t.Fatalf("p=%v, n=%v: estimated %v", precision, n, count)
+			}
+		}
+	}
+}
+
+func TestSpaceSavingExact(t *testing.T) {
+	var s *az.SpaceSaving = az.SpaceSavingNew(4)
+	for _, key := range []string{"c", "a", "b", "a", "c", "a", "d"} {
+		s.Add(key)
+	}
+	// the counts are exact while the keys fit the counters
+	var expected []az.KeyCount = []az.KeyCount{
+		{Key: "a", Records: 3},
+		{Key: "c", Records: 2},
+		{Key: "b", Records: 1},
+	}
+	var actual []az.KeyCount = s.Top(3)
+	if !slices.Equal(expected, actual) {
+		t.Fatalf("unexpected top: %v", actual)
+	}
+}
+
+func TestSpaceSavingFrequent(t *testing.T) {
+	var s *az.SpaceSaving = az.SpaceSavingNew(20)
+	var counts map[string]int64 = map[string]int64{}
+	// 1000 records: "hot" 200, "warm" 100 and 700 keys of a record
+	for i := range 500 {
+		var key string = fmt.Sprintf("cold-%d", i)
+		switch {
+		case 0 == i%5:
+			key = "warm"
+		case 0 == i%5%2:
+			key = "hot"
+		}
+		for _, k := range []string{key, fmt.Sprintf("once-%d", i)} {
+			s.Add(k)
+			counts[k]++
+		}
+	}
+
+	// the keys above 1000/20 records are kept
+	var top []az.KeyCount = s.Top(2)
+	if 2 != len(top) || "hot" != top[0].Key || "warm" != top[1].Key {
+		t.Fatalf("unexpected top: %v", top)
+	}
+	for _, kc := range s.Top(20) {
+		var actual int64 = counts[kc.Key]
+		// the estimate overestimates by the error at most
+		if actual < kc.Records-kc.Error || kc.Records < actual {
+			t.Fatalf("%s: %v records estimated as %v", kc.Key, actual, kc)
+		}
+	}
+}
+
+func TestAnalyzerDistinct(t *testing.T) {
+	for _, exact := range []bool{true, false} {
+		a, e := az.Config{KeyField: "id", Exact: exact}.New()
+		if nil != e {
+			t.Fatal(e)
+		}
+		a.OnMetadata(map[string][]byte{"avro.schema": []byte(`{
+			"type": "record",
+			"name": "Row",
+			"fields": [{"name": "id", "type": "long"}]
+		}`)})
+
+		// the key 0 has 11 records, the others 10
+		e = a.AddAll(
+			context.Background(),
+			func(yield func(map[string]any, error) bool) {
+				for i := range 501 {
+					if !yield(map[string]any{"id": int64(i % 50)}, nil) {
+						return
+					}
+				}
+			},
+			&pk.StringKeyWriterDefault,
+		)
+		if nil != e {
+			t.Fatal(e)
+		}
+
+		var r az.Report = a.Report()
+		var hottest az.KeyCount = az.KeyCount{
+			Key:     "0000000000000000",
+			Records: 11,
+		}
+		var ok bool = 501 == r.Records &&
+			50 == r.DistinctKeys &&
+			exact == r.DistinctExact &&
+			hottest == r.TopKeys[0] &&
+			501 == r.RecordSizes.Count
+		if !ok {
+			t.Fatalf("exact=%v: unexpected report: %+v", exact, r)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package analyze
+
+import (
+	"math/bits"
+)
+
+// Bucket counts the sizes up to Le(inclusive) above the previous bucket.
+type Bucket struct {
+	Le    int64 `json:"le"`
+	Count int64 `json:"count"`
+}
+
+// Histogram counts the sizes using power of two buckets.
+type Histogram struct {
+	Count int64 `json:"count"`
+	Sum   int64 `json:"sum"`
+	Min   int64 `json:"min"`
+	Max   int64 `json:"max"`
+
+	// The non-empty buckets.
+	Buckets []Bucket `json:"buckets"`
+
+	counts [64]int64
+}
+
+func (h *Histogram) Add(size int64) {
+	var s int64 = max(0, size)
+	if 0 == h.Count || s < h.Min {
+		h.Min = s
+	}
+	h.Max = max(h.Max, s)
+	h.Count++
+	h.Sum += s
+	h.counts[bits.Len64(uint64(s))]++
+}
+
+// Finish fills the buckets.
+func (h *Histogram) Finish() {
+	h.Buckets = nil
+	for i, count := range h.counts {
+		if 0 == count {
+			continue
+		}
+		var le uint64 = 1<<uint(i) - 1
+		h.Buckets = append(h.Buckets, Bucket{Le: int64(le), Count: count})
+	}
+}
@@ -0,0 +1,77 @@
+package analyze
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	PrecisionDefault int = 14
+	PrecisionMin     int = 4
+	PrecisionMax     int = 18
+)
+
+// HyperLogLog estimates the number of the distinct strings.
+//
+// The standard error is about 1.04 / sqrt(2^precision)(0.8% by default).
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+// HyperLogLogNew creates the estimator(0: PrecisionDefault).
+func HyperLogLogNew(precision int) *HyperLogLog {
+	if 0 == precision {
+		precision = PrecisionDefault
+	}
+	var p int = min(max(precision, PrecisionMin), PrecisionMax)
+	return &HyperLogLog{
+		precision: uint8(p),
+		registers: make([]uint8, 1<<p),
+	}
+}
+
+// hash64 mixes the fnv hash(the finalizer of the splitmix64).
+func hash64(s string) uint64 {
+	var h = fnv.New64a()
+	_, _ = h.Write([]byte(s)) // error is always nil
+	var x uint64 = h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (h *HyperLogLog) Add(s string) {
+	var x uint64 = hash64(s)
+	var idx uint64 = x >> (64 - h.precision)
+	var rest uint64 = x<<h.precision | 1<<(h.precision-1)
+	var rank uint8 = uint8(bits.LeadingZeros64(rest)) + 1
+	if h.registers[idx] < rank {
+		h.registers[idx] = rank
+	}
+}
+
+// Count returns the estimated number of the distinct strings.
+func (h *HyperLogLog) Count() uint64 {
+	var m float64 = float64(len(h.registers))
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if 0 == r {
+			zeros++
+		}
+	}
+
+	var alpha float64 = 0.7213 / (1 + 1.079/m)
+	var estimate float64 = alpha * m * m / sum
+	if estimate <= 2.5*m && 0 < zeros {
+		// linear counting for the small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
@@ -0,0 +1,105 @@
+package analyze
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+)
+
+const TopNDefault int = 10
+
+// KeyCount is the number of the records of a key.
+type KeyCount struct {
+	Key     string `json:"key"`
+	Records int64  `json:"records"`
+
+	// The maximum overestimation of the records(0: exact).
+	Error int64 `json:"error,omitempty"`
+}
+
+func sortCounts(counts []KeyCount, n int) []KeyCount {
+	slices.SortFunc(counts, func(a, b KeyCount) int {
+		return cmp.Or(
+			cmp.Compare(b.Records, a.Records),
+			cmp.Compare(a.Key, b.Key),
+		)
+	})
+	return counts[:min(n, len(counts))]
+}
+
+// counters is a min heap of the counts.
+type counters struct {
+	items []*KeyCount
+	index map[string]int
+}
+
+func (c *counters) Len() int { return len(c.items) }
+
+func (c *counters) Less(i, j int) bool {
+	return c.items[i].Records < c.items[j].Records
+}
+
+func (c *counters) Swap(i, j int) {
+	c.items[i], c.items[j] = c.items[j], c.items[i]
+	c.index[c.items[i].Key] = i
+	c.index[c.items[j].Key] = j
+}
+
+func (c *counters) Push(x any) {
+	var item *KeyCount = x.(*KeyCount)
+	c.index[item.Key] = len(c.items)
+	c.items = append(c.items, item)
+}
+
+func (c *counters) Pop() any {
+	var last *KeyCount = c.items[len(c.items)-1]
+	c.items = c.items[:len(c.items)-1]
+	delete(c.index, last.Key)
+	return last
+}
+
+// SpaceSaving finds the frequent keys using the fixed number of counters.
+//
+// A key which has more records than records/capacity is always kept.
+type SpaceSaving struct {
+	capacity int
+	counters counters
+}
+
+func SpaceSavingNew(capacity int) *SpaceSaving {
+	return &SpaceSaving{
+		capacity: max(1, capacity),
+		counters: counters{index: map[string]int{}},
+	}
+}
+
+func (s *SpaceSaving) Add(key string) {
+	i, found := s.counters.index[key]
+	switch {
+	case found:
+		s.counters.items[i].Records++
+		heap.Fix(&s.counters, i)
+	case s.counters.Len() < s.capacity:
+		heap.Push(&s.counters, &KeyCount{Key: key, Records: 1})
+	default:
+		// replaces the least frequent key
+		var least *KeyCount = s.counters.items[0]
+		delete(s.counters.index, least.Key)
+		s.counters.index[key] = 0
+		*least = KeyCount{
+			Key:     key,
+			Records: least.Records + 1,
+			Error:   least.Records,
+		}
+		heap.Fix(&s.counters, 0)
+	}
+}
+
+// Top returns the n most frequent keys.
+func (s *SpaceSaving) Top(n int) []KeyCount {
+	var ret []KeyCount = make([]KeyCount, 0, s.counters.Len())
+	for _, item := range s.counters.items {
+		ret = append(ret, *item)
+	}
+	return sortCounts(ret, n)
+}
@@ -0,0 +1,282 @@
+// Package archivesink writes all partitions as entries of a tar or zip.
+package archivesink
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+var (
+	ErrUnknownFormat error = errors.New("unknown archive format")
+	ErrEntryWritten  error = errors.New("archive entry already written")
+)
+
+type Format string
+
+const (
+	FormatTar Format = "tar"
+	FormatZip Format = "zip"
+)
+
+func StringToFormat(s string) (Format, error) {
+	switch s {
+	case "tar":
+		return FormatTar, nil
+	case "zip":
+		return FormatZip, nil
+	default:
+		return FormatTar, fmt.Errorf("%w: %s", ErrUnknownFormat, s)
+	}
+}
+
+const DirModeDefault fs.FileMode = 0o755
+
+type Config struct {
+	Format
+
+	// The mode of the partition entries(zero: eh.FileModeDefault).
+	Mode fs.FileMode
+
+	// Returns the modification time of a committed partition(nil: time.Now).
+	Clock func() time.Time
+
+	// The directory of the partitions; the entry names are relative to it
+	// (empty: the names of the partitions).
+	Root string
+}
+
+// entryWriter writes the entries of the archive.
+type entryWriter interface {
+	dir(name string, mtime time.Time) error
+	file(name string, data []byte, mode fs.FileMode, mtime time.Time) error
+	Close() error
+}
+
+type tarWriter struct{ *tar.Writer }
+
+func (t tarWriter) dir(name string, mtime time.Time) error {
+	return t.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     int64(DirModeDefault),
+		ModTime:  mtime,
+	})
+}
+
+func (t tarWriter) file(
+	name string,
+	data []byte,
+	mode fs.FileMode,
+	mtime time.Time,
+) error {
+	e := t.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(mode),
+		Size:     int64(len(data)),
+		ModTime:  mtime,
+	})
+	if nil != e {
+		return e
+	}
+	_, e = t.Write(data)
+	return e
+}
+
+type zipWriter struct{ *zip.Writer }
+
+func (z zipWriter) dir(name string, mtime time.Time) error {
+	var h *zip.FileHeader = &zip.FileHeader{
+		Name:     name + "/",
+		Modified: mtime,
+	}
+	h.SetMode(fs.ModeDir | DirModeDefault)
+	_, e := z.CreateHeader(h)
+	return e
+}
+
+func (z zipWriter) file(
+	name string,
+	data []byte,
+	mode fs.FileMode,
+	mtime time.Time,
+) error {
+	var h *zip.FileHeader = &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: mtime,
+	}
+	h.SetMode(mode)
+	w, e := z.CreateHeader(h)
+	if nil != e {
+		return e
+	}
+	_, e = w.Write(data)
+	return e
+}
+
+// Sink writes each partition as an entry when the partition is committed.
+//
+// The parent directories are added as directory entries before the first
+// partition in them. The written entries can not be overwritten nor
+// reopened; the pool must keep all partitions open until they are complete.
+// Sink is safe for concurrent use.
+type Sink struct {
+	Config
+
+	w      entryWriter
+	closer func() error
+
+	mu      sync.Mutex
+	written map[string]struct{}
+	dirs    map[string]struct{}
+}
+
+// New creates a sink which writes the archive to w.
+//
+// The closer(e.g, flush and close the file) will be called after writing
+// the archive unless it is nil.
+func (c Config) New(w io.Writer, closer func() error) *Sink {
+	if 0 == c.Mode {
+		c.Mode = eh.FileModeDefault
+	}
+	if nil == c.Clock {
+		c.Clock = time.Now
+	}
+	var ew entryWriter = tarWriter{Writer: tar.NewWriter(w)}
+	if FormatZip == c.Format {
+		ew = zipWriter{Writer: zip.NewWriter(w)}
+	}
+	return &Sink{
+		Config:  c,
+		w:       ew,
+		closer:  closer,
+		written: map[string]struct{}{},
+		dirs:    map[string]struct{}{},
+	}
+}
+
+// Create creates a sink which writes the archive to the file(empty or "-":
+// the stdout).
+func (c Config) Create(filename string) (*Sink, error) {
+	if "" == filename || "-" == filename {
+		var bw *bufio.Writer = bufio.NewWriter(os.Stdout)
+		return c.New(bw, bw.Flush), nil
+	}
+
+	file, e := os.Create(filename)
+	if nil != e {
+		return nil, e
+	}
+	var bw *bufio.Writer = bufio.NewWriter(file)
+	return c.New(bw, func() error {
+		return errors.Join(bw.Flush(), file.Close())
+	}), nil
+}
+
+// EntryName converts the partition path to the slash-separated name
+// relative to the root(empty: relative to the filesystem root).
+func EntryName(root string, filename string) string {
+	if "" != root {
+		rel, e := filepath.Rel(root, filename)
+		if nil == e && filepath.IsLocal(rel) {
+			filename = rel
+		}
+	}
+	var slashed string = path.Clean(strings.ReplaceAll(filename, "\\", "/"))
+	return strings.TrimPrefix(strings.TrimPrefix(slashed, "/"), "./")
+}
+
+type archivePartition struct {
+	eh.MemFile
+	sink *Sink
+	name string
+}
+
+func (a *archivePartition) Name() string  { return a.name }
+func (a *archivePartition) Commit() error { return a.sink.write(a.name, a.Data) }
+func (a *archivePartition) Abort() error  { return nil }
+
+// parents returns the parent directories of the name(the outermost first).
+func parents(name string) []string {
+	var ret []string
+	for dir := path.Dir(name); "." != dir && "/" != dir; dir = path.Dir(dir) {
+		ret = append(ret, dir)
+	}
+	slices.Reverse(ret)
+	return ret
+}
+
+// write adds the entry of the committed partition.
+func (s *Sink) write(filename string, data []byte) error {
+	var name string = EntryName(s.Root, filename)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, found := s.written[name]
+	if found {
+		return fmt.Errorf("%w: %s", ErrEntryWritten, name)
+	}
+
+	var mtime time.Time = s.Clock()
+	for _, dir := range parents(name) {
+		_, found := s.dirs[dir]
+		if found {
+			continue
+		}
+		e := s.w.dir(dir, mtime)
+		if nil != e {
+			return e
+		}
+		s.dirs[dir] = struct{}{}
+	}
+
+	s.written[name] = struct{}{}
+	return s.w.file(name, data, s.Mode, mtime)
+}
+
+// Create fails if the entry was written.
+func (s *Sink) Create(path string) (eh.PartitionWriter, error) {
+	var name string = EntryName(s.Root, path)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, found := s.written[name]
+	if found {
+		return nil, fmt.Errorf("%w: %s", ErrEntryWritten, name)
+	}
+	return &archivePartition{sink: s, name: path}, nil
+}
+
+// Reopen always fails; the written entries can not be changed.
+func (s *Sink) Reopen(name string) (eh.PartitionWriter, error) {
+	return nil, fmt.Errorf("%w: %s", ErrEntryWritten, EntryName(s.Root, name))
+}
+
+// Close finishes the archive and calls the closer.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var e error = s.w.Close()
+	if nil != s.closer {
+		e = errors.Join(e, s.closer())
+	}
+	return e
+}
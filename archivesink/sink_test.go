@@ -0,0 +1,126 @@
+package archivesink_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	as "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/archivesink"
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+const root string = "/tmp/out"
+
+func put(t *testing.T, sink eh.Sink, name string, data string) {
+	t.Helper()
+	w, e := sink.Create(filepath.Join(root, name))
+	if nil != e {
+		t.Fatal(e)
+	}
+	_, e = w.Write([]byte(data))
+	if nil != e {
+		t.Fatal(e)
+	}
+	e = w.Commit()
+	if nil != e {
+		t.Fatal(e)
+	}
+}
+
+func newSink(f as.Format, buf *bytes.Buffer) *as.Sink {
+	return as.Config{
+		Format: f,
+		Root:   root,
+		Clock:  func() time.Time { return time.Unix(0, 0) },
+	}.New(buf, nil)
+}
+
+func TestSinkTar(t *testing.T) {
+	var buf bytes.Buffer
+	var sink *as.Sink = newSink(as.FormatTar, &buf)
+
+	put(t, sink, "ab/k.avro", "k")
+	// the committed entry is written before the Close
+	if 0 == buf.Len() {
+		t.Fatal("entry not written")
+	}
+	put(t, sink, "ab/l.avro", "l")
+	e := sink.Close()
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	var names []string
+	var tr *tar.Reader = tar.NewReader(&buf)
+	for {
+		h, e := tr.Next()
+		if io.EOF == e {
+			break
+		}
+		if nil != e {
+			t.Fatal(e)
+		}
+		names = append(names, h.Name)
+	}
+	var expected []string = []string{"ab/", "ab/k.avro", "ab/l.avro"}
+	if len(expected) != len(names) {
+		t.Fatalf("unexpected entries: %v", names)
+	}
+	for i, name := range expected {
+		if name != names[i] {
+			t.Fatalf("unexpected entries: %v", names)
+		}
+	}
+}
+
+func TestSinkZip(t *testing.T) {
+	var buf bytes.Buffer
+	var sink *as.Sink = newSink(as.FormatZip, &buf)
+	put(t, sink, "k.avro", "data")
+	e := sink.Close()
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	zr, e := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if nil != e {
+		t.Fatal(e)
+	}
+	if 1 != len(zr.File) || "k.avro" != zr.File[0].Name {
+		t.Fatalf("unexpected entries: %v", zr.File)
+	}
+}
+
+func TestSinkWritten(t *testing.T) {
+	var buf bytes.Buffer
+	var sink *as.Sink = newSink(as.FormatTar, &buf)
+	put(t, sink, "k.avro", "k")
+
+	var name string = filepath.Join(root, "k.avro")
+	_, e := sink.Create(name)
+	if !errors.Is(e, as.ErrEntryWritten) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	_, e = sink.Reopen(name)
+	if !errors.Is(e, as.ErrEntryWritten) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+}
+
+func TestEntryName(t *testing.T) {
+	var cases = map[string]string{
+		filepath.Join(root, "ab", "k.avro"): "ab/k.avro",
+		"/elsewhere/k.avro":                 "elsewhere/k.avro",
+		"./k.avro":                          "k.avro",
+	}
+	for filename, expected := range cases {
+		if expected != as.EntryName(root, filename) {
+			t.Errorf("%s: %s", filename, as.EntryName(root, filename))
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package dec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"math/big"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+
+	ha "github.com/hamba/avro/v2"
+)
+
+var ErrJsonValue error = errors.New("invalid avro json value")
+
+func jsonValueErr(s ha.Schema, v any) error {
+	return fmt.Errorf("%w: %s(%T)", ErrJsonValue, s.Type(), v)
+}
+
+func logicalType(s ha.Schema) ha.LogicalType {
+	ls, ok := s.(ha.LogicalTypeSchema)
+	if !ok || nil == ls.Logical() {
+		return ""
+	}
+	return ls.Logical().Type()
+}
+
+func jsonInt(s ha.Schema, v any, bits int) (int64, error) {
+	num, ok := v.(json.Number)
+	if !ok {
+		return 0, jsonValueErr(s, v)
+	}
+	i, e := strconv.ParseInt(string(num), 10, bits)
+	if nil != e {
+		return 0, fmt.Errorf("%w: %w", ErrJsonValue, e)
+	}
+	return i, nil
+}
+
+func jsonFloat(s ha.Schema, v any, bits int) (float64, error) {
+	switch t := v.(type) {
+	case json.Number:
+		f, e := strconv.ParseFloat(string(t), bits)
+		if nil != e {
+			return 0, fmt.Errorf("%w: %w", ErrJsonValue, e)
+		}
+		return f, nil
+	case string:
+		switch t {
+		case "NaN":
+			return math.NaN(), nil
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		default:
+			return 0, jsonValueErr(s, v)
+		}
+	default:
+		return 0, jsonValueErr(s, v)
+	}
+}
+
+// jsonBytes converts the string(one code point per byte) to the bytes.
+func jsonBytes(s ha.Schema, v any) ([]byte, error) {
+	str, ok := v.(string)
+	if !ok {
+		return nil, jsonValueErr(s, v)
+	}
+	var b []byte = make([]byte, 0, len(str))
+	for _, r := range str {
+		if 0xff < r {
+			return nil, jsonValueErr(s, v)
+		}
+		b = append(b, byte(r))
+	}
+	return b, nil
+}
+
+func decimalScale(s ha.Schema) int {
+	ls, _ := s.(ha.LogicalTypeSchema)
+	dec, _ := ls.Logical().(*ha.DecimalLogicalSchema)
+	if nil == dec {
+		return 0
+	}
+	return dec.Scale()
+}
+
+// UnscaledToRat converts the two's complement big-endian bytes of the
+// unscaled value to the decimal.
+func UnscaledToRat(b []byte, scale int) *big.Rat {
+	var i *big.Int = new(big.Int).SetBytes(b)
+	if 0 < len(b) && 0x80 <= b[0] {
+		i.Sub(i, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	var denom *big.Int = new(big.Int).Exp(
+		big.NewInt(10),
+		big.NewInt(int64(scale)),
+		nil,
+	)
+	return new(big.Rat).SetFrac(i, denom)
+}
+
+func jsonToInt(s ha.Schema, v any) (any, error) {
+	i, e := jsonInt(s, v, 32)
+	if nil != e {
+		return nil, e
+	}
+	switch logicalType(s) {
+	case ha.Date:
+		return time.Unix(i*86400, 0).UTC(), nil
+	case ha.TimeMillis:
+		return time.Duration(i) * time.Millisecond, nil
+	default:
+		return int(i), nil
+	}
+}
+
+func jsonToLong(s ha.Schema, v any) (any, error) {
+	i, e := jsonInt(s, v, 64)
+	if nil != e {
+		return nil, e
+	}
+	switch logicalType(s) {
+	case ha.TimeMicros:
+		return time.Duration(i) * time.Microsecond, nil
+	case ha.TimestampMillis, ha.LocalTimestampMillis:
+		return time.UnixMilli(i).UTC(), nil
+	case ha.TimestampMicros, ha.LocalTimestampMicros:
+		return time.UnixMicro(i).UTC(), nil
+	default:
+		return i, nil
+	}
+}
+
+func jsonToFixed(s *ha.FixedSchema, v any) (any, error) {
+	b, e := jsonBytes(s, v)
+	if nil != e {
+		return nil, e
+	}
+	if len(b) != s.Size() {
+		return nil, jsonValueErr(s, v)
+	}
+	switch logicalType(s) {
+	case ha.Decimal:
+		return UnscaledToRat(b, decimalScale(s)), nil
+	case ha.Duration:
+		return ha.LogicalDuration{
+			Months:       uint32Le(b[0:4]),
+			Days:         uint32Le(b[4:8]),
+			Milliseconds: uint32Le(b[8:12]),
+		}, nil
+	default:
+		var arr reflect.Value = reflect.New(
+			reflect.ArrayOf(s.Size(), reflect.TypeFor[byte]()),
+		).Elem()
+		reflect.Copy(arr, reflect.ValueOf(b))
+		return arr.Interface(), nil
+	}
+}
+
+func jsonToRecord(s *ha.RecordSchema, v any) (any, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, jsonValueErr(s, v)
+	}
+	var ret map[string]any = make(map[string]any, len(s.Fields()))
+	for _, field := range s.Fields() {
+		val, found := m[field.Name()]
+		if !found && field.HasDefault() {
+			ret[field.Name()] = field.Default()
+			continue
+		}
+		converted, e := JsonToValue(field.Type(), val)
+		if nil != e {
+			return nil, fmt.Errorf("%s: %w", field.Name(), e)
+		}
+		ret[field.Name()] = converted
+	}
+	return ret, nil
+}
+
+// unionBranchName returns the name of the branch in the Avro JSON encoding.
+func unionBranchName(s ha.Schema) string {
+	if ref, isRef := s.(*ha.RefSchema); isRef {
+		s = ref.Schema()
+	}
+	named, isNamed := s.(ha.NamedSchema)
+	if isNamed {
+		return named.FullName()
+	}
+	return string(s.Type())
+}
+
+// unwrappedBranch is true if the binary decoder returns the value of the
+// branch as it is(not as a map of the name and the value).
+func unwrappedBranch(s ha.Schema) bool {
+	switch s.Type() {
+	case ha.Boolean, ha.Float, ha.Double:
+		return true
+	case ha.Int:
+		switch logicalType(s) {
+		case "", ha.Date, ha.TimeMillis:
+			return true
+		}
+	case ha.Long:
+		switch logicalType(s) {
+		case "", ha.TimeMicros, ha.TimestampMillis, ha.TimestampMicros:
+			return true
+		}
+	case ha.String:
+		switch logicalType(s) {
+		case "", ha.UUID:
+			return true
+		}
+	case ha.Bytes:
+		switch logicalType(s) {
+		case "", ha.Decimal:
+			return true
+		}
+	}
+	return false
+}
+
+// wrappedName returns the key of the branch of the binary decoder.
+func wrappedName(s ha.Schema) string {
+	var name string = unionBranchName(s)
+	if _, isNamed := s.(ha.NamedSchema); isNamed {
+		return name
+	}
+	if _, isRef := s.(*ha.RefSchema); isRef {
+		return name
+	}
+	var lt ha.LogicalType = logicalType(s)
+	if "" != lt {
+		name += "." + string(lt)
+	}
+	return name
+}
+
+func jsonToUnion(s *ha.UnionSchema, v any) (any, error) {
+	if nil == v {
+		var nullable bool = slices.ContainsFunc(
+			s.Types(),
+			func(b ha.Schema) bool { return ha.Null == b.Type() },
+		)
+		if nullable {
+			return nil, nil
+		}
+		return nil, jsonValueErr(s, v)
+	}
+
+	m, ok := v.(map[string]any)
+	if !ok || 1 != len(m) {
+		return nil, jsonValueErr(s, v)
+	}
+	for _, branch := range s.Types() {
+		val, found := m[unionBranchName(branch)]
+		if !found {
+			continue
+		}
+		converted, e := JsonToValue(branch, val)
+		if nil != e {
+			return nil, e
+		}
+		if unwrappedBranch(branch) {
+			return converted, nil
+		}
+		return map[string]any{wrappedName(branch): converted}, nil
+	}
+	return nil, jsonValueErr(s, v)
+}
+
+// JsonToValue converts the json value(decoded using json.Number) to the
+// value of the binary decoder(e.g, map[string]any for records, []byte for
+// bytes).
+func JsonToValue(s ha.Schema, v any) (any, error) {
+	switch t := s.(type) {
+	case *ha.RefSchema:
+		return JsonToValue(t.Schema(), v)
+	case *ha.RecordSchema:
+		return jsonToRecord(t, v)
+	case *ha.UnionSchema:
+		return jsonToUnion(t, v)
+	case *ha.FixedSchema:
+		return jsonToFixed(t, v)
+	case *ha.ArraySchema:
+		arr, ok := v.([]any)
+		if !ok {
+			return nil, jsonValueErr(s, v)
+		}
+		var ret []any = make([]any, 0, len(arr))
+		for _, item := range arr {
+			converted, e := JsonToValue(t.Items(), item)
+			if nil != e {
+				return nil, e
+			}
+			ret = append(ret, converted)
+		}
+		return ret, nil
+	case *ha.MapSchema:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, jsonValueErr(s, v)
+		}
+		var ret map[string]any = make(map[string]any, len(m))
+		for key, val := range m {
+			converted, e := JsonToValue(t.Values(), val)
+			if nil != e {
+				return nil, e
+			}
+			ret[key] = converted
+		}
+		return ret, nil
+	case *ha.EnumSchema:
+		str, ok := v.(string)
+		if !ok || !slices.Contains(t.Symbols(), str) {
+			return nil, jsonValueErr(s, v)
+		}
+		return str, nil
+	}
+
+	switch s.Type() {
+	case ha.Null:
+		if nil != v {
+			return nil, jsonValueErr(s, v)
+		}
+		return nil, nil
+	case ha.Boolean:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, jsonValueErr(s, v)
+		}
+		return b, nil
+	case ha.Int:
+		return jsonToInt(s, v)
+	case ha.Long:
+		return jsonToLong(s, v)
+	case ha.Float:
+		f, e := jsonFloat(s, v, 32)
+		return float32(f), e
+	case ha.Double:
+		return jsonFloat(s, v, 64)
+	case ha.String:
+		str, ok := v.(string)
+		if !ok {
+			return nil, jsonValueErr(s, v)
+		}
+		return str, nil
+	case ha.Bytes:
+		b, e := jsonBytes(s, v)
+		if nil != e || ha.Decimal != logicalType(s) {
+			return b, e
+		}
+		return UnscaledToRat(b, decimalScale(s)), nil
+	default:
+		return nil, jsonValueErr(s, v)
+	}
+}
+
+func uint32Le(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// JsonToMaps decodes the Avro JSON encoded records(e.g, one per line).
+func JsonToMaps(
+	r io.Reader,
+	s ha.Schema,
+) iter.Seq2[map[string]any, error] {
+	return func(yield func(map[string]any, error) bool) {
+		var dec *json.Decoder = json.NewDecoder(r)
+		dec.UseNumber()
+		for {
+			var raw any
+			e := dec.Decode(&raw)
+			if errors.Is(e, io.EOF) {
+				return
+			}
+			if nil != e {
+				yield(nil, e)
+				return
+			}
+
+			converted, e := JsonToValue(s, raw)
+			if nil != e {
+				yield(nil, e)
+				return
+			}
+			m, ok := converted.(map[string]any)
+			if !ok {
+				yield(nil, jsonValueErr(s, converted))
+				return
+			}
+			if !yield(m, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package dec
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+
+	ho "github.com/hamba/avro/v2/ocf"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+)
+
+// BlockDecoder decompresses the data blocks of an object container file.
+type BlockDecoder interface {
+	Decode(compressed []byte) ([]byte, error)
+}
+
+type NullDecoder struct{}
+
+func (NullDecoder) Decode(raw []byte) ([]byte, error) { return raw, nil }
+
+type SnappyDecoder struct{}
+
+func (SnappyDecoder) Decode(compressed []byte) ([]byte, error) {
+	var codec ho.SnappyCodec
+	return codec.Decode(compressed)
+}
+
+// StreamDecoder decompresses blocks using a stream reader.
+type StreamDecoder func(io.Reader) (io.Reader, error)
+
+func (s StreamDecoder) Decode(compressed []byte) ([]byte, error) {
+	rdr, e := s(bytes.NewReader(compressed))
+	if nil != e {
+		return nil, e
+	}
+	return io.ReadAll(rdr)
+}
+
+var DeflateDecoder StreamDecoder = func(r io.Reader) (io.Reader, error) {
+	return flate.NewReader(r), nil
+}
+
+var Bzip2Decoder StreamDecoder = func(r io.Reader) (io.Reader, error) {
+	return bzip2.NewReader(r, nil)
+}
+
+var XzDecoder StreamDecoder = func(r io.Reader) (io.Reader, error) {
+	return xz.NewReader(r)
+}
+
+// zstdDecoder is shared by all the files; DecodeAll is safe for concurrent
+// use.
+var zstdDecoder func() (*zstd.Decoder, error) = sync.OnceValues(
+	func() (*zstd.Decoder, error) { return zstd.NewReader(nil) },
+)
+
+type ZstdDecoder struct{}
+
+func (ZstdDecoder) Decode(compressed []byte) ([]byte, error) {
+	dec, e := zstdDecoder()
+	if nil != e {
+		return nil, e
+	}
+	return dec.DecodeAll(compressed, nil)
+}
+
+// CodecToDecoder returns the decoder of the codec.
+func CodecToDecoder(c bp.Codec) (BlockDecoder, error) {
+	switch c {
+	case bp.CodecNull, "":
+		return NullDecoder{}, nil
+	case bp.CodecDeflate:
+		return DeflateDecoder, nil
+	case bp.CodecSnappy:
+		return SnappyDecoder{}, nil
+	case bp.CodecZstd:
+		return ZstdDecoder{}, nil
+	case bp.CodecBzip2:
+		return Bzip2Decoder, nil
+	case bp.CodecXz:
+		return XzDecoder, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", bp.ErrUnknownCodec, c)
+	}
+}
@@ -0,0 +1,130 @@
+package dec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+
+	ha "github.com/hamba/avro/v2"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+
+	ec "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/encryption"
+	ss "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/schemastore"
+)
+
+// errReader keeps the error which the decoder may not report(e.g, a
+// truncated encrypted stream).
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if nil != err && !errors.Is(err, io.EOF) && nil == e.err {
+		e.err = err
+	}
+	return n, err
+}
+
+// MapsDecoder decodes the records of the input.
+type MapsDecoder func(io.Reader) iter.Seq2[map[string]any, error]
+
+// DecryptWith decodes the input which may be encrypted using the decoder.
+func DecryptWith(
+	rdr io.Reader,
+	ring ec.Keyring,
+	decode MapsDecoder,
+) iter.Seq2[map[string]any, error] {
+	return func(yield func(map[string]any, error) bool) {
+		decrypted, e := ring.MaybeDecrypt(rdr)
+		if nil != e {
+			yield(nil, e)
+			return
+		}
+		var er *errReader = &errReader{r: decrypted}
+		for row, e := range decode(er) {
+			if nil != e && nil != er.err {
+				e = fmt.Errorf("%w: %w", er.err, e)
+			}
+			if !yield(row, e) {
+				return
+			}
+		}
+	}
+}
+
+// DecryptToMapsMeta decodes the OCF which may be encrypted.
+func DecryptToMapsMeta(
+	rdr io.Reader,
+	ring ec.Keyring,
+	cfg bp.DecodeConfig,
+	onMeta MetadataHandler,
+) iter.Seq2[map[string]any, error] {
+	return DecryptWith(
+		rdr,
+		ring,
+		func(r io.Reader) iter.Seq2[map[string]any, error] {
+			return ReaderToMapsMeta(r, cfg, onMeta)
+		},
+	)
+}
+
+// DecryptToMaps decodes the OCF which may be encrypted.
+func DecryptToMaps(
+	rdr io.Reader,
+	ring ec.Keyring,
+	cfg bp.DecodeConfig,
+) iter.Seq2[map[string]any, error] {
+	return DecryptToMapsMeta(rdr, ring, cfg, MetadataIgnore)
+}
+
+// DecryptRawToMaps decodes the raw datums which may be encrypted.
+func DecryptRawToMaps(
+	rdr io.Reader,
+	ring ec.Keyring,
+	s ha.Schema,
+	cfg bp.DecodeConfig,
+) iter.Seq2[map[string]any, error] {
+	return DecryptWith(
+		rdr,
+		ring,
+		func(r io.Reader) iter.Seq2[map[string]any, error] {
+			return RawToMaps(r, s, cfg)
+		},
+	)
+}
+
+// DecryptSingleObjectsToMaps decodes the single objects which may be
+// encrypted.
+func DecryptSingleObjectsToMaps(
+	rdr io.Reader,
+	ring ec.Keyring,
+	resolver ss.Resolver,
+	cfg bp.DecodeConfig,
+) iter.Seq2[map[string]any, error] {
+	return DecryptWith(
+		rdr,
+		ring,
+		func(r io.Reader) iter.Seq2[map[string]any, error] {
+			return SingleObjectsToMaps(r, resolver, cfg)
+		},
+	)
+}
+
+// DecryptJsonToMaps decodes the json records which may be encrypted.
+func DecryptJsonToMaps(
+	rdr io.Reader,
+	ring ec.Keyring,
+	s ha.Schema,
+) iter.Seq2[map[string]any, error] {
+	return DecryptWith(
+		rdr,
+		ring,
+		func(r io.Reader) iter.Seq2[map[string]any, error] {
+			return JsonToMaps(r, s)
+		},
+	)
+}
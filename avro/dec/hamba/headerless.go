@@ -0,0 +1,176 @@
+package dec
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"iter"
+
+	ha "github.com/hamba/avro/v2"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+
+	ss "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/schemastore"
+)
+
+var ErrInvalidSingleObject error = errors.New("invalid single object")
+
+const readerBufSizeDefault int = 4096
+
+func ConfigToReaderOpts(cfg bp.DecodeConfig) []ha.ReaderFunc {
+	var hcfg ha.Config
+	hcfg.MaxByteSliceSize = cfg.BlobSizeMax
+	return []ha.ReaderFunc{ha.WithReaderConfig(hcfg.Freeze())}
+}
+
+// hasNext returns false on the end of the input.
+func hasNext(rdr *ha.Reader) bool {
+	_ = rdr.Peek()
+	return !errors.Is(rdr.Error, io.EOF)
+}
+
+// datumsToMaps decodes the datums until the end of the input.
+//
+// The schema of each datum is got by the function.
+func datumsToMaps(
+	rdr *ha.Reader,
+	schema func(*ha.Reader) (ha.Schema, error),
+) iter.Seq2[map[string]any, error] {
+	return func(yield func(map[string]any, error) bool) {
+		buf := map[string]any{}
+
+		for hasNext(rdr) {
+			if nil != rdr.Error {
+				yield(buf, rdr.Error)
+				return
+			}
+
+			clear(buf)
+
+			s, e := schema(rdr)
+			if nil != e {
+				yield(buf, e)
+				return
+			}
+
+			rdr.ReadVal(s, &buf)
+			if !yield(buf, rdr.Error) || nil != rdr.Error {
+				return
+			}
+		}
+	}
+}
+
+// RawToMaps decodes the concatenated datums written using the schema.
+func RawToMaps(
+	r io.Reader,
+	s ha.Schema,
+	cfg bp.DecodeConfig,
+) iter.Seq2[map[string]any, error] {
+	var rdr *ha.Reader = ha.NewReader(
+		r,
+		readerBufSizeDefault,
+		ConfigToReaderOpts(cfg)...,
+	)
+	return datumsToMaps(rdr, func(_ *ha.Reader) (ha.Schema, error) {
+		return s, nil
+	})
+}
+
+// SingleObjectsToMaps decodes the single object encoded datums.
+//
+// The schema of each datum will be resolved by its fingerprint.
+func SingleObjectsToMaps(
+	r io.Reader,
+	resolver ss.Resolver,
+	cfg bp.DecodeConfig,
+) iter.Seq2[map[string]any, error] {
+	var rdr *ha.Reader = ha.NewReader(
+		r,
+		readerBufSizeDefault,
+		ConfigToReaderOpts(cfg)...,
+	)
+	var header [10]byte
+	return datumsToMaps(rdr, func(rdr *ha.Reader) (ha.Schema, error) {
+		rdr.Read(header[:])
+		if nil != rdr.Error {
+			return nil, rdr.Error
+		}
+		if ss.SingleObjectMarker != [2]byte(header[:2]) {
+			return nil, ErrInvalidSingleObject
+		}
+		var fp uint64 = binary.LittleEndian.Uint64(header[2:])
+		return resolver(ss.Fingerprint(fp))
+	})
+}
+
+// currentSchema gets the current schema of the store.
+func currentSchema(store ss.Store) (ha.Schema, error) {
+	fp, e := store.Current()
+	if nil != e {
+		return nil, e
+	}
+	return store.Get(fp)
+}
+
+func errToMaps(e error) iter.Seq2[map[string]any, error] {
+	return func(yield func(map[string]any, error) bool) {
+		yield(nil, e)
+	}
+}
+
+// CurrentRawToMaps decodes the raw datums using the current schema of the
+// store.
+func CurrentRawToMaps(
+	r io.Reader,
+	store ss.Store,
+	cfg bp.DecodeConfig,
+) iter.Seq2[map[string]any, error] {
+	s, e := currentSchema(store)
+	if nil != e {
+		return errToMaps(e)
+	}
+	return RawToMaps(r, s, cfg)
+}
+
+// CurrentJsonToMaps decodes the json records using the current schema of
+// the store.
+func CurrentJsonToMaps(
+	r io.Reader,
+	store ss.Store,
+) iter.Seq2[map[string]any, error] {
+	s, e := currentSchema(store)
+	if nil != e {
+		return errToMaps(e)
+	}
+	return JsonToMaps(r, s)
+}
+
+// PartitionRawToMaps decodes the raw datums using the schema of the
+// partition file in the store.
+func PartitionRawToMaps(
+	r io.Reader,
+	store ss.Store,
+	partition string,
+	cfg bp.DecodeConfig,
+) iter.Seq2[map[string]any, error] {
+	s, e := store.PartitionSchema(partition)
+	if nil != e {
+		return errToMaps(e)
+	}
+	return RawToMaps(r, s, cfg)
+}
+
+// PartitionJsonToMaps decodes the json records using the schema of the
+// partition file in the store.
+func PartitionJsonToMaps(
+	r io.Reader,
+	store ss.Store,
+	partition string,
+) iter.Seq2[map[string]any, error] {
+	s, e := store.PartitionSchema(partition)
+	if nil != e {
+		return errToMaps(e)
+	}
+	return JsonToMaps(r, s)
+}
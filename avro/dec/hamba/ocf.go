@@ -0,0 +1,146 @@
+package dec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	ha "github.com/hamba/avro/v2"
+	ho "github.com/hamba/avro/v2/ocf"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+)
+
+const (
+	OcfSchemaKey string = "avro.schema"
+	OcfCodecKey  string = "avro.codec"
+)
+
+var OcfMagic [4]byte = [4]byte{'O', 'b', 'j', 1}
+
+var (
+	ErrInvalidOcf   error = errors.New("invalid object container file")
+	ErrInvalidBlock error = errors.New("invalid block")
+)
+
+// OcfDecoder reads an object container file.
+//
+// Unlike ocf.Decoder, all the codecs of the encoder are supported(e.g,
+// bzip2, xz) and a truncated file is an error.
+type OcfDecoder struct {
+	rdr   *ha.Reader
+	block *ha.Reader
+
+	meta   map[string][]byte
+	schema ha.Schema
+	codec  BlockDecoder
+	sync   [16]byte
+
+	// the number of the records left in the block
+	count int64
+	err   error
+}
+
+// OcfDecoderNew reads the header of the file.
+func OcfDecoderNew(r io.Reader, cfg bp.DecodeConfig) (*OcfDecoder, error) {
+	var opts []ha.ReaderFunc = ConfigToReaderOpts(cfg)
+	var rdr *ha.Reader = ha.NewReader(r, readerBufSizeDefault, opts...)
+
+	var h ho.Header
+	rdr.ReadVal(ho.HeaderSchema, &h)
+	if nil != rdr.Error {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidOcf, rdr.Error)
+	}
+	if OcfMagic != h.Magic {
+		return nil, ErrInvalidOcf
+	}
+
+	schema, e := ha.Parse(string(h.Meta[OcfSchemaKey]))
+	if nil != e {
+		return nil, e
+	}
+
+	codec, e := CodecToDecoder(bp.Codec(h.Meta[OcfCodecKey]))
+	if nil != e {
+		return nil, e
+	}
+
+	return &OcfDecoder{
+		rdr:    rdr,
+		block:  ha.NewReader(nil, 0, opts...),
+		meta:   h.Meta,
+		schema: schema,
+		codec:  codec,
+		sync:   h.Sync,
+	}, nil
+}
+
+func (d *OcfDecoder) Metadata() map[string][]byte { return d.meta }
+
+func (d *OcfDecoder) Schema() ha.Schema { return d.schema }
+
+// Error returns the error which stopped the decoder(nil on the end).
+func (d *OcfDecoder) Error() error { return d.err }
+
+func unexpectedEOF(e error) error {
+	if errors.Is(e, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return e
+}
+
+func (d *OcfDecoder) readBlock() error {
+	var count int64 = d.rdr.ReadLong()
+	var size int64 = d.rdr.ReadLong()
+	if nil != d.rdr.Error {
+		return unexpectedEOF(d.rdr.Error)
+	}
+	if count < 0 || size < 0 {
+		return ErrInvalidBlock
+	}
+
+	var compressed []byte = make([]byte, size)
+	d.rdr.Read(compressed)
+
+	var sync [16]byte
+	d.rdr.Read(sync[:])
+	if nil != d.rdr.Error {
+		return unexpectedEOF(d.rdr.Error)
+	}
+	if d.sync != sync {
+		return ErrInvalidBlock
+	}
+
+	data, e := d.codec.Decode(compressed)
+	if nil != e {
+		return e
+	}
+	d.block.Reset(data)
+	d.count = count
+	return nil
+}
+
+// HasNext reads the next block if required.
+func (d *OcfDecoder) HasNext() bool {
+	for nil == d.err && d.count <= 0 {
+		_ = d.rdr.Peek()
+		if errors.Is(d.rdr.Error, io.EOF) {
+			return false
+		}
+		d.err = d.readBlock()
+	}
+	return nil == d.err
+}
+
+// Decode decodes the next record; HasNext must be called before.
+func (d *OcfDecoder) Decode(v any) error {
+	if d.count <= 0 {
+		return ErrInvalidBlock
+	}
+	d.count--
+	d.block.ReadVal(d.schema, v)
+	if nil != d.block.Error {
+		d.err = unexpectedEOF(d.block.Error)
+	}
+	return d.err
+}
@@ -12,9 +12,24 @@ import (
 	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
 )
 
+// MetadataHandler receives the header metadata of the input.
+type MetadataHandler func(map[string][]byte)
+
+func MetadataIgnore(_ map[string][]byte) {}
+
 func ReaderToMapsHamba(
 	rdr io.Reader,
 	opts ...ho.DecoderFunc,
+) iter.Seq2[map[string]any, error] {
+	return ReaderToMapsHambaMeta(rdr, MetadataIgnore, opts...)
+}
+
+// ReaderToMapsHambaMeta passes the metadata to the handler before the
+// first record.
+func ReaderToMapsHambaMeta(
+	rdr io.Reader,
+	onMeta MetadataHandler,
+	opts ...ho.DecoderFunc,
 ) iter.Seq2[map[string]any, error] {
 	return func(yield func(map[string]any, error) bool) {
 		buf := map[string]any{}
@@ -26,6 +41,8 @@ func ReaderToMapsHamba(
 			return
 		}
 
+		onMeta(dec.Metadata())
+
 		for dec.HasNext() {
 			clear(buf)
 
@@ -47,12 +64,53 @@ func ConfigToOpts(cfg bp.DecodeConfig) []ho.DecoderFunc {
 	}
 }
 
+// ReaderToMaps decodes the object container file using OcfDecoder.
 func ReaderToMaps(
 	rdr io.Reader,
 	cfg bp.DecodeConfig,
 ) iter.Seq2[map[string]any, error] {
-	var opts []ho.DecoderFunc = ConfigToOpts(cfg)
-	return ReaderToMapsHamba(rdr, opts...)
+	return ReaderToMapsMeta(rdr, cfg, MetadataIgnore)
+}
+
+// ReaderToMapsMeta decodes the object container file using OcfDecoder and
+// passes the metadata to the handler before the first record.
+func ReaderToMapsMeta(
+	rdr io.Reader,
+	cfg bp.DecodeConfig,
+	onMeta MetadataHandler,
+) iter.Seq2[map[string]any, error] {
+	return func(yield func(map[string]any, error) bool) {
+		buf := map[string]any{}
+
+		dec, e := OcfDecoderNew(bufio.NewReader(rdr), cfg)
+		if nil != e {
+			yield(buf, e)
+			return
+		}
+
+		onMeta(dec.Metadata())
+
+		for dec.HasNext() {
+			clear(buf)
+
+			e := dec.Decode(&buf)
+			if !yield(buf, e) || nil != e {
+				return
+			}
+		}
+
+		e = dec.Error()
+		if nil != e {
+			yield(buf, e)
+		}
+	}
+}
+
+func StdinToMapsMeta(
+	cfg bp.DecodeConfig,
+	onMeta MetadataHandler,
+) iter.Seq2[map[string]any, error] {
+	return ReaderToMapsMeta(os.Stdin, cfg, onMeta)
 }
 
 func StdinToMaps(
@@ -0,0 +1,396 @@
+package enc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"math/big"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	ha "github.com/hamba/avro/v2"
+)
+
+var ErrJsonType error = errors.New("unexpected value for the schema")
+
+func jsonTypeErr(s ha.Schema, v any) error {
+	return fmt.Errorf("%w: %s(%T)", ErrJsonType, s.Type(), v)
+}
+
+func logicalType(s ha.Schema) ha.LogicalType {
+	ls, ok := s.(ha.LogicalTypeSchema)
+	if !ok || nil == ls.Logical() {
+		return ""
+	}
+	return ls.Logical().Type()
+}
+
+func toInt64(v any) (int64, bool) {
+	switch t := v.(type) {
+	case int:
+		return int64(t), true
+	case int8:
+		return int64(t), true
+	case int16:
+		return int64(t), true
+	case int32:
+		return int64(t), true
+	case int64:
+		return t, true
+	default:
+		return 0, false
+	}
+}
+
+// appendJsonBytes appends the bytes as a json string(one code point/byte).
+func appendJsonBytes(buf []byte, b []byte) ([]byte, error) {
+	var runes []byte = make([]byte, 0, len(b))
+	for _, c := range b {
+		runes = utf8.AppendRune(runes, rune(c))
+	}
+	encoded, e := json.Marshal(string(runes))
+	return append(buf, encoded...), e
+}
+
+// RatToUnscaled converts the decimal to the two's complement big-endian
+// bytes of the unscaled value.
+func RatToUnscaled(r *big.Rat, scale int) []byte {
+	var scaled *big.Rat = new(big.Rat).Mul(
+		r,
+		new(big.Rat).SetInt(new(big.Int).Exp(
+			big.NewInt(10),
+			big.NewInt(int64(scale)),
+			nil,
+		)),
+	)
+	var i *big.Int = new(big.Int).Quo(scaled.Num(), scaled.Denom())
+	if 0 <= i.Sign() {
+		var b []byte = i.Bytes()
+		if 0 == len(b) || 0x80 <= b[0] {
+			b = append([]byte{0}, b...)
+		}
+		return b
+	}
+
+	// two's complement: 2^(8n) + i
+	var n int = (i.BitLen() + 8) / 8
+	var mod *big.Int = new(big.Int).Lsh(big.NewInt(1), uint(8*n))
+	var b []byte = new(big.Int).Add(mod, i).Bytes()
+	for len(b) < n {
+		b = append([]byte{0xff}, b...)
+	}
+	return b
+}
+
+func decimalScale(s ha.Schema) int {
+	ls, _ := s.(ha.LogicalTypeSchema)
+	dec, _ := ls.Logical().(*ha.DecimalLogicalSchema)
+	if nil == dec {
+		return 0
+	}
+	return dec.Scale()
+}
+
+func appendJsonFloat(buf []byte, f float64, bits int) []byte {
+	switch {
+	case math.IsNaN(f):
+		return append(buf, `"NaN"`...)
+	case math.IsInf(f, 1):
+		return append(buf, `"Infinity"`...)
+	case math.IsInf(f, -1):
+		return append(buf, `"-Infinity"`...)
+	default:
+		return strconv.AppendFloat(buf, f, 'g', -1, bits)
+	}
+}
+
+func appendJsonLong(buf []byte, s ha.Schema, v any) ([]byte, error) {
+	switch t := v.(type) {
+	case time.Time:
+		switch logicalType(s) {
+		case ha.TimestampMillis, ha.LocalTimestampMillis:
+			return strconv.AppendInt(buf, t.UnixMilli(), 10), nil
+		case ha.TimestampMicros, ha.LocalTimestampMicros:
+			return strconv.AppendInt(buf, t.UnixMicro(), 10), nil
+		default:
+			return nil, jsonTypeErr(s, v)
+		}
+	case time.Duration:
+		return strconv.AppendInt(buf, t.Microseconds(), 10), nil
+	default:
+		i, ok := toInt64(v)
+		if !ok {
+			return nil, jsonTypeErr(s, v)
+		}
+		return strconv.AppendInt(buf, i, 10), nil
+	}
+}
+
+func appendJsonInt(buf []byte, s ha.Schema, v any) ([]byte, error) {
+	switch t := v.(type) {
+	case time.Time:
+		var days int64 = t.Unix() / 86400
+		if t.Unix() < 0 && 0 != t.Unix()%86400 {
+			days--
+		}
+		return strconv.AppendInt(buf, days, 10), nil
+	case time.Duration:
+		return strconv.AppendInt(buf, t.Milliseconds(), 10), nil
+	case int64:
+		return nil, jsonTypeErr(s, v)
+	default:
+		i, ok := toInt64(v)
+		if !ok {
+			return nil, jsonTypeErr(s, v)
+		}
+		return strconv.AppendInt(buf, i, 10), nil
+	}
+}
+
+func fixedBytes(v any) ([]byte, bool) {
+	var rv reflect.Value = reflect.ValueOf(v)
+	if reflect.Array != rv.Kind() || reflect.Uint8 != rv.Type().Elem().Kind() {
+		return nil, false
+	}
+	var b []byte = make([]byte, rv.Len())
+	reflect.Copy(reflect.ValueOf(b), rv)
+	return b, true
+}
+
+func appendJsonRecord(
+	buf []byte,
+	s *ha.RecordSchema,
+	v any,
+) ([]byte, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, jsonTypeErr(s, v)
+	}
+	buf = append(buf, '{')
+	for i, field := range s.Fields() {
+		if 0 < i {
+			buf = append(buf, ',')
+		}
+		name, _ := json.Marshal(field.Name())
+		buf = append(append(buf, name...), ':')
+
+		var e error
+		buf, e = AppendAvroJson(buf, field.Type(), m[field.Name()])
+		if nil != e {
+			return nil, fmt.Errorf("%s: %w", field.Name(), e)
+		}
+	}
+	return append(buf, '}'), nil
+}
+
+// nullableUnion is true if the union has the null branch(ha.UnionSchema
+// Nullable is true only for the unions of the null and another type).
+func nullableUnion(s *ha.UnionSchema) bool {
+	return slices.ContainsFunc(s.Types(), func(b ha.Schema) bool {
+		return ha.Null == b.Type()
+	})
+}
+
+// unionBranchName returns the name of the branch in the Avro JSON encoding.
+func unionBranchName(s ha.Schema) string {
+	if ref, isRef := s.(*ha.RefSchema); isRef {
+		s = ref.Schema()
+	}
+	named, isNamed := s.(ha.NamedSchema)
+	if isNamed {
+		return named.FullName()
+	}
+	return string(s.Type())
+}
+
+// unwrapBranch gets the value of the branch wrapped by the binary decoder
+// (e.g, map[string]any{"ns.Rec": rec}, map[string]any{"array": arr}).
+func unwrapBranch(name string, v any) any {
+	m, ok := v.(map[string]any)
+	if !ok || 1 != len(m) {
+		return v
+	}
+	for key, val := range m {
+		if key == name || strings.HasPrefix(key, name+".") {
+			return val
+		}
+	}
+	return v
+}
+
+func appendJsonUnion(
+	buf []byte,
+	s *ha.UnionSchema,
+	v any,
+) ([]byte, error) {
+	if nil == v {
+		if nullableUnion(s) {
+			return append(buf, "null"...), nil
+		}
+		return nil, jsonTypeErr(s, v)
+	}
+
+	for _, branch := range s.Types() {
+		if ha.Null == branch.Type() {
+			continue
+		}
+
+		var name string = unionBranchName(branch)
+		encoded, e := AppendAvroJson(nil, branch, unwrapBranch(name, v))
+		if nil != e {
+			continue
+		}
+
+		quoted, _ := json.Marshal(name)
+		buf = append(append(append(buf, '{'), quoted...), ':')
+		return append(append(buf, encoded...), '}'), nil
+	}
+	return nil, jsonTypeErr(s, v)
+}
+
+// AppendAvroJson appends the Avro JSON encoding of the value.
+//
+// The value must be a decoded value using the generic types(e.g,
+// map[string]any for records).
+func AppendAvroJson(buf []byte, s ha.Schema, v any) ([]byte, error) {
+	switch t := s.(type) {
+	case *ha.RefSchema:
+		return AppendAvroJson(buf, t.Schema(), v)
+	case *ha.RecordSchema:
+		return appendJsonRecord(buf, t, v)
+	case *ha.UnionSchema:
+		return appendJsonUnion(buf, t, v)
+	case *ha.ArraySchema:
+		arr, ok := v.([]any)
+		if !ok {
+			return nil, jsonTypeErr(s, v)
+		}
+		buf = append(buf, '[')
+		for i, item := range arr {
+			if 0 < i {
+				buf = append(buf, ',')
+			}
+			var e error
+			buf, e = AppendAvroJson(buf, t.Items(), item)
+			if nil != e {
+				return nil, e
+			}
+		}
+		return append(buf, ']'), nil
+	case *ha.MapSchema:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, jsonTypeErr(s, v)
+		}
+		buf = append(buf, '{')
+		// sorted to write the same bytes for the same map
+		for i, key := range slices.Sorted(maps.Keys(m)) {
+			if 0 < i {
+				buf = append(buf, ',')
+			}
+			quoted, _ := json.Marshal(key)
+			buf = append(append(buf, quoted...), ':')
+			var e error
+			buf, e = AppendAvroJson(buf, t.Values(), m[key])
+			if nil != e {
+				return nil, e
+			}
+		}
+		return append(buf, '}'), nil
+	case *ha.FixedSchema:
+		switch r := v.(type) {
+		case *big.Rat:
+			var b []byte = RatToUnscaled(r, decimalScale(s))
+			var padded []byte = make([]byte, t.Size())
+			var pad byte = 0
+			if 0 < len(b) && 0x80 <= b[0] {
+				pad = 0xff
+			}
+			for i := range padded {
+				padded[i] = pad
+			}
+			copy(padded[max(0, t.Size()-len(b)):], b[max(0, len(b)-t.Size()):])
+			return appendJsonBytes(buf, padded)
+		case ha.LogicalDuration:
+			var b [12]byte
+			putUint32Le(b[0:4], r.Months)
+			putUint32Le(b[4:8], r.Days)
+			putUint32Le(b[8:12], r.Milliseconds)
+			return appendJsonBytes(buf, b[:])
+		default:
+			b, ok := fixedBytes(v)
+			if !ok || len(b) != t.Size() {
+				return nil, jsonTypeErr(s, v)
+			}
+			return appendJsonBytes(buf, b)
+		}
+	case *ha.EnumSchema:
+		str, ok := v.(string)
+		if !ok {
+			return nil, jsonTypeErr(s, v)
+		}
+		quoted, e := json.Marshal(str)
+		return append(buf, quoted...), e
+	}
+
+	switch s.Type() {
+	case ha.Null:
+		if nil != v {
+			return nil, jsonTypeErr(s, v)
+		}
+		return append(buf, "null"...), nil
+	case ha.Boolean:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, jsonTypeErr(s, v)
+		}
+		return strconv.AppendBool(buf, b), nil
+	case ha.Int:
+		return appendJsonInt(buf, s, v)
+	case ha.Long:
+		return appendJsonLong(buf, s, v)
+	case ha.Float:
+		f, ok := v.(float32)
+		if !ok {
+			return nil, jsonTypeErr(s, v)
+		}
+		return appendJsonFloat(buf, float64(f), 32), nil
+	case ha.Double:
+		f, ok := v.(float64)
+		if !ok {
+			return nil, jsonTypeErr(s, v)
+		}
+		return appendJsonFloat(buf, f, 64), nil
+	case ha.String:
+		str, ok := v.(string)
+		if !ok {
+			return nil, jsonTypeErr(s, v)
+		}
+		quoted, e := json.Marshal(str)
+		return append(buf, quoted...), e
+	case ha.Bytes:
+		switch b := v.(type) {
+		case []byte:
+			return appendJsonBytes(buf, b)
+		case *big.Rat:
+			return appendJsonBytes(buf, RatToUnscaled(b, decimalScale(s)))
+		default:
+			return nil, jsonTypeErr(s, v)
+		}
+	default:
+		return nil, jsonTypeErr(s, v)
+	}
+}
+
+func putUint32Le(b []byte, u uint32) {
+	b[0] = byte(u)
+	b[1] = byte(u >> 8)
+	b[2] = byte(u >> 16)
+	b[3] = byte(u >> 24)
+}
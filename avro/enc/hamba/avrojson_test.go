@@ -0,0 +1,190 @@
+package enc_test
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	ha "github.com/hamba/avro/v2"
+
+	dh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/dec/hamba"
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+const jsonSchema string = `{
+	"type": "record",
+	"name": "Row",
+	"namespace": "test",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "opt", "type": ["null", "string", "long"]},
+		{"name": "inner", "type": ["null", {
+			"type": "record",
+			"name": "Inner",
+			"fields": [{"name": "x", "type": "int"}]
+		}]},
+		{"name": "amount", "type": {
+			"type": "bytes", "logicalType": "decimal",
+			"precision": 10, "scale": 2
+		}},
+		{"name": "price", "type": {
+			"type": "fixed", "name": "Price", "size": 4,
+			"logicalType": "decimal", "precision": 8, "scale": 3
+		}},
+		{"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 3}},
+		{"name": "attrs", "type": {"type": "map", "values": "double"}},
+		{"name": "ratio", "type": "double"},
+		{"name": "small", "type": "float"},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "color", "type": {
+			"type": "enum", "name": "Color", "symbols": ["RED", "BLUE"]
+		}},
+		{"name": "raw", "type": "bytes"}
+	]
+}`
+
+func jsonRow(i int, ratio float64) map[string]any {
+	var attrs map[string]any = map[string]any{}
+	for j := range 20 {
+		attrs[fmt.Sprintf("k%02d", j)] = float64(i * j)
+	}
+	var opt any = nil
+	switch i % 3 {
+	case 1:
+		opt = fmt.Sprintf("s%v", i)
+	case 2:
+		opt = int64(i)
+	}
+	var inner any = nil
+	if 0 == i%2 {
+		inner = map[string]any{"test.Inner": map[string]any{"x": i}}
+	}
+	return map[string]any{
+		"id":     int64(i),
+		"opt":    opt,
+		"inner":  inner,
+		"amount": big.NewRat(int64(-12345*i-1), 100),
+		"price":  big.NewRat(int64(1000*i+7), 1000),
+		"hash":   [3]byte{byte(i), 0xff, 0x80},
+		"attrs":  attrs,
+		"ratio":  ratio,
+		"small":  float32(i) / 4,
+		"tags":   []any{"a", "é", "\u0000"},
+		"color":  "BLUE",
+		"raw":    []byte{0, 0x7f, 0x80, 0xff},
+	}
+}
+
+// binaryRoundTrip gets the values as the binary decoder returns them.
+func binaryRoundTrip(t *testing.T, s ha.Schema, row map[string]any) any {
+	t.Helper()
+	encoded, e := ha.Marshal(s, row)
+	if nil != e {
+		t.Fatal(e)
+	}
+	var decoded map[string]any
+	e = ha.Unmarshal(s, encoded, &decoded)
+	if nil != e {
+		t.Fatal(e)
+	}
+	return decoded
+}
+
+func appendJson(t *testing.T, s ha.Schema, v any) []byte {
+	t.Helper()
+	encoded, e := eh.AppendAvroJson(nil, s, v)
+	if nil != e {
+		t.Fatal(e)
+	}
+	return encoded
+}
+
+func jsonToMaps(t *testing.T, s ha.Schema, data []byte) []map[string]any {
+	t.Helper()
+	var ret []map[string]any
+	for row, e := range dh.JsonToMaps(bytes.NewReader(data), s) {
+		if nil != e {
+			t.Fatal(e)
+		}
+		ret = append(ret, row)
+	}
+	return ret
+}
+
+func TestAvroJsonRoundTrip(t *testing.T) {
+	var s ha.Schema = mustParse(t, jsonSchema)
+	for i := range 6 {
+		var expected any = binaryRoundTrip(t, s, jsonRow(i, 0.5))
+		var encoded []byte = appendJson(t, s, expected)
+
+		var rows []map[string]any = jsonToMaps(t, s, encoded)
+		if 1 != len(rows) {
+			t.Fatalf("rows: %v", rows)
+		}
+		if !reflect.DeepEqual(expected, rows[0]) {
+			t.Fatalf("row %v\nexpected: %v\ngot:      %v", i, expected, rows[0])
+		}
+
+		// the decoded values can be written again
+		_, e := ha.Marshal(s, rows[0])
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+}
+
+func TestAvroJsonNonFinite(t *testing.T) {
+	var s ha.Schema = mustParse(t, jsonSchema)
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		var expected any = binaryRoundTrip(t, s, jsonRow(1, f))
+		var encoded []byte = appendJson(t, s, expected)
+
+		var rows []map[string]any = jsonToMaps(t, s, encoded)
+		var got float64 = rows[0]["ratio"].(float64)
+		var same bool = math.IsNaN(f) && math.IsNaN(got) || f == got
+		if !same {
+			t.Fatalf("expected %v, got %v", f, got)
+		}
+		if !bytes.Equal(encoded, appendJson(t, s, rows[0])) {
+			t.Fatalf("unstable encoding: %s", encoded)
+		}
+	}
+}
+
+func TestAvroJsonMapSorted(t *testing.T) {
+	var s ha.Schema = mustParse(t, jsonSchema)
+	var row any = binaryRoundTrip(t, s, jsonRow(3, 1))
+
+	var first []byte = appendJson(t, s, row)
+	for range 20 {
+		if !bytes.Equal(first, appendJson(t, s, row)) {
+			t.Fatal("nondeterministic encoding")
+		}
+	}
+	if !strings.Contains(string(first), `"attrs":{"k00":0,"k01":3,"k02":6,`) {
+		t.Fatalf("unsorted map: %s", first)
+	}
+}
+
+func TestAvroJsonInvalid(t *testing.T) {
+	var s ha.Schema = mustParse(t, jsonSchema)
+	var valid []byte = appendJson(t, s, binaryRoundTrip(t, s, jsonRow(1, 1)))
+	var cases = map[string]string{
+		"enum":   strings.Replace(string(valid), `"BLUE"`, `"GREEN"`, 1),
+		"union":  strings.Replace(string(valid), `{"string":"s1"}`, `"s1"`, 1),
+		"fixed":  strings.Replace(string(valid), `"hash":"\u0001`, `"hash":"`, 1),
+		"syntax": string(valid[:len(valid)-1]),
+	}
+	for name, data := range cases {
+		for _, e := range dh.JsonToMaps(strings.NewReader(data), s) {
+			if nil == e {
+				t.Errorf("%s: expected an error", name)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,599 @@
+package enc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	. "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/util"
+
+	pk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/pkey"
+)
+
+var (
+	ErrUnknownBlobMode error = errors.New("unknown blob mode")
+	ErrNotBlobField    error = errors.New("not a bytes field")
+)
+
+// BlobMode decides where the blob fields will be written.
+type BlobMode string
+
+const (
+	// BlobInline keeps the blobs inside the partition files.
+	BlobInline BlobMode = "inline"
+
+	// BlobSidecar writes the blobs as raw files next to the partition file
+	// which keeps references to them.
+	BlobSidecar BlobMode = "sidecar"
+
+	// BlobOnly writes the blobs(and the json sidecar) without the partition
+	// file.
+	BlobOnly BlobMode = "only"
+)
+
+func StringToBlobMode(s string) (BlobMode, error) {
+	switch s {
+	case "", "inline":
+		return BlobInline, nil
+	case "sidecar":
+		return BlobSidecar, nil
+	case "only":
+		return BlobOnly, nil
+	default:
+		return BlobInline, fmt.Errorf("%w: %s", ErrUnknownBlobMode, s)
+	}
+}
+
+const BlobRefName string = "blob_ref"
+
+// BlobRefSchema is the schema of the reference to a blob file.
+var BlobRefSchema map[string]any = map[string]any{
+	"type": "record",
+	"name": BlobRefName,
+	"fields": []any{
+		map[string]any{"name": "path", "type": "string"},
+		map[string]any{"name": "size", "type": "long"},
+		map[string]any{"name": "digest", "type": "string"},
+	},
+}
+
+// ReplaceFieldTypes replaces the type of the bytes fields(or nullable bytes
+// fields) of the record schema.
+//
+// The conv gets the index of the replaced field(0: first replacement).
+// A union returned by the conv will be flattened for nullable fields.
+func ReplaceFieldTypes(
+	schema string,
+	fields []string,
+	conv func(index int) any,
+) (string, error) {
+	var parsed map[string]any
+	e := json.Unmarshal([]byte(schema), &parsed)
+	if nil != e {
+		return "", e
+	}
+
+	flds, _ := parsed["fields"].([]any)
+	var replaced int = 0
+	for _, fld := range flds {
+		field, _ := fld.(map[string]any)
+		name, _ := field["name"].(string)
+		if !slices.Contains(fields, name) {
+			continue
+		}
+
+		switch typ := field["type"].(type) {
+		case string:
+			if "bytes" != typ {
+				return "", fmt.Errorf("%w: %s", ErrNotBlobField, name)
+			}
+			field["type"] = conv(replaced)
+		case []any:
+			if !slices.Equal(typ, []any{"null", "bytes"}) {
+				return "", fmt.Errorf("%w: %s", ErrNotBlobField, name)
+			}
+			var converted any = conv(replaced)
+			union, isUnion := converted.([]any)
+			switch isUnion {
+			case true:
+				field["type"] = append([]any{"null"}, union...)
+			default:
+				field["type"] = []any{"null", converted}
+			}
+		default:
+			return "", fmt.Errorf("%w: %s", ErrNotBlobField, name)
+		}
+		replaced++
+	}
+
+	converted, e := json.Marshal(parsed)
+	return string(converted), e
+}
+
+// SchemaToBlobRefSchema replaces the blob fields with blob references.
+func SchemaToBlobRefSchema(schema string, fields []string) (string, error) {
+	return ReplaceFieldTypes(schema, fields, func(index int) any {
+		switch index {
+		case 0:
+			return BlobRefSchema
+		default:
+			return BlobRefName
+		}
+	})
+}
+
+// NullableFields returns the fields of the record schema which are unions.
+func NullableFields(schema string, fields []string) ([]string, error) {
+	var parsed map[string]any
+	e := json.Unmarshal([]byte(schema), &parsed)
+	if nil != e {
+		return nil, e
+	}
+
+	var nullable []string
+	flds, _ := parsed["fields"].([]any)
+	for _, fld := range flds {
+		field, _ := fld.(map[string]any)
+		name, _ := field["name"].(string)
+		_, isUnion := field["type"].([]any)
+		if isUnion && slices.Contains(fields, name) {
+			nullable = append(nullable, name)
+		}
+	}
+	return nullable, nil
+}
+
+type BlobConfig struct {
+	BlobMode
+	Fields []string
+
+	// The nullable fields of the Fields(see NullableFields); the references
+	// of them are encoded as unions.
+	Nullable []string
+
+	// Appends the non-blob fields(and the references) as json lines.
+	JsonSidecar bool
+
+	FsyncType
+
+	// Syncs the files by groups(nil: the FsyncType syncs each file).
+	Syncer *Syncer
+
+	// Writes the blobs of the partitions named by the keys(e.g, database
+	// sinks) next to KeyDirname/key.KeyExt(empty: next to the partitions).
+	KeyDirname Dirname
+	KeyExt     Ext
+
+	// Encrypts the blobs and the json sidecars(nil: plain files).
+	Encryption *Encryption
+
+	// Checks the limits of the blobs and the json sidecars(nil: no limits).
+	Limiter *Limiter
+
+	// The policy of the json sidecars written without the partitions(e.g,
+	// BlobOnly): ExistAppend appends to them; the others replace them.
+	SidecarPolicy ExistPolicy
+}
+
+// ToFsync returns the sync of the Syncer or the FsyncType.
+func (c BlobConfig) ToFsync() func(*os.File) error {
+	return c.Syncer.ToFsync(c.FsyncType)
+}
+
+// LocalName returns the local name of the partition next to which the blobs
+// are written.
+func (c BlobConfig) LocalName(partition string) string {
+	if "" == c.KeyDirname {
+		return partition
+	}
+	return filepath.Join(string(c.KeyDirname), partition+"."+string(c.KeyExt))
+}
+
+// BlobFilename creates the name of a blob file next to the partition file.
+//
+// e.g, path/to/key.avro -> path/to/key.data.0123456789abcdef.bin
+func BlobFilename(partition string, field string, digest string) string {
+	var noext string = strings.TrimSuffix(partition, filepath.Ext(partition))
+	return noext + "." + field + "." + digest[:16] + ".bin"
+}
+
+var sidecarBlobPattern *regexp.Regexp = regexp.MustCompile(
+	`\.[^./]+\.[0-9a-f]{16}\.bin$`,
+)
+
+// SidecarBlob is true if the name looks like a name of BlobFilename.
+func SidecarBlob(name string) bool {
+	return sidecarBlobPattern.MatchString(name)
+}
+
+// JsonSidecarFilename creates the name of the json lines file.
+func JsonSidecarFilename(partition string) string {
+	var noext string = strings.TrimSuffix(partition, filepath.Ext(partition))
+	return noext + ".jsonl"
+}
+
+// WriteFileSync writes the file unless it exists.
+func WriteFileSync(
+	filename string,
+	data []byte,
+	sync func(*os.File) error,
+) error {
+	f, e := os.OpenFile(
+		filename,
+		os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+		FileModeDefault,
+	)
+	if errors.Is(e, fs.ErrExist) {
+		return nil
+	}
+	if nil != e {
+		return e
+	}
+	_, e = f.Write(data)
+	return errors.Join(e, sync(f), f.Close())
+}
+
+// BlobExtractor writes the blobs as files before saving the records.
+//
+// BlobExtractor is safe for concurrent use.
+type BlobExtractor struct {
+	BlobConfig
+
+	mu sync.Mutex
+
+	// the json sidecars being written by the names of the partitions
+	sidecars map[string]PartitionWriter
+}
+
+func (c BlobConfig) ToExtractor() *BlobExtractor {
+	return &BlobExtractor{
+		BlobConfig: c,
+		sidecars:   map[string]PartitionWriter{},
+	}
+}
+
+func (b *BlobExtractor) writeBlob(
+	partition string,
+	field string,
+	blob []byte,
+) (map[string]any, error) {
+	var digest [32]byte = sha256.Sum256(blob)
+	var dhex string = hex.EncodeToString(digest[:])
+	var filename string = BlobFilename(b.LocalName(partition), field, dhex)
+
+	var ref map[string]any = map[string]any{
+		"path":   filepath.Base(filename),
+		"size":   int64(len(blob)),
+		"digest": dhex,
+	}
+	_, e := os.Stat(filename)
+	if nil == e {
+		// the same blob was written
+		return ref, nil
+	}
+
+	var data []byte = blob
+	if nil != b.Encryption {
+		data, e = b.Encryption.Seal(blob)
+		if nil != e {
+			return nil, e
+		}
+	}
+	e = b.Limiter.Reserve(filename, int64(len(data)))
+	if nil != e {
+		return nil, e
+	}
+	return ref, WriteFileSync(filename, data, b.ToFsync())
+}
+
+// JsonValue converts the value to be marshaled as json.
+func JsonValue(v any) any {
+	switch t := v.(type) {
+	case [16]byte:
+		return fmt.Sprintf(
+			"%x-%x-%x-%x-%x",
+			t[0:4], t[4:6], t[6:8], t[8:10], t[10:],
+		)
+	case map[string]any:
+		var m map[string]any = map[string]any{}
+		for key, val := range t {
+			m[key] = JsonValue(val)
+		}
+		return m
+	default:
+		return v
+	}
+}
+
+// createJson starts writing the json sidecar of the partition.
+//
+// The sidecar of an appended partition is appended; the others are
+// replaced on commit.
+func (b *BlobExtractor) createJson(
+	partition string,
+	appended bool,
+) (PartitionWriter, error) {
+	var filename string = JsonSidecarFilename(b.LocalName(partition))
+	var policy ExistPolicy = ExistOverwrite
+	if appended {
+		policy = ExistAppend
+	}
+	var sink Sink = FsSink{ExistPolicy: policy, Sync: b.ToFsync()}
+	if nil != b.Encryption {
+		// the lines are sealed once on commit
+		sink = EncryptSink{Sink: sink, Encryption: *b.Encryption}
+	}
+
+	w, e := sink.Create(filename)
+	if nil != e {
+		return nil, e
+	}
+	seeker, seekable := w.(io.Seeker)
+	if seekable {
+		_, e = seeker.Seek(0, io.SeekEnd)
+	}
+	if nil != e {
+		return nil, errors.Join(e, w.Abort())
+	}
+	return w, nil
+}
+
+// openJson starts writing the json sidecar of the created partition.
+func (b *BlobExtractor) openJson(partition string, appended bool) error {
+	w, e := b.createJson(partition, appended)
+	if nil != e {
+		return e
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sidecars[partition] = w
+	return nil
+}
+
+// closeJson commits(or aborts) the json sidecar of the partition.
+func (b *BlobExtractor) closeJson(partition string, commit bool) error {
+	b.mu.Lock()
+	w, found := b.sidecars[partition]
+	delete(b.sidecars, partition)
+	b.mu.Unlock()
+
+	switch {
+	case !found:
+		return nil
+	case commit:
+		return w.Commit()
+	default:
+		return w.Abort()
+	}
+}
+
+// Close commits the json sidecars written without the partitions(e.g,
+// BlobOnly).
+func (b *BlobExtractor) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var errs []error
+	for _, partition := range slices.Sorted(maps.Keys(b.sidecars)) {
+		errs = append(errs, b.sidecars[partition].Commit())
+		delete(b.sidecars, partition)
+	}
+	return errors.Join(errs...)
+}
+
+func (b *BlobExtractor) appendJson(
+	partition string,
+	m map[string]any,
+) error {
+	line, e := json.Marshal(JsonValue(m))
+	if nil != e {
+		return e
+	}
+	e = b.Limiter.Reserve(
+		JsonSidecarFilename(b.LocalName(partition)),
+		int64(len(line)+1),
+	)
+	if nil != e {
+		return e
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w, found := b.sidecars[partition]
+	if !found {
+		// the sidecar of the records without the partition
+		w, e = b.createJson(partition, ExistAppend == b.SidecarPolicy)
+		if nil != e {
+			return e
+		}
+		b.sidecars[partition] = w
+	}
+	_, e = w.Write(append(line, '\n'))
+	return e
+}
+
+// Extract writes the blobs and creates a record with the references.
+//
+// The references of the nullable fields are wrapped as unions(the json
+// sidecar has the plain references).
+func (b *BlobExtractor) Extract(
+	partition string,
+	m map[string]any,
+) (map[string]any, error) {
+	var converted map[string]any = make(map[string]any, len(m))
+	var refs map[string]any = map[string]any{}
+	for key, val := range m {
+		blob, isBlob := val.([]byte)
+		if !isBlob || !slices.Contains(b.Fields, key) {
+			converted[key] = val
+			continue
+		}
+
+		ref, e := b.writeBlob(partition, key, blob)
+		if nil != e {
+			return nil, e
+		}
+		converted[key] = ref
+		refs[key] = ref
+	}
+
+	if b.JsonSidecar {
+		e := b.appendJson(partition, converted)
+		if nil != e {
+			return nil, e
+		}
+	}
+
+	for key, ref := range refs {
+		if slices.Contains(b.Nullable, key) {
+			converted[key] = map[string]any{BlobRefName: ref}
+		}
+	}
+	return converted, nil
+}
+
+// Converter converts the records of the partition before written.
+func (b *BlobExtractor) Converter(partition string) RecordConverter {
+	return func(m map[string]any) (map[string]any, error) {
+		return b.Extract(partition, m)
+	}
+}
+
+// Wrap creates a saver which extracts the blobs before the original saver.
+//
+// The original saver must use the schema converted by
+// SchemaToBlobRefSchema(unused for BlobOnly).
+//
+// The blobs are extracted even if the partition is skipped; use
+// FsConfig.Blobs to extract the blobs of the written partitions only. The
+// json sidecars are committed by Close.
+func (b *BlobExtractor) Wrap(
+	original pk.RecordSaver,
+	pk2filename KeyToFilename,
+) pk.RecordSaver {
+	if BlobInline == b.BlobMode {
+		return original
+	}
+	return func(
+		key pk.PrimaryKey,
+		pw pk.PrimaryKeyWriter,
+		m map[string]any,
+	) IO[Void] {
+		return func(ctx context.Context) (Void, error) {
+			filename, e := pk2filename(key, pw)(ctx)
+			if nil != e {
+				return Empty, e
+			}
+
+			converted, e := b.Extract(filename, m)
+			if nil != e || BlobOnly == b.BlobMode {
+				return Empty, e
+			}
+
+			return original(key, pw, converted)(ctx)
+		}
+	}
+}
+
+// sidecarPartition commits(or aborts) the json sidecar with the partition.
+type sidecarPartition struct {
+	PartitionWriter
+	blobs *BlobExtractor
+	name  string
+}
+
+func (p *sidecarPartition) Unwrap() PartitionWriter { return p.PartitionWriter }
+
+func (p *sidecarPartition) Finish() error {
+	return FinishPartition(p.PartitionWriter)
+}
+
+func (p *sidecarPartition) Commit() error {
+	e := p.PartitionWriter.Commit()
+	return errors.Join(e, p.blobs.closeJson(p.name, nil == e))
+}
+
+func (p *sidecarPartition) Abort() error {
+	return errors.Join(
+		p.PartitionWriter.Abort(),
+		p.blobs.closeJson(p.name, false),
+	)
+}
+
+// seekableSidecarPartition allows appending to the existing partition.
+type seekableSidecarPartition struct {
+	*sidecarPartition
+	io.ReadSeeker
+}
+
+// JsonSidecarSink writes the json sidecar of each created partition(named
+// by the actual name of the partition, e.g, a versioned name).
+//
+// The sidecar of an appended(or reopened) partition is appended; the others
+// are replaced.
+type JsonSidecarSink struct {
+	Sink
+	Blobs *BlobExtractor
+	ExistPolicy
+}
+
+func (s JsonSidecarSink) wrap(
+	w PartitionWriter,
+	appended bool,
+	e error,
+) (PartitionWriter, error) {
+	if nil != e {
+		return nil, e
+	}
+	e = s.Blobs.openJson(w.Name(), appended)
+	if nil != e {
+		return nil, errors.Join(e, w.Abort())
+	}
+
+	var p *sidecarPartition = &sidecarPartition{
+		PartitionWriter: w,
+		blobs:           s.Blobs,
+		name:            w.Name(),
+	}
+	rs, seekable := w.(io.ReadSeeker)
+	if !seekable {
+		return p, nil
+	}
+	return seekableSidecarPartition{sidecarPartition: p, ReadSeeker: rs}, nil
+}
+
+func (s JsonSidecarSink) Create(path string) (PartitionWriter, error) {
+	w, e := s.Sink.Create(path)
+	return s.wrap(w, ExistAppend == s.ExistPolicy, e)
+}
+
+func (s JsonSidecarSink) Reopen(name string) (PartitionWriter, error) {
+	w, e := s.Sink.Reopen(name)
+	return s.wrap(w, true, e)
+}
+
+func (s JsonSidecarSink) Close() error { return CloseSink(s.Sink) }
+
+// WithBlobRefs converts the schema if the blobs are extracted.
+func (c Config) WithBlobRefs(b BlobConfig) (Config, error) {
+	if BlobInline == b.BlobMode {
+		return c, nil
+	}
+	converted, e := SchemaToBlobRefSchema(c.Schema, b.Fields)
+	c.Schema = converted
+	return c, e
+}
@@ -0,0 +1,271 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+const nullableSchema string = `{
+	"type": "record",
+	"name": "Row",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "opt", "type": ["null", "bytes"]}
+	]
+}`
+
+func blobConfig(t *testing.T, dir string, policy eh.ExistPolicy) eh.FsConfig {
+	t.Helper()
+	var fields []string = []string{"opt"}
+	nullable, e := eh.NullableFields(nullableSchema, fields)
+	if nil != e {
+		t.Fatal(e)
+	}
+	var bc eh.BlobConfig = eh.BlobConfig{
+		BlobMode: eh.BlobSidecar,
+		Fields:   fields,
+		Nullable: nullable,
+	}
+	cfg, e := eh.Config{
+		Schema:       nullableSchema,
+		EncodeConfig: bp.EncodeConfigDefault,
+	}.WithBlobRefs(bc)
+	if nil != e {
+		t.Fatal(e)
+	}
+	return eh.FsConfig{
+		Config:      cfg,
+		FsyncType:   eh.FsyncFast,
+		Dirname:     eh.Dirname(dir),
+		ExistPolicy: policy,
+		Blobs:       bc.ToExtractor(),
+	}
+}
+
+func blobFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	found, e := filepath.Glob(filepath.Join(dir, "*.bin"))
+	if nil != e {
+		t.Fatal(e)
+	}
+	return found
+}
+
+func TestBlobNullableField(t *testing.T) {
+	var dir string = t.TempDir()
+	var fc eh.FsConfig = blobConfig(t, dir, eh.ExistAppend)
+	var filename string = filepath.Join(dir, "k.avro")
+
+	for _, opt := range []any{[]byte("blob"), nil} {
+		e := fc.WriteMap(map[string]any{"id": int64(1), "opt": opt}, filename)
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+
+	f, e := os.Open(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	defer f.Close()
+	rows, e := decodeRows(t, f)
+	if nil != e {
+		t.Fatal(e)
+	}
+	if 2 != len(rows) || nil != rows[1]["opt"] {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+	union, _ := rows[0]["opt"].(map[string]any)
+	ref, isRef := union[eh.BlobRefName].(map[string]any)
+	if !isRef || int64(4) != ref["size"] {
+		t.Fatalf("unexpected reference: %v", rows[0]["opt"])
+	}
+	if 1 != len(blobFiles(t, dir)) {
+		t.Fatalf("blobs: %v", blobFiles(t, dir))
+	}
+}
+
+func TestBlobSkippedPartition(t *testing.T) {
+	var dir string = t.TempDir()
+	var filename string = filepath.Join(dir, "k.avro")
+	e := os.WriteFile(filename, []byte("existing"), 0o644)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	var fc eh.FsConfig = blobConfig(t, dir, eh.ExistSkip)
+	e = fc.WriteMap(
+		map[string]any{"id": int64(1), "opt": []byte("blob")},
+		filename,
+	)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	pool, e := fc.ToPool(1)
+	if nil != e {
+		t.Fatal(e)
+	}
+	e = pool.WriteMap(
+		map[string]any{"id": int64(1), "opt": []byte("blob")},
+		filename,
+		eh.PartitionHeader{},
+	)
+	if nil != e {
+		t.Fatal(e)
+	}
+	e = pool.Close()
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	if 0 != len(blobFiles(t, dir)) {
+		t.Fatalf("blobs of the skipped partition: %v", blobFiles(t, dir))
+	}
+}
+
+func TestBlobKeyDirname(t *testing.T) {
+	var dir string = t.TempDir()
+	var fc eh.FsConfig = blobConfig(t, dir, eh.ExistOverwrite)
+	fc.Blobs.KeyDirname = eh.Dirname(dir)
+	fc.Blobs.KeyExt = eh.ExtDefault
+
+	// the database sinks name the partitions by the keys
+	var sink *eh.MemSink = eh.MemSinkNew()
+	fc.Sink = sink
+	e := fc.WriteMap(map[string]any{"id": int64(1), "opt": []byte("blob")}, "k")
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	var blobs []string = blobFiles(t, dir)
+	if 1 != len(blobs) || "k.opt." != filepath.Base(blobs[0])[:6] {
+		t.Fatalf("blobs: %v", blobs)
+	}
+	if "k" != sink.Names()[0] {
+		t.Fatalf("partitions: %v", sink.Names())
+	}
+}
+
+func sidecarConfig(
+	t *testing.T,
+	dir string,
+	policy eh.ExistPolicy,
+) eh.FsConfig {
+	t.Helper()
+	var fc eh.FsConfig = blobConfig(t, dir, policy)
+	fc.Blobs.JsonSidecar = true
+	return fc
+}
+
+func writeBlobRows(t *testing.T, fc eh.FsConfig, filename string, n int) {
+	t.Helper()
+	for i := range n {
+		e := fc.WriteMap(
+			map[string]any{"id": int64(i), "opt": []byte("blob")},
+			filename,
+		)
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+}
+
+func jsonLines(t *testing.T, filename string) int {
+	t.Helper()
+	data, e := os.ReadFile(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+// The json lines of the earlier runs are kept for the appended partitions.
+func TestJsonSidecarAppend(t *testing.T) {
+	var dir string = t.TempDir()
+	var filename string = filepath.Join(dir, "k.avro")
+	writeBlobRows(t, sidecarConfig(t, dir, eh.ExistOverwrite), filename, 1)
+	writeBlobRows(t, sidecarConfig(t, dir, eh.ExistAppend), filename, 2)
+
+	if 3 != jsonLines(t, filepath.Join(dir, "k.jsonl")) {
+		t.Fatalf("lines: %v", jsonLines(t, filepath.Join(dir, "k.jsonl")))
+	}
+}
+
+// The versioned partitions have their own json sidecars.
+func TestJsonSidecarVersion(t *testing.T) {
+	var dir string = t.TempDir()
+	var filename string = filepath.Join(dir, "k.avro")
+	writeBlobRows(t, sidecarConfig(t, dir, eh.ExistOverwrite), filename, 1)
+	writeBlobRows(t, sidecarConfig(t, dir, eh.ExistVersion), filename, 1)
+
+	if 1 != jsonLines(t, filepath.Join(dir, "k.jsonl")) {
+		t.Fatalf("lines: %v", jsonLines(t, filepath.Join(dir, "k.jsonl")))
+	}
+	if 1 != jsonLines(t, filepath.Join(dir, "k.1.jsonl")) {
+		t.Fatalf("lines: %v", jsonLines(t, filepath.Join(dir, "k.1.jsonl")))
+	}
+}
+
+// The json lines of an aborted partition are rolled back.
+func TestJsonSidecarAbort(t *testing.T) {
+	var dir string = t.TempDir()
+	var filename string = filepath.Join(dir, "k.avro")
+	_ = writeExisting(t, filename)
+	var sidecar string = filepath.Join(dir, "k.jsonl")
+	e := os.WriteFile(sidecar, []byte("{}\n"), 0o644)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	// the existing partition has another schema
+	e = sidecarConfig(t, dir, eh.ExistAppend).WriteMap(
+		map[string]any{"id": int64(1), "opt": []byte("blob")},
+		filename,
+	)
+	if !errors.Is(e, eh.ErrSchemaMismatch) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	data, e := os.ReadFile(sidecar)
+	if nil != e || "{}\n" != string(data) {
+		t.Fatalf("sidecar modified: %q %v", data, e)
+	}
+}
+
+// The json sidecar of a partition evicted by the pool is appended.
+func TestJsonSidecarPool(t *testing.T) {
+	var dir string = t.TempDir()
+	var fc eh.FsConfig = sidecarConfig(t, dir, eh.ExistOverwrite)
+	pool, e := fc.ToPool(1)
+	if nil != e {
+		t.Fatal(e)
+	}
+	for i, key := range []string{"a", "b", "a", "b", "a"} {
+		if nil == e {
+			e = pool.WriteMap(
+				map[string]any{"id": int64(i), "opt": []byte(key)},
+				filepath.Join(dir, key+".avro"),
+				eh.PartitionHeader{},
+			)
+		}
+	}
+	e = errors.Join(e, pool.Close())
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	for key, expected := range map[string]int{"a": 3, "b": 2} {
+		var lines int = jsonLines(t, filepath.Join(dir, key+".jsonl"))
+		var records int = fileRecords(t, filepath.Join(dir, key+".avro"))
+		if expected != lines || expected != records {
+			t.Fatalf("%s: %v lines, %v records", key, lines, records)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package enc
+
+import (
+	"context"
+	"slices"
+
+	. "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/util"
+
+	bs "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/blobstore"
+	pk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/pkey"
+)
+
+const CasThresholdDefault int = 4096
+
+// CasConfig stores large blobs in the content-addressed store.
+//
+// A blob field becomes a union of bytes(small blob) and string(digest).
+type CasConfig struct {
+	Fields []string
+
+	// Blobs smaller than this are kept inline.
+	Threshold int
+
+	bs.Store
+}
+
+// SchemaToCasSchema converts the blob fields to ["bytes", "string"].
+func SchemaToCasSchema(schema string, fields []string) (string, error) {
+	return ReplaceFieldTypes(schema, fields, func(_ int) any {
+		return []any{"bytes", "string"}
+	})
+}
+
+// Dedup creates a record which has digests instead of large blobs.
+func (c CasConfig) Dedup(m map[string]any) (map[string]any, error) {
+	var converted map[string]any = make(map[string]any, len(m))
+	for key, val := range m {
+		blob, isBlob := val.([]byte)
+		if !isBlob ||
+			len(blob) < c.Threshold ||
+			!slices.Contains(c.Fields, key) {
+			converted[key] = val
+			continue
+		}
+
+		digest, e := c.Store.Put(blob)
+		if nil != e {
+			return nil, e
+		}
+		converted[key] = digest
+	}
+	return converted, nil
+}
+
+// Wrap creates a saver which stores the large blobs before the original
+// saver.
+func (c CasConfig) Wrap(original pk.RecordSaver) pk.RecordSaver {
+	if 0 == len(c.Fields) {
+		return original
+	}
+	return func(
+		key pk.PrimaryKey,
+		pw pk.PrimaryKeyWriter,
+		m map[string]any,
+	) IO[Void] {
+		return func(ctx context.Context) (Void, error) {
+			converted, e := c.Dedup(m)
+			if nil != e {
+				return Empty, e
+			}
+			return original(key, pw, converted)(ctx)
+		}
+	}
+}
+
+// WithCas converts the schema if the blobs are stored.
+func (c Config) WithCas(cas CasConfig) (Config, error) {
+	if 0 == len(cas.Fields) {
+		return c, nil
+	}
+	converted, e := SchemaToCasSchema(c.Schema, cas.Fields)
+	c.Schema = converted
+	return c, e
+}
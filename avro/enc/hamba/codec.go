@@ -0,0 +1,227 @@
+package enc
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+
+	ho "github.com/hamba/avro/v2/ocf"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+)
+
+// BlockCodec compresses the data blocks of an object container file.
+type BlockCodec interface {
+	Name() bp.Codec
+	Encode(raw []byte) ([]byte, error)
+}
+
+type NullCodec struct{}
+
+func (NullCodec) Name() bp.Codec                    { return bp.CodecNull }
+func (NullCodec) Encode(raw []byte) ([]byte, error) { return raw, nil }
+
+type SnappyCodec struct{ ho.SnappyCodec }
+
+func (SnappyCodec) Name() bp.Codec { return bp.CodecSnappy }
+
+func (s SnappyCodec) Encode(raw []byte) ([]byte, error) {
+	return s.SnappyCodec.Encode(raw), nil
+}
+
+// StreamCodec compresses blocks using a stream writer.
+type StreamCodec struct {
+	name      bp.Codec
+	newWriter func(io.Writer) (io.WriteCloser, error)
+}
+
+func (s StreamCodec) Name() bp.Codec { return s.name }
+
+func (s StreamCodec) Encode(raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	wtr, e := s.newWriter(&buf)
+	if nil != e {
+		return nil, e
+	}
+	_, e = wtr.Write(raw)
+	e = errors.Join(e, wtr.Close())
+	return buf.Bytes(), e
+}
+
+func DeflateCodecNew(level int) (StreamCodec, error) {
+	switch level {
+	case bp.CompressionLevelDefault:
+		level = flate.DefaultCompression
+	default:
+	}
+
+	// checks the level
+	_, e := flate.NewWriter(io.Discard, level)
+
+	return StreamCodec{
+		name: bp.CodecDeflate,
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		},
+	}, e
+}
+
+func Bzip2CodecNew(level int) (StreamCodec, error) {
+	var cfg bzip2.WriterConfig
+	cfg.Level = level
+
+	// checks the level
+	_, e := bzip2.NewWriter(io.Discard, &cfg)
+
+	return StreamCodec{
+		name: bp.CodecBzip2,
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return bzip2.NewWriter(w, &cfg)
+		},
+	}, e
+}
+
+var XzCodec StreamCodec = StreamCodec{
+	name: bp.CodecXz,
+	newWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return xz.NewWriter(w)
+	},
+}
+
+type ZstdCodec struct{ *zstd.Encoder }
+
+func (ZstdCodec) Name() bp.Codec { return bp.CodecZstd }
+
+func (z ZstdCodec) Encode(raw []byte) ([]byte, error) {
+	return z.Encoder.EncodeAll(raw, nil), nil
+}
+
+// zstdEncoders are shared by the levels; EncodeAll is safe for concurrent
+// use and an encoder is never created(and left open) per file.
+var zstdEncoders struct {
+	sync.Mutex
+	byLevel map[int]*zstd.Encoder
+}
+
+func ZstdCodecNew(level int) (ZstdCodec, error) {
+	zstdEncoders.Lock()
+	defer zstdEncoders.Unlock()
+
+	enc, found := zstdEncoders.byLevel[level]
+	if found {
+		return ZstdCodec{Encoder: enc}, nil
+	}
+
+	var opts []zstd.EOption
+	switch level {
+	case bp.CompressionLevelDefault:
+	default:
+		opts = append(
+			opts,
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+		)
+	}
+	enc, e := zstd.NewWriter(nil, opts...)
+	if nil != e {
+		return ZstdCodec{}, e
+	}
+
+	if nil == zstdEncoders.byLevel {
+		zstdEncoders.byLevel = map[int]*zstd.Encoder{}
+	}
+	zstdEncoders.byLevel[level] = enc
+	return ZstdCodec{Encoder: enc}, nil
+}
+
+// CodecNew creates the codec for the config.
+func CodecNew(c bp.Codec, level int) (BlockCodec, error) {
+	switch c {
+	case bp.CodecNull:
+		return NullCodec{}, nil
+	case bp.CodecDeflate:
+		return DeflateCodecNew(level)
+	case bp.CodecSnappy:
+		return SnappyCodec{}, nil
+	case bp.CodecZstd:
+		return ZstdCodecNew(level)
+	case bp.CodecBzip2:
+		return Bzip2CodecNew(level)
+	case bp.CodecXz:
+		return XzCodec, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", bp.ErrUnknownCodec, c)
+	}
+}
+
+// CodecSelector chooses the codec using the first (uncompressed) blocks
+// (see OcfConfig.SelectBytes).
+type CodecSelector func(firstBlock []byte) (BlockCodec, error)
+
+// SelectBySize chooses the small codec if the blocks are smaller than the
+// threshold.
+func SelectBySize(small, large BlockCodec, threshold int) CodecSelector {
+	return func(firstBlock []byte) (BlockCodec, error) {
+		switch len(firstBlock) < threshold {
+		case true:
+			return small, nil
+		default:
+			return large, nil
+		}
+	}
+}
+
+// SelectByTrial chooses the codec which compresses the block better.
+func SelectByTrial(small, large BlockCodec) CodecSelector {
+	return func(firstBlock []byte) (BlockCodec, error) {
+		s, e := small.Encode(firstBlock)
+		if nil != e {
+			return nil, e
+		}
+		var slen int = len(s) // s may be firstBlock itself(null codec)
+
+		l, e := large.Encode(firstBlock)
+		if nil != e {
+			return nil, e
+		}
+
+		switch len(l) < slen {
+		case true:
+			return large, nil
+		default:
+			return small, nil
+		}
+	}
+}
+
+// ConfigToSelector creates a selector for the adaptive config.
+//
+// Returns nil if the codec is fixed.
+func ConfigToSelector(
+	large BlockCodec,
+	cfg bp.AdaptiveConfig,
+) (CodecSelector, error) {
+	switch cfg.CodecSelection {
+	case bp.CodecSelectionSize, bp.CodecSelectionTrial:
+	default:
+		return nil, nil
+	}
+
+	small, e := CodecNew(cfg.SmallCodec, bp.CompressionLevelDefault)
+	if nil != e {
+		return nil, e
+	}
+
+	switch cfg.CodecSelection {
+	case bp.CodecSelectionSize:
+		return SelectBySize(small, large, cfg.Threshold), nil
+	default:
+		return SelectByTrial(small, large), nil
+	}
+}
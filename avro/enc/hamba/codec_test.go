@@ -0,0 +1,83 @@
+package enc_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+func headerCodec(t *testing.T, filename string) bp.Codec {
+	t.Helper()
+	f, e := os.Open(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	defer f.Close()
+	h, e := eh.ReadOcfHeader(f)
+	if nil != e {
+		t.Fatal(e)
+	}
+	return bp.Codec(h.Meta[eh.OcfCodecKey])
+}
+
+// The codec of each partition is selected by the size of the partition(not
+// only of the first block) and recorded in the header and the stats.
+func TestAdaptiveCodecStats(t *testing.T) {
+	var dir string = t.TempDir()
+	var stats *eh.Stats = eh.StatsNew()
+	var fc eh.FsConfig = memConfig(nil)
+	fc.Dirname = eh.Dirname(dir)
+	fc.StatObserver = stats.ToObserver()
+	fc.Config.EncodeConfig = bp.EncodeConfig{
+		BlockLength: 2,
+		Codec:       bp.CodecZstd,
+		Adaptive: bp.AdaptiveConfig{
+			CodecSelection: bp.CodecSelectionSize,
+			SmallCodec:     bp.CodecNull,
+			Threshold:      256,
+		},
+	}
+
+	pool, e := fc.ToPool(2)
+	if nil != e {
+		t.Fatal(e)
+	}
+	var small string = filepath.Join(dir, "small.avro")
+	var large string = filepath.Join(dir, "large.avro")
+	e = pool.WriteMap(testRows(1)[0], small, eh.PartitionHeader{})
+	for _, row := range testRows(50) {
+		if nil == e {
+			e = pool.WriteMap(row, large, eh.PartitionHeader{})
+		}
+	}
+	e = errors.Join(e, pool.Close())
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	if bp.CodecNull != headerCodec(t, small) {
+		t.Fatalf("small: %v", headerCodec(t, small))
+	}
+	if bp.CodecZstd != headerCodec(t, large) {
+		t.Fatalf("large: %v", headerCodec(t, large))
+	}
+	if 50 != fileRecords(t, large) {
+		t.Fatalf("records: %v", fileRecords(t, large))
+	}
+
+	var expected map[bp.Codec]eh.CodecStat = map[bp.Codec]eh.CodecStat{
+		bp.CodecNull: {Files: 1, Records: 1},
+		bp.CodecZstd: {Files: 1, Records: 50},
+	}
+	for codec, c := range expected {
+		var actual eh.CodecStat = stats.ByCodec[codec]
+		if c.Files != actual.Files || c.Records != actual.Records {
+			t.Fatalf("%s: %v", codec, actual)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package enc
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+
+	"lukechampine.com/blake3"
+)
+
+var ErrUnknownDigest error = errors.New("unknown digest algorithm")
+
+// DigestAlgorithm computes the checksums of the partitions.
+type DigestAlgorithm string
+
+const (
+	DigestNone   DigestAlgorithm = ""
+	DigestSha256 DigestAlgorithm = "sha256"
+	DigestBlake3 DigestAlgorithm = "blake3"
+)
+
+func StringToDigestAlgorithm(s string) (DigestAlgorithm, error) {
+	switch s {
+	case "", "none":
+		return DigestNone, nil
+	case "sha256":
+		return DigestSha256, nil
+	case "blake3", "b3":
+		return DigestBlake3, nil
+	default:
+		return DigestNone, fmt.Errorf("%w: %s", ErrUnknownDigest, s)
+	}
+}
+
+func (a DigestAlgorithm) newHash() hash.Hash {
+	switch a {
+	case DigestSha256:
+		return sha256.New()
+	case DigestBlake3:
+		return blake3.New(32, nil)
+	default:
+		return nil
+	}
+}
+
+// Ext returns the extension of the sidecar(e.g, .sha256).
+func (a DigestAlgorithm) Ext() string {
+	switch a {
+	case DigestBlake3:
+		return ".b3"
+	default:
+		return "." + string(a)
+	}
+}
+
+func (a DigestAlgorithm) toChecksum(sum []byte) string {
+	return string(a) + ":" + hex.EncodeToString(sum)
+}
+
+// DigestWriter hashes the bytes written to the underlying writer.
+type DigestWriter struct {
+	io.Writer
+	algo DigestAlgorithm
+	h    hash.Hash
+	size int64
+}
+
+// NewWriter creates the writer(nil for DigestNone).
+func (a DigestAlgorithm) NewWriter(w io.Writer) *DigestWriter {
+	var h hash.Hash = a.newHash()
+	if nil == h {
+		return nil
+	}
+	return &DigestWriter{Writer: w, algo: a, h: h}
+}
+
+func (d *DigestWriter) Write(data []byte) (int, error) {
+	n, e := d.Writer.Write(data)
+	_, _ = d.h.Write(data[:n]) // never returns an error
+	d.size += int64(n)
+	return n, e
+}
+
+// Digest returns the digest of the bytes written so far.
+func (d *DigestWriter) Digest() Digest {
+	return Digest{
+		Checksum: d.algo.toChecksum(d.h.Sum(nil)),
+		Size:     d.size,
+	}
+}
+
+// Hex returns the hex digest of the checksum(e.g, sha256:e3b0... -> e3b0...).
+func (d Digest) Hex() string {
+	_, hexed, found := strings.Cut(d.Checksum, ":")
+	if !found {
+		return d.Checksum
+	}
+	return hexed
+}
+
+// Algorithm returns the algorithm of the checksum.
+func (d Digest) Algorithm() DigestAlgorithm {
+	algo, _, _ := strings.Cut(d.Checksum, ":")
+	return DigestAlgorithm(algo)
+}
+
+// Digest is the checksum of a whole partition.
+type Digest struct {
+	// The algorithm and the hex digest(e.g, sha256:e3b0...).
+	Checksum string `json:"checksum"`
+
+	// The size of the whole partition.
+	Size int64 `json:"size"`
+
+	// True if the partition had existing content(e.g, appended).
+	Appended bool `json:"appended"`
+}
+
+// DigestStatesMaxDefault is the number of the running digests kept by
+// DigestStatesNew(about 17KiB each for blake3).
+const DigestStatesMaxDefault int = 1024
+
+type digestState struct {
+	name string
+	h    hash.Hash
+	size int64
+}
+
+// DigestStates keeps the running digests of the committed partitions; a
+// partition appended again(e.g, reopened by the pool) continues its digest
+// instead of being read again.
+//
+// The least recently committed digests are dropped if more than Max
+// partitions are kept. DigestStates is safe for concurrent use.
+type DigestStates struct {
+	Max int
+
+	mu     sync.Mutex
+	lru    *list.List
+	states map[string]*list.Element
+}
+
+func DigestStatesNew() *DigestStates {
+	return &DigestStates{
+		Max:    DigestStatesMaxDefault,
+		lru:    list.New(),
+		states: map[string]*list.Element{},
+	}
+}
+
+// take removes the digest of the partition of the size.
+func (s *DigestStates) take(name string, size int64) (digestState, bool) {
+	if nil == s {
+		return digestState{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, found := s.states[name]
+	if !found {
+		return digestState{}, false
+	}
+	s.lru.Remove(elem)
+	delete(s.states, name)
+	var st digestState = elem.Value.(digestState)
+	return st, size == st.size
+}
+
+func (s *DigestStates) put(st digestState) {
+	if nil == s {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, found := s.states[st.name]
+	if found {
+		s.lru.Remove(elem)
+	}
+	s.states[st.name] = s.lru.PushFront(st)
+	for s.Max < s.lru.Len() {
+		var back *list.Element = s.lru.Back()
+		s.lru.Remove(back)
+		delete(s.states, back.Value.(digestState).name)
+	}
+}
+
+// digestPartition hashes the written bytes while they are sequential.
+//
+// The partition will be read again on commit if the bytes were not written
+// sequentially(e.g, appended OCF without the running digest).
+type digestPartition struct {
+	PartitionWriter
+	algo   DigestAlgorithm
+	h      hash.Hash
+	states *DigestStates
+
+	pos      int64
+	streamed int64
+
+	// the partition had existing content
+	existing bool
+
+	// the bytes were not written sequentially
+	broken bool
+}
+
+func (d *digestPartition) Write(data []byte) (int, error) {
+	n, e := d.PartitionWriter.Write(data)
+	switch d.pos == d.streamed && !d.broken {
+	case true:
+		_, _ = d.h.Write(data[:n]) // never returns an error
+		d.streamed += int64(n)
+	default:
+		d.broken = true
+	}
+	d.pos += int64(n)
+	return n, e
+}
+
+// Unwrap returns the partition; it may be modified without the digest
+// partition(e.g, truncated) only if the digest will be computed again.
+func (d *digestPartition) Unwrap() PartitionWriter { return d.PartitionWriter }
+
+func (d *digestPartition) sum() Digest {
+	return Digest{
+		Checksum: d.algo.toChecksum(d.h.Sum(nil)),
+		Size:     d.streamed,
+		Appended: d.existing || d.broken,
+	}
+}
+
+// Digest returns the digest of the partition written so far.
+func (d *digestPartition) Digest() (Digest, error) {
+	return d.sum(), nil
+}
+
+// Commit keeps the running digest unless it requires reading the partition.
+func (d *digestPartition) Commit() error {
+	e := d.PartitionWriter.Commit()
+	if nil == e && !d.broken {
+		d.states.put(digestState{name: d.Name(), h: d.h, size: d.streamed})
+	}
+	return e
+}
+
+func (d *digestPartition) seeked(pos int64) {
+	d.broken = d.broken || d.streamed < pos
+}
+
+// seekableDigestPartition allows appending to the existing partition.
+type seekableDigestPartition struct {
+	*digestPartition
+	readSeeker
+}
+
+// Digest reads the whole partition again unless the digest covers it.
+func (s seekableDigestPartition) Digest() (Digest, error) {
+	var d *digestPartition = s.digestPartition
+	size, e := s.rs.Seek(0, io.SeekEnd)
+	if nil != e {
+		return Digest{}, e
+	}
+	d.pos = size
+	if !d.broken && size == d.streamed {
+		return d.sum(), nil
+	}
+
+	_, e = s.rs.Seek(0, io.SeekStart)
+	if nil != e {
+		return Digest{}, e
+	}
+	d.h.Reset()
+	size, e = io.Copy(d.h, s.rs)
+	if nil != e {
+		return Digest{}, e
+	}
+	d.pos = size
+	d.streamed = size
+	var dg Digest = d.sum()
+	d.broken = false
+	return dg, nil
+}
+
+// Wrap computes the digest of the partition(no wrap for DigestNone).
+func (a DigestAlgorithm) Wrap(w PartitionWriter) PartitionWriter {
+	wrapped, _ := a.WrapStates(w, nil)
+	return wrapped
+}
+
+// WrapStates continues the running digest of the states if the partition is
+// unchanged since its commit.
+func (a DigestAlgorithm) WrapStates(
+	w PartitionWriter,
+	states *DigestStates,
+) (PartitionWriter, error) {
+	var h hash.Hash = a.newHash()
+	if nil == h {
+		return w, nil
+	}
+
+	var d *digestPartition = &digestPartition{
+		PartitionWriter: w,
+		algo:            a,
+		h:               h,
+		states:          states,
+	}
+	rs, seekable := w.(io.ReadSeeker)
+	if !seekable {
+		return d, nil
+	}
+
+	size, e := existingSize(rs)
+	if nil != e {
+		return nil, e
+	}
+	d.existing = 0 < size
+	st, valid := states.take(w.Name(), size)
+	if valid {
+		d.h = st.h
+		d.streamed = st.size
+	}
+	return seekableDigestPartition{
+		digestPartition: d,
+		readSeeker:      readSeeker{rs: rs, pos: &d.pos, seeked: d.seeked},
+	}, nil
+}
+
+// PartitionDigest returns the digest of the partition(false: no digest).
+//
+// The wrapped partition(e.g, encrypted) will be used if the partition has no
+// digest.
+func PartitionDigest(w PartitionWriter) (Digest, bool, error) {
+	for nil != w {
+		d, ok := w.(interface{ Digest() (Digest, error) })
+		if ok {
+			dg, e := d.Digest()
+			return dg, nil == e, e
+		}
+		w = UnwrapPartition(w)
+	}
+	return Digest{}, false, nil
+}
+
+// DigestSink computes the digests of the partitions of the sink.
+type DigestSink struct {
+	Sink
+	DigestAlgorithm
+
+	// Keeps the running digests(nil: the appended partitions are read again).
+	States *DigestStates
+}
+
+func (d DigestSink) wrap(w PartitionWriter, e error) (PartitionWriter, error) {
+	if nil != e {
+		return nil, e
+	}
+	wrapped, e := d.DigestAlgorithm.WrapStates(w, d.States)
+	if nil != e {
+		return nil, errors.Join(e, w.Abort())
+	}
+	return wrapped, nil
+}
+
+func (d DigestSink) Create(path string) (PartitionWriter, error) {
+	return d.wrap(d.Sink.Create(path))
+}
+
+func (d DigestSink) Reopen(name string) (PartitionWriter, error) {
+	return d.wrap(d.Sink.Reopen(name))
+}
+
+func (d DigestSink) Close() error { return CloseSink(d.Sink) }
@@ -0,0 +1,112 @@
+package enc_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+func sha256Checksum(data string) string {
+	var sum [32]byte = sha256.Sum256([]byte(data))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// appendDigest appends the data to the partition and returns the digest.
+func appendDigest(
+	t *testing.T,
+	sink eh.Sink,
+	name string,
+	data string,
+) eh.Digest {
+	t.Helper()
+	w, e := sink.Create(name)
+	if nil != e {
+		t.Fatal(e)
+	}
+	_, e = w.(io.Seeker).Seek(0, io.SeekEnd)
+	if nil != e {
+		t.Fatal(e)
+	}
+	writePartition(t, w, data)
+	dg, found, e := eh.PartitionDigest(w)
+	if nil != e || !found {
+		t.Fatalf("no digest: %v", e)
+	}
+	e = w.Commit()
+	if nil != e {
+		t.Fatal(e)
+	}
+	return dg
+}
+
+func TestDigestStates(t *testing.T) {
+	var cases = []struct {
+		name     string
+		states   *eh.DigestStates
+		replaced string
+		expected string
+	}{
+		// the running digest is continued(the replaced content is not read)
+		{"running", eh.DigestStatesNew(), "xyz", "abcdef"},
+		{"no-states", nil, "xyz", "xyzdef"},
+		// the partition changed since the commit
+		{"resized", eh.DigestStatesNew(), "abcd", "abcddef"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var name string = filepath.Join(t.TempDir(), "k.avro")
+			var sink eh.Sink = eh.DigestSink{
+				Sink:            eh.FsSink{ExistPolicy: eh.ExistAppend},
+				DigestAlgorithm: eh.DigestSha256,
+				States:          c.states,
+			}
+
+			var dg eh.Digest = appendDigest(t, sink, name, "abc")
+			if sha256Checksum("abc") != dg.Checksum || dg.Appended {
+				t.Fatalf("unexpected digest: %v", dg)
+			}
+
+			e := os.WriteFile(name, []byte(c.replaced), 0o644)
+			if nil != e {
+				t.Fatal(e)
+			}
+			dg = appendDigest(t, sink, name, "def")
+			if sha256Checksum(c.expected) != dg.Checksum || !dg.Appended {
+				t.Fatalf("unexpected digest: %v", dg)
+			}
+			if int64(len(c.expected)) != dg.Size {
+				t.Fatalf("unexpected size: %v", dg.Size)
+			}
+		})
+	}
+}
+
+func TestDigestStatesMax(t *testing.T) {
+	var states *eh.DigestStates = eh.DigestStatesNew()
+	states.Max = 1
+	var dir string = t.TempDir()
+	var sink eh.Sink = eh.DigestSink{
+		Sink:            eh.FsSink{ExistPolicy: eh.ExistAppend},
+		DigestAlgorithm: eh.DigestSha256,
+		States:          states,
+	}
+	var a string = filepath.Join(dir, "a.avro")
+	var b string = filepath.Join(dir, "b.avro")
+	appendDigest(t, sink, a, "abc")
+	appendDigest(t, sink, b, "abc")
+
+	// the digest of a was dropped; a is read again
+	e := os.WriteFile(a, []byte("xyz"), 0o644)
+	if nil != e {
+		t.Fatal(e)
+	}
+	var dg eh.Digest = appendDigest(t, sink, a, "def")
+	if sha256Checksum("xyzdef") != dg.Checksum {
+		t.Fatalf("unexpected digest: %v", dg)
+	}
+}
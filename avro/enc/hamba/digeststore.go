@@ -0,0 +1,127 @@
+package enc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrUnknownDigestStore error = errors.New("unknown digest store")
+	ErrXattrUnsupported   error = errors.New("extended attributes unsupported")
+
+	ErrDigestStoreUnsupported error = errors.New("digest store unsupported by the sink")
+)
+
+// DigestStore decides where the digests of the partitions are kept.
+type DigestStore string
+
+const (
+	// DigestStoreManifest keeps the digests in the manifest only.
+	DigestStoreManifest DigestStore = "manifest"
+
+	// DigestStoreSidecar writes key.avro.sha256(sha256sum -c compatible).
+	DigestStoreSidecar DigestStore = "sidecar"
+
+	// DigestStoreXattr sets the extended attribute user.checksum.sha256.
+	DigestStoreXattr DigestStore = "xattr"
+)
+
+func StringToDigestStore(s string) (DigestStore, error) {
+	switch s {
+	case "", "manifest":
+		return DigestStoreManifest, nil
+	case "sidecar":
+		return DigestStoreSidecar, nil
+	case "xattr":
+		return DigestStoreXattr, nil
+	default:
+		return DigestStoreManifest, fmt.Errorf("%w: %s", ErrUnknownDigestStore, s)
+	}
+}
+
+// The prefix of the extended attribute names(e.g, user.checksum.sha256).
+const XattrPrefix string = "user.checksum."
+
+// XattrName returns the name of the extended attribute of the algorithm.
+func XattrName(a DigestAlgorithm) string { return XattrPrefix + string(a) }
+
+// SidecarName returns the name of the sidecar of the partition file.
+func SidecarName(filename string, a DigestAlgorithm) string {
+	return filename + a.Ext()
+}
+
+// WriteSidecar writes "<hex>  <basename>" next to the partition file.
+func WriteSidecar(
+	filename string,
+	d Digest,
+	sync func(*os.File) error,
+) error {
+	var name string = SidecarName(filename, d.Algorithm())
+	var line string = d.Hex() + "  " + filepath.Base(filename) + "\n"
+
+	var tmp string = name + ".tmp"
+	f, e := os.Create(tmp)
+	if nil != e {
+		return e
+	}
+	_, e = f.WriteString(line)
+	if nil == e && nil != sync {
+		e = sync(f)
+	}
+	e = errors.Join(e, f.Close())
+	if nil != e {
+		return errors.Join(e, os.Remove(tmp))
+	}
+	return os.Rename(tmp, name)
+}
+
+// SetXattr sets the hex digest as the extended attribute of the file.
+func SetXattr(filename string, d Digest) error {
+	return setxattr(filename, XattrName(d.Algorithm()), []byte(d.Hex()))
+}
+
+// ToObserver stores the digests of the local partition files.
+//
+// Nothing will be stored for DigestStoreManifest(the manifest observes the
+// stats) or for the partitions without digests. The sidecars of the removed
+// partitions are removed.
+func (s DigestStore) ToObserver(sync func(*os.File) error) StatObserver {
+	return func(stat PartitionStat) error {
+		if stat.Removed && DigestStoreSidecar == s {
+			return errors.Join(
+				RemoveFile(SidecarName(stat.Filename, DigestSha256)),
+				RemoveFile(SidecarName(stat.Filename, DigestBlake3)),
+			)
+		}
+		if stat.Skipped || stat.Removed || nil == stat.Digest {
+			return nil
+		}
+		switch s {
+		case DigestStoreSidecar:
+			return WriteSidecar(stat.Filename, *stat.Digest, sync)
+		case DigestStoreXattr:
+			return SetXattr(stat.Filename, *stat.Digest)
+		default:
+			return nil
+		}
+	}
+}
+
+// WithDigestStore stores the digests of the partitions(sha256 by default).
+//
+// Sidecars and extended attributes require local files(no Sink).
+func (f FsConfig) WithDigestStore(s DigestStore) (FsConfig, error) {
+	if DigestStoreManifest != s && nil != f.Sink {
+		return f, fmt.Errorf("%w: %s", ErrDigestStoreUnsupported, s)
+	}
+	if DigestNone == f.DigestAlgorithm {
+		f.DigestAlgorithm = DigestSha256
+	}
+	f.StatObserver = StatObservers(
+		f.StatObserver,
+		s.ToObserver(f.ToFsync()),
+	)
+	return f, nil
+}
@@ -0,0 +1,191 @@
+package enc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	ec "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/encryption"
+)
+
+// Encryption encrypts the partitions using the Key.
+type Encryption struct {
+	Key ec.Key
+
+	// Decrypts the existing partitions to append(the Key is always used).
+	Keyring ec.Keyring
+
+	// zero: ec.ChunkSizeDefault
+	ChunkSize int
+}
+
+func (e Encryption) keyring() ec.Keyring {
+	var ret ec.Keyring = ec.KeyringNew(e.Key)
+	for id, key := range e.Keyring {
+		_, found := ret[id]
+		if !found {
+			ret[id] = key
+		}
+	}
+	return ret
+}
+
+// Seal encrypts the whole data(e.g, a blob).
+func (e Encryption) Seal(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	err := e.Key.Encrypt(&buf, data, e.ChunkSize)
+	return buf.Bytes(), err
+}
+
+// encryptedStream encrypts the written bytes.
+type encryptedStream struct {
+	under    PartitionWriter
+	enc      *ec.Writer
+	finished bool
+}
+
+func (s *encryptedStream) Name() string                   { return s.under.Name() }
+func (s *encryptedStream) Write(data []byte) (int, error) { return s.enc.Write(data) }
+func (s *encryptedStream) Unwrap() PartitionWriter        { return s.under }
+func (s *encryptedStream) Abort() error                   { return s.under.Abort() }
+
+func (s *encryptedStream) Finish() error {
+	if s.finished {
+		return nil
+	}
+	s.finished = true
+	return s.enc.Close()
+}
+
+func (s *encryptedStream) Commit() error {
+	e := s.Finish()
+	if nil != e {
+		return errors.Join(e, s.under.Abort())
+	}
+	return s.under.Commit()
+}
+
+// encryptedBuffer keeps the decrypted partition to append to it.
+//
+// The whole partition will be encrypted again to a replacement(e.g, a
+// temporary file) which keeps the existing partition until the commit. The
+// partitions which can not be replaced are overwritten and truncated.
+type encryptedBuffer struct {
+	MemFile
+	under    PartitionWriter
+	size     int64
+	enc      Encryption
+	finished bool
+}
+
+func (b *encryptedBuffer) Name() string            { return b.under.Name() }
+func (b *encryptedBuffer) Unwrap() PartitionWriter { return b.under }
+func (b *encryptedBuffer) Abort() error            { return b.under.Abort() }
+
+func (b *encryptedBuffer) Finish() error {
+	if b.finished {
+		return nil
+	}
+	b.finished = true
+
+	e := ReplacePartition(b.under)
+	var replaced bool = nil == e
+	if !replaced && !errors.Is(e, ErrReplaceUnsupported) {
+		return e
+	}
+
+	var seeker io.Seeker = b.under.(io.Seeker)
+	_, e = seeker.Seek(0, io.SeekStart)
+	if nil != e {
+		return e
+	}
+	e = b.enc.Key.Encrypt(b.under, b.Data, b.enc.ChunkSize)
+	if nil != e {
+		return e
+	}
+
+	written, e := seeker.Seek(0, io.SeekCurrent)
+	if nil != e || replaced || b.size <= written {
+		return e
+	}
+	return TruncatePartition(b.under, written)
+}
+
+func (b *encryptedBuffer) Commit() error {
+	e := b.Finish()
+	if nil != e {
+		return errors.Join(e, b.under.Abort())
+	}
+	return b.under.Commit()
+}
+
+// Wrap encrypts the partition.
+//
+// The existing content(encrypted or not) will be decrypted to append to it.
+func (e Encryption) Wrap(w PartitionWriter) (PartitionWriter, error) {
+	rs, seekable := w.(io.ReadSeeker)
+	if seekable {
+		size, err := rs.Seek(0, io.SeekEnd)
+		if nil != err {
+			return nil, errors.Join(err, w.Abort())
+		}
+		if 0 < size {
+			return e.decrypt(w, rs, size)
+		}
+	}
+
+	enc, err := e.Key.NewWriter(w, e.ChunkSize)
+	if nil != err {
+		return nil, errors.Join(err, w.Abort())
+	}
+	return &encryptedStream{under: w, enc: enc}, nil
+}
+
+func (e Encryption) decrypt(
+	w PartitionWriter,
+	rs io.ReadSeeker,
+	size int64,
+) (PartitionWriter, error) {
+	_, err := rs.Seek(0, io.SeekStart)
+	if nil != err {
+		return nil, errors.Join(err, w.Abort())
+	}
+	r, err := e.keyring().MaybeDecrypt(rs)
+	if nil != err {
+		return nil, errors.Join(err, w.Abort())
+	}
+	plain, err := io.ReadAll(r)
+	if nil != err {
+		return nil, errors.Join(err, w.Abort())
+	}
+	return &encryptedBuffer{
+		MemFile: MemFile{Data: plain},
+		under:   w,
+		size:    size,
+		enc:     e,
+	}, nil
+}
+
+// EncryptSink encrypts the partitions of the sink.
+type EncryptSink struct {
+	Sink
+	Encryption
+}
+
+func (s EncryptSink) Create(path string) (PartitionWriter, error) {
+	w, e := s.Sink.Create(path)
+	if nil != e {
+		return nil, e
+	}
+	return s.Encryption.Wrap(w)
+}
+
+func (s EncryptSink) Reopen(name string) (PartitionWriter, error) {
+	w, e := s.Sink.Reopen(name)
+	if nil != e {
+		return nil, e
+	}
+	return s.Encryption.Wrap(w)
+}
+
+func (s EncryptSink) Close() error { return CloseSink(s.Sink) }
@@ -0,0 +1,183 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+	bs "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/blobstore"
+	ec "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/encryption"
+)
+
+var testKey ec.Key = ec.Key{Id: "k1", Secret: [ec.KeySize]byte{1, 2, 3}}
+
+var testEncryption eh.Encryption = eh.Encryption{Key: testKey}
+
+func decryptFile(t *testing.T, filename string) string {
+	t.Helper()
+	data, e := os.ReadFile(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	if !ec.Encrypted(data) {
+		t.Fatalf("not encrypted: %s", filename)
+	}
+	r, e := ec.KeyringNew(testKey).NewReader(bytes.NewReader(data))
+	if nil != e {
+		t.Fatal(e)
+	}
+	plain, e := io.ReadAll(r)
+	if nil != e {
+		t.Fatal(e)
+	}
+	return string(plain)
+}
+
+func appendEncrypted(
+	t *testing.T,
+	name string,
+	data string,
+) eh.PartitionWriter {
+	t.Helper()
+	w, e := eh.EncryptSink{
+		Sink:       eh.FsSink{ExistPolicy: eh.ExistAppend},
+		Encryption: testEncryption,
+	}.Create(name)
+	if nil != e {
+		t.Fatal(e)
+	}
+	seeker, seekable := w.(io.Seeker)
+	if seekable {
+		_, e = seeker.Seek(0, io.SeekEnd)
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+	writePartition(t, w, data)
+	return w
+}
+
+// The appended partition is encrypted to a temporary file which replaces
+// the partition on commit.
+func TestEncryptedAppendReplace(t *testing.T) {
+	var dir string = t.TempDir()
+	var name string = filepath.Join(dir, "k.avro")
+	e := appendEncrypted(t, name, "abc").Commit()
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	var w eh.PartitionWriter = appendEncrypted(t, name, "def")
+	e = eh.FinishPartition(w)
+	if nil != e {
+		t.Fatal(e)
+	}
+	// the partition is kept until the commit
+	if "abc" != decryptFile(t, name) {
+		t.Fatal("partition modified before the commit")
+	}
+	e = w.Abort()
+	if nil != e {
+		t.Fatal(e)
+	}
+	if "abc" != decryptFile(t, name) || 1 != len(dirNames(t, dir)) {
+		t.Fatalf("not rolled back: %v", dirNames(t, dir))
+	}
+
+	e = appendEncrypted(t, name, "def").Commit()
+	if nil != e {
+		t.Fatal(e)
+	}
+	if "abcdef" != decryptFile(t, name) || 1 != len(dirNames(t, dir)) {
+		t.Fatalf("unexpected partition: %v", dirNames(t, dir))
+	}
+}
+
+func TestBlobEncrypted(t *testing.T) {
+	var dir string = t.TempDir()
+	var enc eh.Encryption = testEncryption
+	var b *eh.BlobExtractor = eh.BlobConfig{
+		BlobMode:    eh.BlobSidecar,
+		Fields:      []string{"data"},
+		JsonSidecar: true,
+		FsyncType:   eh.FsyncFast,
+		Encryption:  &enc,
+	}.ToExtractor()
+
+	var partition string = filepath.Join(dir, "k.avro")
+	for _, blob := range []string{"blob1", "blob2"} {
+		_, e := b.Extract(partition, map[string]any{"data": []byte(blob)})
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+
+	blobs, e := filepath.Glob(filepath.Join(dir, "*.bin"))
+	if nil != e || 2 != len(blobs) {
+		t.Fatalf("unexpected blobs: %v %v", blobs, e)
+	}
+	for _, blob := range blobs {
+		if !strings.HasPrefix(decryptFile(t, blob), "blob") {
+			t.Fatalf("unexpected blob: %s", blob)
+		}
+	}
+
+	// the sidecar without the partition is sealed on close
+	e = b.Close()
+	if nil != e {
+		t.Fatal(e)
+	}
+	var sidecar string = eh.JsonSidecarFilename(partition)
+	var lines string = decryptFile(t, sidecar)
+	if 2 != strings.Count(lines, "\n") {
+		t.Fatalf("unexpected json sidecar: %s", lines)
+	}
+
+	// the next run appends to the sealed sidecar
+	var cfg eh.BlobConfig = b.BlobConfig
+	cfg.SidecarPolicy = eh.ExistAppend
+	var next *eh.BlobExtractor = cfg.ToExtractor()
+	_, e = next.Extract(partition, map[string]any{"data": []byte("blob3")})
+	e = errors.Join(e, next.Close())
+	if nil != e {
+		t.Fatal(e)
+	}
+	lines = decryptFile(t, sidecar)
+	if 3 != strings.Count(lines, "\n") {
+		t.Fatalf("unexpected json sidecar: %s", lines)
+	}
+}
+
+func TestCasEncrypted(t *testing.T) {
+	var enc eh.Encryption = testEncryption
+	var cas eh.CasConfig = eh.CasConfig{
+		Fields: []string{"data"},
+		Store: bs.Store{
+			Root:   t.TempDir(),
+			FanOut: bs.FanOutDefault,
+			Seal:   enc.Seal,
+		},
+	}
+	converted, e := cas.Dedup(map[string]any{"data": []byte("large blob")})
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	// the digest of the plain blob
+	var digest string = bs.Digest([]byte("large blob"))
+	if digest != converted["data"] {
+		t.Fatalf("unexpected digest: %v", converted["data"])
+	}
+	name, e := cas.Store.ObjectPath(digest)
+	if nil != e {
+		t.Fatal(e)
+	}
+	if "large blob" != decryptFile(t, name) {
+		t.Fatal("unexpected blob")
+	}
+}
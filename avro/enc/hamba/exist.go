@@ -0,0 +1,169 @@
+package enc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownExistPolicy error = errors.New("unknown exist policy")
+	ErrSchemaMismatch     error = errors.New("schema mismatch")
+	ErrUnsupportedPolicy  error = errors.New("unsupported exist policy")
+)
+
+// ExistPolicy decides what to do when a partition file already exists.
+type ExistPolicy string
+
+const (
+	// ExistOverwrite truncates the existing file.
+	ExistOverwrite ExistPolicy = "overwrite"
+
+	// ExistSkip keeps the existing file and drops the record.
+	ExistSkip ExistPolicy = "skip"
+
+	// ExistFail aborts the run.
+	ExistFail ExistPolicy = "fail"
+
+	// ExistAppend adds records to the existing OCF(same schema only).
+	ExistAppend ExistPolicy = "append"
+
+	// ExistVersion writes key.N.avro using the first unused N.
+	ExistVersion ExistPolicy = "version"
+)
+
+func StringToExistPolicy(s string) (ExistPolicy, error) {
+	switch s {
+	case "", "overwrite":
+		return ExistOverwrite, nil
+	case "skip":
+		return ExistSkip, nil
+	case "fail":
+		return ExistFail, nil
+	case "append":
+		return ExistAppend, nil
+	case "version":
+		return ExistVersion, nil
+	default:
+		return ExistOverwrite, fmt.Errorf("%w: %s", ErrUnknownExistPolicy, s)
+	}
+}
+
+const FileModeDefault fs.FileMode = 0o644
+
+// Create opens the file to be written using the policy.
+//
+// The error wraps fs.ErrExist if the file exists and the policy is
+// ExistSkip or ExistFail.
+func (p ExistPolicy) Create(filename string) (*os.File, error) {
+	switch p {
+	case ExistSkip, ExistFail:
+		return os.OpenFile(
+			filename,
+			os.O_RDWR|os.O_CREATE|os.O_EXCL,
+			FileModeDefault,
+		)
+	case ExistAppend:
+		return os.OpenFile(
+			filename,
+			os.O_RDWR|os.O_CREATE,
+			FileModeDefault,
+		)
+	case ExistVersion:
+		return CreateVersioned(filename)
+	default:
+		return os.Create(filename)
+	}
+}
+
+// Skip returns true if the error from Create can be ignored.
+func (p ExistPolicy) Skip(e error) bool {
+	return ExistSkip == p && errors.Is(e, fs.ErrExist)
+}
+
+// VersionedName converts path/to/key.avro to path/to/key.N.avro.
+func VersionedName(filename string, version int) string {
+	var ext string = filepath.Ext(filename)
+	var noext string = strings.TrimSuffix(filename, ext)
+	return noext + "." + strconv.Itoa(version) + ext
+}
+
+// CreateVersioned creates the filename or the first unused versioned name.
+func CreateVersioned(filename string) (*os.File, error) {
+	var name string = filename
+	for version := 1; ; version++ {
+		f, e := os.OpenFile(
+			name,
+			os.O_RDWR|os.O_CREATE|os.O_EXCL,
+			FileModeDefault,
+		)
+		if !errors.Is(e, fs.ErrExist) {
+			return f, e
+		}
+		name = VersionedName(filename, version)
+	}
+}
+
+// LoadValue reads the value of the key(found: false if missing).
+type LoadValue func(key string) (data []byte, found bool, err error)
+
+// ValueExists checks the key without reading the value.
+type ValueExists func(key string) (bool, error)
+
+// CheckValue rejects the policies unsupported by the sinks which keep the
+// partitions as values(e.g, rows of a database or objects of a bucket).
+func (p ExistPolicy) CheckValue() error {
+	switch p {
+	case ExistOverwrite, ExistSkip, ExistFail, ExistAppend, "":
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedPolicy, p)
+	}
+}
+
+// CreateValue returns the initial content of the value of the key(nil:
+// empty) using the policy.
+//
+// The exists checks the key for ExistSkip and ExistFail(nil: the load).
+// The error wraps fs.ErrExist if the value exists and the policy is
+// ExistSkip(with ErrSkipPartition) or ExistFail.
+func (p ExistPolicy) CreateValue(
+	key string,
+	load LoadValue,
+	exists ValueExists,
+) ([]byte, error) {
+	e := p.CheckValue()
+	if nil != e {
+		return nil, e
+	}
+	if nil == exists {
+		exists = func(key string) (bool, error) {
+			_, found, e := load(key)
+			return found, e
+		}
+	}
+
+	switch p {
+	case ExistAppend:
+		data, _, e := load(key)
+		return data, e
+	case ExistSkip, ExistFail:
+		found, e := exists(key)
+		switch {
+		case nil != e:
+			return nil, e
+		case !found:
+			return nil, nil
+		case ExistSkip == p:
+			return nil, fmt.Errorf("%w: %w", ErrSkipPartition, fs.ErrExist)
+		default:
+			return nil, fmt.Errorf("%s: %w", key, fs.ErrExist)
+		}
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,189 @@
+package enc_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+const otherSchema string = `{
+	"type": "record",
+	"name": "Other",
+	"fields": [
+		{"name": "id", "type": "long"}
+	]
+}`
+
+// existConfig writes the partitions under the dir using the policy.
+func existConfig(
+	dir string,
+	policy eh.ExistPolicy,
+	stats *[]eh.PartitionStat,
+) eh.FsConfig {
+	var fc eh.FsConfig = memConfig(nil)
+	fc.Dirname = eh.Dirname(dir)
+	fc.ExistPolicy = policy
+	fc.StatObserver = func(s eh.PartitionStat) error {
+		*stats = append(*stats, s)
+		return nil
+	}
+	return fc
+}
+
+func fileRecords(t *testing.T, filename string) int {
+	t.Helper()
+	f, e := os.Open(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	defer f.Close()
+	rows, e := decodeRows(t, f)
+	if nil != e {
+		t.Fatal(e)
+	}
+	return len(rows)
+}
+
+// writeExisting writes a partition of a record using the overwrite policy.
+func writeExisting(t *testing.T, filename string) []byte {
+	t.Helper()
+	var stats []eh.PartitionStat
+	var fc eh.FsConfig = existConfig(
+		filepath.Dir(filename),
+		eh.ExistOverwrite,
+		&stats,
+	)
+	e := fc.WriteMap(testRows(1)[0], filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	data, e := os.ReadFile(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	return data
+}
+
+func TestCreateVersioned(t *testing.T) {
+	var dir string = t.TempDir()
+	var filename string = filepath.Join(dir, "k.avro")
+	for _, name := range []string{"k.avro", "k.2.avro"} {
+		e := os.WriteFile(filepath.Join(dir, name), nil, 0o644)
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+
+	// the first unused version
+	for _, expected := range []string{"k.1.avro", "k.3.avro"} {
+		f, e := eh.CreateVersioned(filename)
+		if nil != e {
+			t.Fatal(e)
+		}
+		_ = f.Close()
+		if expected != filepath.Base(f.Name()) {
+			t.Fatalf("unexpected name: %s", f.Name())
+		}
+	}
+}
+
+func TestExistVersion(t *testing.T) {
+	var dir string = t.TempDir()
+	var stats []eh.PartitionStat
+	var fc eh.FsConfig = existConfig(dir, eh.ExistVersion, &stats)
+	for _, row := range testRows(3) {
+		e := fc.WriteMap(row, filepath.Join(dir, "k.avro"))
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+
+	var expected []string = []string{"k.avro", "k.1.avro", "k.2.avro"}
+	for i, stat := range stats {
+		if filepath.Join(dir, expected[i]) != stat.Filename {
+			t.Fatalf("unexpected stat: %v", stat)
+		}
+		if 1 != fileRecords(t, stat.Filename) {
+			t.Fatalf("unexpected records: %s", stat.Filename)
+		}
+	}
+	if 3 != len(stats) {
+		t.Fatalf("unexpected stats: %v", stats)
+	}
+}
+
+func TestExistSkip(t *testing.T) {
+	var dir string = t.TempDir()
+	var filename string = filepath.Join(dir, "k.avro")
+	var before []byte = writeExisting(t, filename)
+
+	var stats []eh.PartitionStat
+	var fc eh.FsConfig = existConfig(dir, eh.ExistSkip, &stats)
+	e := fc.WriteMap(testRows(2)[1], filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	// the skipped partition is reported
+	if 1 != len(stats) || !stats[0].Skipped || filename != stats[0].Filename {
+		t.Fatalf("unexpected stats: %v", stats)
+	}
+	after, e := os.ReadFile(filename)
+	if nil != e || !slices.Equal(before, after) {
+		t.Fatalf("partition modified: %v", e)
+	}
+}
+
+func TestExistFail(t *testing.T) {
+	var dir string = t.TempDir()
+	var filename string = filepath.Join(dir, "k.avro")
+	var before []byte = writeExisting(t, filename)
+
+	var stats []eh.PartitionStat
+	var fc eh.FsConfig = existConfig(dir, eh.ExistFail, &stats)
+	e := fc.WriteMap(testRows(2)[1], filename)
+	if !errors.Is(e, fs.ErrExist) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+
+	after, e := os.ReadFile(filename)
+	if nil != e || !slices.Equal(before, after) {
+		t.Fatalf("partition modified: %v", e)
+	}
+	if 0 != len(stats) || 1 != len(dirNames(t, dir)) {
+		t.Fatalf("unexpected stats: %v", stats)
+	}
+}
+
+func TestExistAppend(t *testing.T) {
+	var dir string = t.TempDir()
+	var filename string = filepath.Join(dir, "k.avro")
+	var before []byte = writeExisting(t, filename)
+
+	// a different schema is rejected
+	var stats []eh.PartitionStat
+	var other eh.FsConfig = existConfig(dir, eh.ExistAppend, &stats)
+	other.Config.Schema = otherSchema
+	e := other.WriteMap(map[string]any{"id": int64(1)}, filename)
+	if !errors.Is(e, eh.ErrSchemaMismatch) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	after, e := os.ReadFile(filename)
+	if nil != e || !slices.Equal(before, after) {
+		t.Fatalf("partition modified: %v", e)
+	}
+
+	var fc eh.FsConfig = existConfig(dir, eh.ExistAppend, &stats)
+	e = fc.WriteMap(testRows(2)[1], filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	if 2 != fileRecords(t, filename) {
+		t.Fatalf("unexpected records: %v", fileRecords(t, filename))
+	}
+}
@@ -0,0 +1,346 @@
+package enc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ha "github.com/hamba/avro/v2"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+
+	ss "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/schemastore"
+)
+
+var ErrUnknownOutputFormat error = errors.New("unknown output format")
+
+// OutputFormat decides the encoding of the partition files.
+type OutputFormat string
+
+const (
+	// OutputOcf writes object container files.
+	OutputOcf OutputFormat = "ocf"
+
+	// OutputSingleObject writes each record with the single object header
+	// (the marker and the CRC-64-AVRO fingerprint of the schema).
+	OutputSingleObject OutputFormat = "single-object"
+
+	// OutputRaw writes the concatenated binary datums without any header.
+	OutputRaw OutputFormat = "raw"
+
+	// OutputJson writes the Avro JSON encoding(one record per line).
+	OutputJson OutputFormat = "json"
+)
+
+func StringToOutputFormat(s string) (OutputFormat, error) {
+	switch s {
+	case "", "ocf":
+		return OutputOcf, nil
+	case "single-object", "soe":
+		return OutputSingleObject, nil
+	case "raw", "binary":
+		return OutputRaw, nil
+	case "json":
+		return OutputJson, nil
+	default:
+		return OutputOcf, fmt.Errorf("%w: %s", ErrUnknownOutputFormat, s)
+	}
+}
+
+// Ext returns the file extension of the format.
+func (f OutputFormat) Ext() Ext {
+	switch f {
+	case OutputSingleObject:
+		return "soe"
+	case OutputRaw:
+		return "bin"
+	case OutputJson:
+		return "json"
+	default:
+		return ExtDefault
+	}
+}
+
+// ExtToOutputFormat finds the format of the partition file extension.
+func ExtToOutputFormat(ext Ext) (OutputFormat, bool) {
+	for _, f := range OutputFormats {
+		if f.Ext() == ext {
+			return f, true
+		}
+	}
+	return OutputOcf, false
+}
+
+// FilenameToOutputFormat finds the format of the partition file.
+//
+// Sidecar blobs(e.g, key.data.0123456789abcdef.bin) are not partitions.
+func FilenameToOutputFormat(filename string) (OutputFormat, bool) {
+	var ext Ext = Ext(strings.TrimPrefix(filepath.Ext(filename), "."))
+	f, found := ExtToOutputFormat(ext)
+	if !found || (OutputRaw == f && SidecarBlob(filename)) {
+		return OutputOcf, false
+	}
+	return f, true
+}
+
+// OutputFormats lists all the formats.
+var OutputFormats []OutputFormat = []OutputFormat{
+	OutputOcf,
+	OutputSingleObject,
+	OutputRaw,
+	OutputJson,
+}
+
+// Headerless returns true if the partition files do not have the schema
+// (the json partitions are decoded using the stored schema, too).
+func (f OutputFormat) Headerless() bool {
+	return OutputOcf != f
+}
+
+// PutSchema stores the schema as the current schema of the store.
+//
+// The headerless partitions can be decoded using the stored schema.
+func (c Config) PutSchema(store ss.Store) (ss.Fingerprint, error) {
+	parsed, e := ha.Parse(c.Schema)
+	if nil != e {
+		return 0, e
+	}
+	return store.PutCurrent(parsed)
+}
+
+// PartitionSchemas keeps the fingerprint of the schema of each headerless
+// partition next to it(e.g, key.bin.fp).
+type PartitionSchemas struct {
+	ss.Store
+	ss.Fingerprint
+}
+
+// Check rejects appending to a partition written using another schema.
+func (p PartitionSchemas) Check(filename string) error {
+	fp, e := ss.PartitionFingerprint(filename)
+	if errors.Is(e, fs.ErrNotExist) {
+		return nil
+	}
+	if nil != e {
+		return e
+	}
+	_, e = os.Stat(filename)
+	if errors.Is(e, fs.ErrNotExist) {
+		return nil
+	}
+	if nil == e && fp != p.Fingerprint {
+		return fmt.Errorf(
+			"%w: %s(%s != %s)",
+			ErrSchemaMismatch,
+			filename,
+			fp,
+			p.Fingerprint,
+		)
+	}
+	return e
+}
+
+// ToObserver saves the fingerprints of the written partitions.
+func (p PartitionSchemas) ToObserver() StatObserver {
+	return func(stat PartitionStat) error {
+		switch {
+		case stat.Skipped:
+			return nil
+		case stat.Removed:
+			return RemoveFile(ss.PartitionFingerprintName(stat.Filename))
+		}
+		fp, e := ss.PartitionFingerprint(stat.Filename)
+		if nil == e && fp == p.Fingerprint {
+			return nil
+		}
+		return p.Store.PutPartition(stat.Filename, p.Fingerprint)
+	}
+}
+
+// SchemaSink checks the schemas of the partitions to be appended.
+type SchemaSink struct {
+	Sink
+	PartitionSchemas
+	ExistPolicy
+}
+
+func (s SchemaSink) Create(path string) (PartitionWriter, error) {
+	if ExistAppend == s.ExistPolicy {
+		e := s.PartitionSchemas.Check(path)
+		if nil != e {
+			return nil, e
+		}
+	}
+	return s.Sink.Create(path)
+}
+
+func (s SchemaSink) Close() error { return CloseSink(s.Sink) }
+
+// WithSchemaStore stores the schema of the headerless partitions.
+//
+// The fingerprint of each partition is kept next to it if the partitions
+// are local files(no Sink) or if the store writes elsewhere(e.g, to the
+// bucket of the Sink); otherwise only the current schema is saved.
+func (f FsConfig) WithSchemaStore(store ss.Store) (FsConfig, error) {
+	if !f.OutputFormat.Headerless() {
+		return f, nil
+	}
+	fp, e := f.Config.PutSchema(store)
+	if nil != e || (nil != f.Sink && nil == store.Write) {
+		return f, e
+	}
+	f.Schemas = &PartitionSchemas{Store: store, Fingerprint: fp}
+	f.StatObserver = StatObservers(f.StatObserver, f.Schemas.ToObserver())
+	return f, nil
+}
+
+// RecordEncoder writes records to a partition file.
+type RecordEncoder interface {
+	Encode(v any) error
+
+	// Flushes the buffered records.
+	Close() error
+
+	// The codec used(null for formats without codec).
+	Codec() bp.Codec
+
+	Records() int
+
+	// The number of bytes written so far.
+	Bytes() int64
+
+	// The number of the encoded bytes not written yet(e.g, the records of
+	// the current block before compressed).
+	Buffered() int64
+}
+
+// SingleObjectHeader creates the header: the marker and the little-endian
+// CRC-64-AVRO fingerprint of the canonical form of the schema.
+func SingleObjectHeader(s ha.Schema) ([]byte, error) {
+	fp, e := ss.FingerprintOf(s)
+	if nil != e {
+		return nil, e
+	}
+
+	var header []byte = append([]byte{}, ss.SingleObjectMarker[:]...)
+	return binary.LittleEndian.AppendUint64(header, uint64(fp)), nil
+}
+
+// DatumEncoder writes binary datums, each of them optionally prefixed.
+type DatumEncoder struct {
+	w       countWriter
+	prefix  []byte
+	records int
+	enc     *ha.Encoder
+}
+
+func DatumEncoderNew(s ha.Schema, w io.Writer, prefix []byte) *DatumEncoder {
+	d := &DatumEncoder{
+		w:      countWriter{Writer: w},
+		prefix: prefix,
+	}
+	d.enc = ha.NewEncoderForSchema(s, &d.w)
+	return d
+}
+
+func (d *DatumEncoder) Encode(v any) error {
+	if 0 < len(d.prefix) {
+		_, e := d.w.Write(d.prefix)
+		if nil != e {
+			return e
+		}
+	}
+	e := d.enc.Encode(v)
+	if nil != e {
+		return e
+	}
+	d.records++
+	return nil
+}
+
+// Close does nothing; each datum is written by Encode.
+func (d *DatumEncoder) Close() error { return nil }
+
+func (d *DatumEncoder) Codec() bp.Codec { return bp.CodecNull }
+func (d *DatumEncoder) Records() int    { return d.records }
+func (d *DatumEncoder) Bytes() int64    { return d.w.count }
+func (d *DatumEncoder) Buffered() int64 { return 0 }
+
+// JsonEncoder writes the Avro JSON encoding of each record as a line.
+type JsonEncoder struct {
+	w       countWriter
+	schema  ha.Schema
+	records int
+	buf     []byte
+}
+
+func JsonEncoderNew(s ha.Schema, w io.Writer) *JsonEncoder {
+	return &JsonEncoder{
+		w:      countWriter{Writer: w},
+		schema: s,
+	}
+}
+
+func (j *JsonEncoder) Encode(v any) error {
+	buf, e := AppendAvroJson(j.buf[:0], j.schema, v)
+	if nil != e {
+		return e
+	}
+	j.buf = append(buf, '\n')
+	_, e = j.w.Write(j.buf)
+	if nil != e {
+		return e
+	}
+	j.records++
+	return nil
+}
+
+// Close does nothing; each line is written by Encode.
+func (j *JsonEncoder) Close() error { return nil }
+
+func (j *JsonEncoder) Codec() bp.Codec { return bp.CodecNull }
+func (j *JsonEncoder) Records() int    { return j.records }
+func (j *JsonEncoder) Bytes() int64    { return j.w.count }
+func (j *JsonEncoder) Buffered() int64 { return 0 }
+
+// EncoderNew creates an encoder for the format.
+//
+// The OcfConfig is used only for OutputOcf. Other formats append the records
+// to the end of a seekable writer.
+func (f OutputFormat) EncoderNew(
+	s ha.Schema,
+	w io.Writer,
+	cfg OcfConfig,
+) (RecordEncoder, error) {
+	if OutputOcf == f || "" == f {
+		return OcfEncoderNew(s, w, cfg)
+	}
+
+	seeker, seekable := w.(io.Seeker)
+	if seekable {
+		_, e := seeker.Seek(0, io.SeekEnd)
+		if nil != e {
+			return nil, e
+		}
+	}
+
+	switch f {
+	case OutputSingleObject:
+		header, e := SingleObjectHeader(s)
+		if nil != e {
+			return nil, e
+		}
+		return DatumEncoderNew(s, w, header), nil
+	case OutputRaw:
+		return DatumEncoderNew(s, w, nil), nil
+	case OutputJson:
+		return JsonEncoderNew(s, w), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownOutputFormat, f)
+	}
+}
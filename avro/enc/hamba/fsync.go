@@ -0,0 +1,110 @@
+package enc
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+const SyncBatchFilesDefault int = 64
+
+// SyncConfig configures the group commit of the Syncer.
+type SyncConfig struct {
+	FsyncType
+
+	// Syncs every N files(batch only).
+	BatchFiles int
+
+	// Syncs if the interval elapsed since the last sync(batch only).
+	//
+	// The interval is checked when a file is written; the files written
+	// last are synced by Finish.
+	BatchInterval time.Duration
+}
+
+// Syncer syncs the written files by groups using the syncfs(2).
+//
+// The BSDs(and darwin) use the sync(2); other platforms sync each file
+// instead. Syncer is safe for concurrent use.
+type Syncer struct {
+	SyncConfig
+
+	mu      sync.Mutex
+	pending int
+	last    time.Time
+}
+
+func (c SyncConfig) ToSyncer() *Syncer {
+	if 0 == c.BatchFiles && 0 == c.BatchInterval {
+		c.BatchFiles = SyncBatchFilesDefault
+	}
+	return &Syncer{SyncConfig: c, last: time.Now()}
+}
+
+func (s *Syncer) due() bool {
+	var byCount bool = 0 < s.BatchFiles && s.BatchFiles <= s.pending
+	var byTime bool = 0 < s.BatchInterval &&
+		s.BatchInterval <= time.Since(s.last)
+	return byCount || byTime
+}
+
+// Sync syncs the file or the pending files if required.
+func (s *Syncer) Sync(f *os.File) error {
+	switch s.FsyncType {
+	case FsyncBatch, FsyncEnd:
+		if !syncfsSupported {
+			return f.Sync()
+		}
+	default:
+		return s.FsyncType.ToFsync()(f)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending++
+	if FsyncEnd == s.FsyncType || !s.due() {
+		return nil
+	}
+
+	// syncs the file system of the file(including the file)
+	e := syncfs(f)
+	s.pending = 0
+	s.last = time.Now()
+	return e
+}
+
+// ToFsync returns the Sync or the fsync of the type if the Syncer is nil.
+func (s *Syncer) ToFsync(t FsyncType) func(*os.File) error {
+	if nil == s {
+		return t.ToFsync()
+	}
+	return s.Sync
+}
+
+// Finish syncs the pending files using the file system of the dirname; a nil
+// Syncer is ignored.
+//
+// The files written after the last sync are not durable until Finish.
+func (s *Syncer) Finish(dirname string) error {
+	if nil == s {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if 0 == s.pending {
+		return nil
+	}
+
+	d, e := os.Open(dirname)
+	if nil != e {
+		return e
+	}
+	e = syncfs(d)
+	s.pending = 0
+	s.last = time.Now()
+	return errors.Join(e, d.Close())
+}
@@ -0,0 +1,17 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+package enc
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// True if the file systems can be synced(not only a file).
+const syncfsSupported bool = true
+
+func fdatasync(f *os.File) error { return f.Sync() }
+
+// syncs all the file systems
+func syncfs(_ *os.File) error { return unix.Sync() }
@@ -0,0 +1,14 @@
+package enc
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// True if the file systems can be synced(not only a file).
+const syncfsSupported bool = true
+
+func fdatasync(f *os.File) error { return unix.Fdatasync(int(f.Fd())) }
+
+func syncfs(f *os.File) error { return unix.Syncfs(int(f.Fd())) }
@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package enc
+
+import (
+	"os"
+)
+
+// True if the file systems can be synced(not only a file).
+const syncfsSupported bool = false
+
+func fdatasync(f *os.File) error { return f.Sync() }
+
+func syncfs(f *os.File) error { return f.Sync() }
@@ -0,0 +1,220 @@
+package enc_test
+
+import (
+	"errors"
+	"iter"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+
+	ec "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/encryption"
+	ss "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/schemastore"
+
+	dh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/dec/hamba"
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+const schemaV2 string = `{
+	"type": "record",
+	"name": "Row",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "label", "type": "string"}
+	]
+}`
+
+func headerlessConfig(
+	t *testing.T,
+	store ss.Store,
+	schema string,
+	f eh.OutputFormat,
+) eh.FsConfig {
+	t.Helper()
+	fc, e := eh.FsConfig{
+		Config: eh.Config{
+			Schema:       schema,
+			EncodeConfig: bp.EncodeConfigDefault,
+			OutputFormat: f,
+		},
+		FsyncType:   eh.FsyncFast,
+		Dirname:     eh.Dirname(filepath.Dir(store.Root)),
+		ExistPolicy: eh.ExistAppend,
+	}.WithSchemaStore(store)
+	if nil != e {
+		t.Fatal(e)
+	}
+	return fc
+}
+
+func collect(t *testing.T, rows iter.Seq2[map[string]any, error]) []any {
+	t.Helper()
+	var ret []any
+	for row, e := range rows {
+		if nil != e {
+			t.Fatal(e)
+		}
+		ret = append(ret, row["label"])
+	}
+	return ret
+}
+
+func readPartition(
+	t *testing.T,
+	store ss.Store,
+	ring ec.Keyring,
+	f eh.OutputFormat,
+	filename string,
+) []any {
+	t.Helper()
+	file, e := os.Open(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	defer file.Close()
+
+	if eh.OutputSingleObject == f {
+		return collect(t, dh.DecryptSingleObjectsToMaps(
+			file,
+			ring,
+			store.ToResolver(),
+			bp.DecodeConfigDefault,
+		))
+	}
+	s, e := store.PartitionSchema(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	if eh.OutputJson == f {
+		return collect(t, dh.DecryptJsonToMaps(file, ring, s))
+	}
+	return collect(t, dh.DecryptRawToMaps(file, ring, s, bp.DecodeConfigDefault))
+}
+
+var headerlessFormats []eh.OutputFormat = []eh.OutputFormat{
+	eh.OutputSingleObject,
+	eh.OutputRaw,
+	eh.OutputJson,
+}
+
+func TestHeaderlessSchemaPerPartition(t *testing.T) {
+	for _, f := range headerlessFormats {
+		t.Run(string(f), func(t *testing.T) {
+			var dir string = t.TempDir()
+			var store ss.Store = ss.Store{
+				Root: filepath.Join(dir, ss.DirnameDefault),
+			}
+			var a string = filepath.Join(dir, "a."+string(f.Ext()))
+			var b string = filepath.Join(dir, "b."+string(f.Ext()))
+
+			// the later run changes the current schema
+			e := headerlessConfig(t, store, testSchema, f).WriteMap(
+				map[string]any{"id": int64(1), "name": "n", "data": []byte("d")},
+				a,
+			)
+			if nil != e {
+				t.Fatal(e)
+			}
+			e = headerlessConfig(t, store, schemaV2, f).WriteMap(
+				map[string]any{"id": int64(2), "label": "v2"},
+				b,
+			)
+			if nil != e {
+				t.Fatal(e)
+			}
+
+			var got []any = readPartition(t, store, nil, f, a)
+			if 1 != len(got) || nil != got[0] {
+				t.Fatalf("a: %v", got)
+			}
+			got = readPartition(t, store, nil, f, b)
+			if 1 != len(got) || "v2" != got[0] {
+				t.Fatalf("b: %v", got)
+			}
+		})
+	}
+}
+
+func TestHeaderlessAppendMismatch(t *testing.T) {
+	var dir string = t.TempDir()
+	var store ss.Store = ss.Store{Root: filepath.Join(dir, ss.DirnameDefault)}
+	var a string = filepath.Join(dir, "a.bin")
+
+	e := headerlessConfig(t, store, testSchema, eh.OutputRaw).WriteMap(
+		map[string]any{"id": int64(1), "name": "n", "data": []byte("d")},
+		a,
+	)
+	if nil != e {
+		t.Fatal(e)
+	}
+	before, e := os.ReadFile(a)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	e = headerlessConfig(t, store, schemaV2, eh.OutputRaw).WriteMap(
+		map[string]any{"id": int64(2), "label": "v2"},
+		a,
+	)
+	if !errors.Is(e, eh.ErrSchemaMismatch) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	after, e := os.ReadFile(a)
+	if nil != e || string(before) != string(after) {
+		t.Fatalf("partition modified: %v", e)
+	}
+}
+
+func TestHeaderlessEncrypted(t *testing.T) {
+	var key ec.Key = ec.Key{Id: "k1", Secret: [ec.KeySize]byte{1, 2, 3}}
+	for _, f := range headerlessFormats {
+		t.Run(string(f), func(t *testing.T) {
+			var dir string = t.TempDir()
+			var store ss.Store = ss.Store{
+				Root: filepath.Join(dir, ss.DirnameDefault),
+			}
+			var a string = filepath.Join(dir, "a."+string(f.Ext()))
+
+			var fc eh.FsConfig = headerlessConfig(t, store, schemaV2, f)
+			fc.Encryption = &eh.Encryption{Key: key}
+			for _, label := range []string{"x", "y"} {
+				e := fc.WriteMap(
+					map[string]any{"id": int64(1), "label": label},
+					a,
+				)
+				if nil != e {
+					t.Fatal(e)
+				}
+			}
+
+			data, e := os.ReadFile(a)
+			if nil != e || !ec.Encrypted(data) {
+				t.Fatalf("not encrypted: %v", e)
+			}
+			var got []any = readPartition(t, store, ec.KeyringNew(key), f, a)
+			if 2 != len(got) || "x" != got[0] || "y" != got[1] {
+				t.Fatalf("unexpected rows: %v", got)
+			}
+		})
+	}
+}
+
+func TestPartitionSchemaFallback(t *testing.T) {
+	var dir string = t.TempDir()
+	var store ss.Store = ss.Store{Root: filepath.Join(dir, ss.DirnameDefault)}
+	fp, e := store.PutCurrent(mustParse(t, schemaV2))
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	// partitions without the sidecar use the current schema
+	s, e := store.PartitionSchema(filepath.Join(dir, "old.bin"))
+	if nil != e {
+		t.Fatal(e)
+	}
+	actual, e := ss.FingerprintOf(s)
+	if nil != e || fp != actual {
+		t.Fatalf("unexpected schema: %v", e)
+	}
+}
@@ -0,0 +1,285 @@
+package enc
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	ErrLimitExceeded     error = errors.New("limit exceeded")
+	ErrStatfsUnsupported error = errors.New("free space check unsupported")
+)
+
+// The free space is checked after writing this many bytes(and on create).
+const FreeCheckBytesDefault int64 = 16 * 1024 * 1024
+
+// Limits protects the target from a misconfigured run(e.g, a wrong key
+// field); zero values disable the limits.
+//
+// The limits apply to the partitions of the sink and to the blobs written
+// outside the sink(the extracted blobs, the json sidecars and the stored
+// blobs); the other sidecars(e.g, the digests) are not counted.
+type Limits struct {
+	// The maximum number of the distinct partitions(by the keys) of a run;
+	// the segments and the versions of a partition are not counted.
+	MaxPartitions int
+
+	// The maximum number of the bytes added to the target by a run.
+	MaxTotalBytes int64
+
+	// The minimum free space of the file system of the root(statfs).
+	MinFreeBytes int64
+
+	// The maximum size of a partition file.
+	MaxFileBytes int64
+}
+
+// Enabled is true if any limit is set.
+func (l Limits) Enabled() bool {
+	return 0 < l.MaxPartitions ||
+		0 < l.MaxTotalBytes ||
+		0 < l.MinFreeBytes ||
+		0 < l.MaxFileBytes
+}
+
+// Limiter checks the Limits of a run.
+//
+// The first exceeded limit fails all the following writes with the same
+// error(wrapping ErrLimitExceeded). The partitions being written are rolled
+// back; the partitions committed before(e.g, evicted by the EncoderPool) are
+// kept. Limiter is safe for concurrent use.
+type Limiter struct {
+	Limits
+
+	// The directory to check the free space.
+	Root string
+
+	mu         sync.Mutex
+	partitions map[string]struct{}
+	total      int64
+	unchecked  int64
+	exceeded   error
+}
+
+func (l Limits) ToLimiter(root string) *Limiter {
+	return &Limiter{
+		Limits:     l,
+		Root:       root,
+		partitions: map[string]struct{}{},
+	}
+}
+
+func (l *Limiter) exceed(format string, args ...any) error {
+	l.exceeded = fmt.Errorf(
+		"%w: "+format,
+		append([]any{ErrLimitExceeded}, args...)...,
+	)
+	return l.exceeded
+}
+
+// checkFree checks the free space; the lock must be held.
+func (l *Limiter) checkFree() error {
+	if l.MinFreeBytes <= 0 {
+		return nil
+	}
+	l.unchecked = 0
+	free, e := freeBytes(l.Root)
+	if nil != e {
+		return e
+	}
+	if free < l.MinFreeBytes {
+		return l.exceed(
+			"free space of %s %v < %v bytes",
+			l.Root,
+			free,
+			l.MinFreeBytes,
+		)
+	}
+	return nil
+}
+
+// Create checks the free space before a partition is created.
+func (l *Limiter) Create() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if nil != l.exceeded {
+		return l.exceeded
+	}
+	return l.checkFree()
+}
+
+// Created counts the partition of the key after it was created; the
+// partition is aborted if there are too many partitions.
+//
+// A nil limiter has no limits.
+func (l *Limiter) Created(
+	w PartitionWriter,
+	key string,
+) (PartitionWriter, error) {
+	if nil == l {
+		return w, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, found := l.partitions[key]
+	if found {
+		return w, nil
+	}
+	if 0 < l.MaxPartitions && l.MaxPartitions <= len(l.partitions) {
+		return nil, errors.Join(
+			l.exceed("more than %v partitions", l.MaxPartitions),
+			w.Abort(),
+		)
+	}
+	l.partitions[key] = struct{}{}
+	return w, nil
+}
+
+// Check returns the exceeded limit(nil: not exceeded).
+func (l *Limiter) Check() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.exceeded
+}
+
+// Reserve checks the limits before a new file(e.g, a blob) is written
+// outside the sink; a nil limiter has no limits.
+func (l *Limiter) Reserve(name string, size int64) error {
+	if nil == l {
+		return nil
+	}
+	return l.Grow(name, 0, size)
+}
+
+// Grow checks the limits before the file grows from the size.
+func (l *Limiter) Grow(name string, size int64, growth int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if nil != l.exceeded {
+		return l.exceeded
+	}
+	if 0 < l.MaxFileBytes && l.MaxFileBytes < size+growth {
+		return l.exceed("%s larger than %v bytes", name, l.MaxFileBytes)
+	}
+	if 0 < l.MaxTotalBytes && l.MaxTotalBytes < l.total+growth {
+		return l.exceed("more than %v bytes written", l.MaxTotalBytes)
+	}
+
+	l.total += growth
+	l.unchecked += growth
+	if l.unchecked < FreeCheckBytesDefault {
+		return nil
+	}
+	return l.checkFree()
+}
+
+// limitedPartition checks the limits before the file grows.
+type limitedPartition struct {
+	PartitionWriter
+	limiter *Limiter
+
+	pos  int64
+	size int64
+}
+
+func (p *limitedPartition) Write(data []byte) (int, error) {
+	var growth int64 = max(0, p.pos+int64(len(data))-p.size)
+	if 0 < growth {
+		e := p.limiter.Grow(p.Name(), p.size, growth)
+		if nil != e {
+			return 0, e
+		}
+	}
+	n, e := p.PartitionWriter.Write(data)
+	p.pos += int64(n)
+	p.size = max(p.size, p.pos)
+	return n, e
+}
+
+func (p *limitedPartition) Unwrap() PartitionWriter { return p.PartitionWriter }
+
+// seekableLimitedPartition allows appending to the existing partition.
+type seekableLimitedPartition struct {
+	*limitedPartition
+	readSeeker
+}
+
+// Wrap checks the limits while the partition is written.
+func (l *Limiter) Wrap(w PartitionWriter) (PartitionWriter, error) {
+	var p *limitedPartition = &limitedPartition{
+		PartitionWriter: w,
+		limiter:         l,
+	}
+	rs, seekable := w.(io.ReadSeeker)
+	if !seekable {
+		return p, nil
+	}
+
+	// the existing content
+	size, e := existingSize(rs)
+	p.size = size
+	return seekableLimitedPartition{
+		limitedPartition: p,
+		readSeeker:       readSeeker{rs: rs, pos: &p.pos},
+	}, e
+}
+
+// LimitSink checks the limits of the partitions of the sink.
+type LimitSink struct {
+	Sink
+	Limiter *Limiter
+}
+
+func (s LimitSink) wrap(w PartitionWriter, e error) (PartitionWriter, error) {
+	if nil != e {
+		return nil, e
+	}
+	wrapped, e := s.Limiter.Wrap(w)
+	if nil != e {
+		return nil, errors.Join(e, w.Abort())
+	}
+	return wrapped, nil
+}
+
+// Create checks the free space; the partition is counted by the caller(see
+// Limiter.Created).
+func (s LimitSink) Create(path string) (PartitionWriter, error) {
+	e := s.Limiter.Create()
+	if nil != e {
+		return nil, e
+	}
+	return s.wrap(s.Sink.Create(path))
+}
+
+// Reopen fails before reopening the partition if a limit was exceeded.
+func (s LimitSink) Reopen(name string) (PartitionWriter, error) {
+	e := s.Limiter.Check()
+	if nil != e {
+		return nil, e
+	}
+	return s.wrap(s.Sink.Reopen(name))
+}
+
+func (s LimitSink) Close() error { return CloseSink(s.Sink) }
+
+// keyedSink counts the created partitions by the key(the path if no key).
+type keyedSink struct {
+	Sink
+	limiter *Limiter
+	key     string
+}
+
+func (s keyedSink) Create(path string) (PartitionWriter, error) {
+	w, e := s.Sink.Create(path)
+	if nil != e {
+		return nil, e
+	}
+	return s.limiter.Created(w, cmp.Or(s.key, path))
+}
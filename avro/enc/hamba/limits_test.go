@@ -0,0 +1,247 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+	bs "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/blobstore"
+)
+
+func limitedConfig(
+	dir string,
+	policy eh.ExistPolicy,
+	limits eh.Limits,
+) eh.FsConfig {
+	var fc eh.FsConfig = memConfig(nil)
+	fc.Dirname = eh.Dirname(dir)
+	fc.ExistPolicy = policy
+	fc.Limiter = limits.ToLimiter(dir)
+	return fc
+}
+
+// writePool writes the rows to the partitions of the names.
+func writePool(t *testing.T, fc eh.FsConfig, names []string) error {
+	t.Helper()
+	pool, e := fc.ToPool(len(names))
+	if nil != e {
+		t.Fatal(e)
+	}
+	for i, row := range testRows(4 * len(names)) {
+		var name string = filepath.Join(
+			string(fc.Dirname),
+			names[i%len(names)],
+		)
+		e = pool.WriteMap(row, name, eh.PartitionHeader{})
+		if nil != e {
+			return errors.Join(e, pool.Close())
+		}
+	}
+	return pool.Close()
+}
+
+// The exceeded limit keeps the existing partitions and leaves no partition
+// without records.
+func TestLimitRollback(t *testing.T) {
+	var names []string = []string{"a.avro", "b.avro", "c.avro"}
+	for _, policy := range []eh.ExistPolicy{eh.ExistOverwrite, eh.ExistAppend} {
+		t.Run(string(policy), func(t *testing.T) {
+			var dir string = t.TempDir()
+			e := writePool(t, limitedConfig(dir, policy, eh.Limits{}), names[:1])
+			if nil != e {
+				t.Fatal(e)
+			}
+			before, e := os.ReadFile(filepath.Join(dir, "a.avro"))
+			if nil != e {
+				t.Fatal(e)
+			}
+
+			var fc eh.FsConfig = limitedConfig(
+				dir,
+				policy,
+				eh.Limits{MaxTotalBytes: int64(len(before))},
+			)
+			e = writePool(t, fc, names)
+			if !errors.Is(e, eh.ErrLimitExceeded) {
+				t.Fatalf("unexpected error: %v", e)
+			}
+
+			after, e := os.ReadFile(filepath.Join(dir, "a.avro"))
+			if nil != e || !slices.Equal(before, after) {
+				t.Fatalf("partition modified: %v", e)
+			}
+			if !slices.Equal(names[:1], dirNames(t, dir)) {
+				t.Fatalf("unexpected files: %v", dirNames(t, dir))
+			}
+
+			// no partition is opened after the limit is exceeded
+			_, e = eh.LimitSink{
+				Sink:    eh.FsSink{ExistPolicy: policy},
+				Limiter: fc.Limiter,
+			}.Reopen(filepath.Join(dir, "a.avro"))
+			if !errors.Is(e, eh.ErrLimitExceeded) {
+				t.Fatalf("unexpected error: %v", e)
+			}
+		})
+	}
+}
+
+func TestLimitBlobs(t *testing.T) {
+	var dir string = t.TempDir()
+	var limiter *eh.Limiter = eh.Limits{MaxTotalBytes: 8}.ToLimiter(dir)
+	var b *eh.BlobExtractor = eh.BlobConfig{
+		BlobMode:  eh.BlobSidecar,
+		Fields:    []string{"data"},
+		FsyncType: eh.FsyncFast,
+		Limiter:   limiter,
+	}.ToExtractor()
+
+	var partition string = filepath.Join(dir, "k.avro")
+	_, e := b.Extract(partition, map[string]any{"data": []byte("blob")})
+	if nil != e {
+		t.Fatal(e)
+	}
+	// the same blob is not counted again
+	_, e = b.Extract(partition, map[string]any{"data": []byte("blob")})
+	if nil != e {
+		t.Fatal(e)
+	}
+	_, e = b.Extract(partition, map[string]any{"data": []byte("large blob")})
+	if !errors.Is(e, eh.ErrLimitExceeded) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if 1 != len(dirNames(t, dir)) {
+		t.Fatalf("unexpected files: %v", dirNames(t, dir))
+	}
+}
+
+func TestLimitCas(t *testing.T) {
+	var dir string = t.TempDir()
+	var limiter *eh.Limiter = eh.Limits{MaxTotalBytes: 8}.ToLimiter(dir)
+	var cas eh.CasConfig = eh.CasConfig{
+		Fields: []string{"data"},
+		Store: bs.Store{
+			Root:    filepath.Join(dir, bs.DirnameDefault),
+			FanOut:  bs.FanOutDefault,
+			Reserve: limiter.Reserve,
+		},
+	}
+	_, e := cas.Dedup(map[string]any{"data": []byte("large blob")})
+	if !errors.Is(e, eh.ErrLimitExceeded) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if 0 != len(dirNames(t, dir)) {
+		t.Fatalf("unexpected files: %v", dirNames(t, dir))
+	}
+}
+
+// The exceeded limit failing all the open partitions is reported once.
+func TestLimitCloseErrors(t *testing.T) {
+	var dir string = t.TempDir()
+
+	// the headers of the partitions fit; the blocks do not
+	var header bytes.Buffer
+	_ = encodeRows(t, &header, codecConfig(t, bp.CodecNull), nil)
+	var fc eh.FsConfig = limitedConfig(
+		dir,
+		eh.ExistOverwrite,
+		eh.Limits{MaxTotalBytes: 3*int64(header.Len()) + 8},
+	)
+	pool, e := fc.ToPool(3)
+	if nil != e {
+		t.Fatal(e)
+	}
+	// the records are buffered until the close
+	for i, row := range testRows(3) {
+		var name string = filepath.Join(dir, []string{"a", "b", "c"}[i]+".avro")
+		e = pool.WriteMap(row, name, eh.PartitionHeader{})
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+
+	e = pool.Close()
+	if !errors.Is(e, eh.ErrLimitExceeded) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if 1 != strings.Count(e.Error(), eh.ErrLimitExceeded.Error()) {
+		t.Fatalf("duplicated errors: %v", e)
+	}
+	if 0 != len(dirNames(t, dir)) {
+		t.Fatalf("unexpected files: %v", dirNames(t, dir))
+	}
+}
+
+// writeKeys writes a row to the partition of each key.
+func writeKeys(t *testing.T, fc eh.FsConfig, keys []string) error {
+	t.Helper()
+	pool, e := fc.ToPool(1)
+	if nil != e {
+		t.Fatal(e)
+	}
+	for i, key := range keys {
+		e = pool.WriteMap(
+			testRows(len(keys))[i],
+			filepath.Join(string(fc.Dirname), key+".avro"),
+			eh.PartitionHeader{Key: key},
+		)
+		if nil != e {
+			return errors.Join(e, pool.Close())
+		}
+	}
+	return pool.Close()
+}
+
+// The skipped partitions and the segments do not count toward the number of
+// the partitions.
+func TestLimitPartitions(t *testing.T) {
+	var dir string = t.TempDir()
+	_ = writeExisting(t, filepath.Join(dir, "a.avro"))
+	var limits eh.Limits = eh.Limits{MaxPartitions: 1}
+
+	var skip eh.FsConfig = limitedConfig(dir, eh.ExistSkip, limits)
+	e := writeKeys(t, skip, []string{"a", "b", "b"})
+	if nil != e {
+		t.Fatal(e)
+	}
+	e = writeKeys(t, skip, []string{"b", "c"})
+	if !errors.Is(e, eh.ErrLimitExceeded) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+
+	var rolled eh.FsConfig = limitedConfig(dir, eh.ExistOverwrite, limits)
+	rolled.Rolling = eh.Rolling{MaxRecords: 1}
+	e = writeKeys(t, rolled, []string{"d", "d", "d"})
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	// the versions written without the pool
+	var versioned eh.FsConfig = limitedConfig(dir, eh.ExistVersion, limits)
+	for _, row := range testRows(2) {
+		e = versioned.WriteMapWithHeader(
+			row,
+			filepath.Join(dir, "e.avro"),
+			eh.PartitionHeader{Key: "e"},
+		)
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+
+	var expected []string = []string{
+		"a.avro", "b.avro",
+		"d.0001.avro", "d.0002.avro", "d.0003.avro",
+		"e.1.avro", "e.avro",
+	}
+	if !slices.Equal(expected, dirNames(t, dir)) {
+		t.Fatalf("unexpected files: %v", dirNames(t, dir))
+	}
+}
@@ -3,6 +3,7 @@ package enc
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -34,6 +35,10 @@ func MapToWriterHamba(
 	return errors.Join(e, enc.Flush())
 }
 
+// CodecConv converts the codec to the codec of ocf.Encoder.
+//
+// The codecs unsupported by ocf.Encoder(bzip2, xz) are converted to null;
+// use OcfEncoder for them.
 func CodecConv(c bp.Codec) ho.CodecName {
 	switch c {
 	case bp.CodecNull:
@@ -49,14 +54,33 @@ func CodecConv(c bp.Codec) ho.CodecName {
 	}
 }
 
+// ConfigToOpts converts the config to the options of ocf.Encoder.
 func ConfigToOpts(cfg bp.EncodeConfig) []ho.EncoderFunc {
-	var blockLen int = cfg.BlockLength
-	var c bp.Codec = cfg.Codec
-	var converted ho.CodecName = CodecConv(c)
-	return []ho.EncoderFunc{
-		ho.WithBlockLength(blockLen),
-		ho.WithCodec(converted),
+	var opts []ho.EncoderFunc = []ho.EncoderFunc{
+		ho.WithBlockLength(cfg.BlockLength),
+		ho.WithCodec(CodecConv(cfg.Codec)),
 	}
+	var deflate bool = bp.CodecDeflate == cfg.Codec
+	if deflate && bp.CompressionLevelDefault != cfg.CompressionLevel {
+		opts = append(opts, ho.WithCompressionLevel(cfg.CompressionLevel))
+	}
+	return opts
+}
+
+func MapToWriterOcf(
+	m map[string]any,
+	w io.Writer,
+	s ha.Schema,
+	cfg OcfConfig,
+) error {
+	enc, e := OcfEncoderNew(s, w, cfg)
+	if nil != e {
+		return e
+	}
+
+	e = enc.Encode(m)
+
+	return errors.Join(e, enc.Close())
 }
 
 func MapToWriter(
@@ -69,12 +93,15 @@ func MapToWriter(
 	if nil != e {
 		return e
 	}
-	var opts []ho.EncoderFunc = ConfigToOpts(cfg)
-	return MapToWriterHamba(
+	ocfg, e := ConfigToOcfConfig(cfg)
+	if nil != e {
+		return e
+	}
+	return MapToWriterOcf(
 		m,
 		w,
 		parsed,
-		opts...,
+		ocfg,
 	)
 }
 
@@ -99,45 +126,226 @@ func MapToFs(
 	schema string,
 	cfg bp.EncodeConfig,
 ) error {
-	f, e := os.Create(filename)
+	return MapToFsWithPolicy(
+		m,
+		filename,
+		ExistOverwrite,
+		sync,
+		schema,
+		cfg,
+	)
+}
+
+func MapToFsWithPolicy(
+	m map[string]any,
+	filename string,
+	policy ExistPolicy,
+	sync func(*os.File) error,
+	schema string,
+	cfg bp.EncodeConfig,
+) error {
+	_, e := MapToFsStat(
+		m,
+		filename,
+		policy,
+		sync,
+		Config{Schema: schema, EncodeConfig: cfg},
+		PartitionHeader{},
+	)
+	return e
+}
+
+// MapToOcfFile writes the map and returns the stat of the written file.
+func MapToOcfFile(
+	m map[string]any,
+	f *os.File,
+	sync func(*os.File) error,
+	s ha.Schema,
+	cfg OcfConfig,
+) (PartitionStat, error) {
+	return MapToFormatFile(m, f, sync, s, cfg, OutputOcf)
+}
+
+// MapToFormatFile writes the map using the format.
+func MapToFormatFile(
+	m map[string]any,
+	f *os.File,
+	sync func(*os.File) error,
+	s ha.Schema,
+	cfg OcfConfig,
+	format OutputFormat,
+) (PartitionStat, error) {
+	return MapToPartition(
+		m,
+		FileToPartitionWriter(f, sync),
+		s,
+		cfg,
+		format,
+	)
+}
+
+// MapToPartition writes the map and commits the partition.
+//
+// The partition will be aborted on error.
+func MapToPartition(
+	m map[string]any,
+	w PartitionWriter,
+	s ha.Schema,
+	cfg OcfConfig,
+	format OutputFormat,
+) (PartitionStat, error) {
+	enc, e := format.EncoderNew(s, w, cfg)
 	if nil != e {
-		return e
+		return PartitionStat{}, errors.Join(e, w.Abort())
+	}
+
+	e = errors.Join(
+		enc.Encode(m),
+		enc.Close(),
+	)
+	if nil != e {
+		return PartitionStat{}, errors.Join(e, w.Abort())
 	}
-	defer f.Close()
-	return MapToFileLike(
+
+	stat, e := PartitionToStat(enc, w, "")
+	if nil != e {
+		return PartitionStat{}, errors.Join(e, w.Abort())
+	}
+	return stat, w.Commit()
+}
+
+func MapToFsStat(
+	m map[string]any,
+	filename string,
+	policy ExistPolicy,
+	sync func(*os.File) error,
+	cfg Config,
+	header PartitionHeader,
+) (PartitionStat, error) {
+	return MapToSinkStat(
 		m,
-		f,
-		func() error { return sync(f) },
-		schema,
+		FsSink{ExistPolicy: policy, Sync: sync},
+		filename,
 		cfg,
+		header,
 	)
 }
 
+// RecordConverter converts a record before it is encoded.
+type RecordConverter func(map[string]any) (map[string]any, error)
+
+func RecordAsIs(m map[string]any) (map[string]any, error) { return m, nil }
+
+// PartitionConverter returns the converter of the records of the created
+// partition(e.g, a versioned name).
+type PartitionConverter func(partition string) RecordConverter
+
+func RecordsAsIs(_ string) RecordConverter { return RecordAsIs }
+
+// MapToSinkStat writes the map to the partition of the sink.
+func MapToSinkStat(
+	m map[string]any,
+	sink Sink,
+	path string,
+	cfg Config,
+	header PartitionHeader,
+) (PartitionStat, error) {
+	return MapToSinkStatConv(m, sink, path, cfg, header, RecordsAsIs)
+}
+
+// MapToSinkStatConv converts the map if the partition is not skipped.
+func MapToSinkStatConv(
+	m map[string]any,
+	sink Sink,
+	path string,
+	cfg Config,
+	header PartitionHeader,
+	conv PartitionConverter,
+) (PartitionStat, error) {
+	parsed, e := ha.Parse(cfg.Schema)
+	if nil != e {
+		return PartitionStat{}, e
+	}
+
+	ocfg, e := ConfigToOcfConfig(cfg.EncodeConfig)
+	if nil != e {
+		return PartitionStat{}, e
+	}
+	ocfg = header.Apply(ocfg, parsed)
+
+	w, e := sink.Create(path)
+	if errors.Is(e, ErrSkipPartition) {
+		return PartitionStat{Filename: path, Key: header.Key, Skipped: true}, nil
+	}
+	if nil != e {
+		return PartitionStat{}, e
+	}
+
+	converted, e := conv(w.Name())(m)
+	if nil != e {
+		return PartitionStat{}, errors.Join(e, w.Abort())
+	}
+
+	stat, e := MapToPartition(converted, w, parsed, ocfg, cfg.OutputFormat)
+	stat.Key = header.Key
+	return stat, e
+}
+
 type Config struct {
 	Schema string
 	bp.EncodeConfig
+	OutputFormat
 }
 
+// FsyncType decides when the written files are synced.
+//
+// The guarantee on a crash(or a power loss) of each type:
+//
+//   - fsync: each committed file is durable(the default).
+//   - fdatasync: the data of each committed file is durable; metadata not
+//     required to read the data(e.g, mtime) may be lost.
+//   - batch: the files are synced by a group every N files or T
+//     milliseconds; the files of the last group may be lost or partial.
+//   - syncfs: the files are synced once at the end of the run; any file of
+//     an unfinished run may be lost or partial.
+//   - fast: never synced.
+//
+// The batch and the syncfs require a Syncer; ToFsync does not sync for them.
 type FsyncType string
 
 const (
-	FsyncSync FsyncType = "fsync"
-	FsyncFast FsyncType = "fast"
+	FsyncSync  FsyncType = "fsync"
+	FsyncData  FsyncType = "fdatasync"
+	FsyncBatch FsyncType = "batch"
+	FsyncEnd   FsyncType = "syncfs"
+	FsyncFast  FsyncType = "fast"
 )
 
+var ErrUnknownFsyncType error = errors.New("unknown fsync type")
+
 func StringToFsyncType(s string) (FsyncType, error) {
 	switch s {
+	case "", "fsync":
+		return FsyncSync, nil
 	case "fast":
 		return FsyncFast, nil
+	case "fdatasync":
+		return FsyncData, nil
+	case "batch":
+		return FsyncBatch, nil
+	case "syncfs":
+		return FsyncEnd, nil
 	default:
-		return FsyncSync, nil
+		return FsyncSync, fmt.Errorf("%w: %s", ErrUnknownFsyncType, s)
 	}
 }
 
 func (f FsyncType) ToFsync() func(*os.File) error {
 	switch f {
-	case FsyncFast:
+	case FsyncFast, FsyncBatch, FsyncEnd:
 		return func(_ *os.File) error { return nil }
+	case FsyncData:
+		return fdatasync
 	default:
 		return func(f *os.File) error { return f.Sync() }
 	}
@@ -147,23 +355,142 @@ type FsConfig struct {
 	Config
 	FsyncType
 	Dirname
+	ExistPolicy
+	StatObserver
+	PartitionMetadata
+
+	// The metadata of the input for the PartitionMetadata(nil: none).
+	Input *InputMetadata
+
+	// Writes byte-identical files for identical input if true.
+	Deterministic bool
+
+	// Stores the partitions(nil: local files using the ExistPolicy).
+	Sink
+
+	// Computes the digests of the partitions for the StatObserver.
+	DigestAlgorithm
+
+	// Keeps the running digests between the writes of the partitions(nil:
+	// the appended partitions are read again; ToPool creates one).
+	Digests *DigestStates
+
+	// Encrypts the partitions(nil: plain partitions).
+	Encryption *Encryption
+
+	// Splits the partitions into segments(requires the EncoderPool).
+	Rolling
+
+	// Syncs the files by groups(nil: the FsyncType syncs each file).
+	Syncer *Syncer
+
+	// Checks the limits of the run(nil: no limits).
+	Limiter *Limiter
+
+	// Extracts the blobs of the records written to the partitions(nil: no
+	// extraction); the blobs of the skipped partitions are not written.
+	Blobs *BlobExtractor
+
+	// Keeps the schemas of the headerless partitions(nil: not kept).
+	Schemas *PartitionSchemas
+}
+
+// ToConverter returns the converter of the records of the created
+// partition.
+func (f FsConfig) ToConverter(filename string) RecordConverter {
+	if nil == f.Blobs || BlobInline == f.Blobs.BlobMode {
+		return RecordAsIs
+	}
+	return f.Blobs.Converter(filename)
+}
+
+// ToFsync returns the sync of the Syncer or the FsyncType.
+func (f FsConfig) ToFsync() func(*os.File) error {
+	return f.Syncer.ToFsync(f.FsyncType)
+}
+
+// ToSink returns the sink or the local filesystem sink.
+func (f FsConfig) ToSink() Sink {
+	var sink Sink = f.Sink
+	if nil == sink {
+		sink = FsSink{
+			ExistPolicy: f.ExistPolicy,
+			Sync:        f.ToFsync(),
+		}
+	}
+	if nil != f.Limiter {
+		// the limits of the stored(encrypted) partitions
+		sink = LimitSink{Sink: sink, Limiter: f.Limiter}
+	}
+	if DigestNone != f.DigestAlgorithm {
+		// the digests of the stored(encrypted) partitions
+		sink = DigestSink{
+			Sink:            sink,
+			DigestAlgorithm: f.DigestAlgorithm,
+			States:          f.Digests,
+		}
+	}
+	if nil != f.Encryption {
+		sink = EncryptSink{Sink: sink, Encryption: *f.Encryption}
+	}
+	if nil != f.Schemas && nil == f.Sink {
+		sink = SchemaSink{
+			Sink:             sink,
+			PartitionSchemas: *f.Schemas,
+			ExistPolicy:      f.ExistPolicy,
+		}
+	}
+	if nil != f.Blobs && f.Blobs.JsonSidecar && BlobInline != f.Blobs.BlobMode {
+		sink = JsonSidecarSink{
+			Sink:        sink,
+			Blobs:       f.Blobs,
+			ExistPolicy: f.ExistPolicy,
+		}
+	}
+	return sink
 }
 
 func (f FsConfig) WriteMap(
 	m map[string]any,
 	filename string,
 ) error {
-	return MapToFs(
+	return f.WriteMapWithHeader(m, filename, PartitionHeader{})
+}
+
+func (f FsConfig) WriteMapWithHeader(
+	m map[string]any,
+	filename string,
+	header PartitionHeader,
+) error {
+	stat, e := MapToSinkStatConv(
 		m,
+		keyedSink{Sink: f.ToSink(), limiter: f.Limiter, key: header.Key},
 		filename,
-		f.FsyncType.ToFsync(),
-		f.Config.Schema,
-		f.Config.EncodeConfig,
+		f.Config,
+		header,
+		f.ToConverter,
 	)
+	if nil != e {
+		return e
+	}
+	return f.StatObserver.Observe(stat)
 }
 
 type KeyToFilename func(pk.PrimaryKey, pk.PrimaryKeyWriter) IO[string]
 
+// KeyToHeader creates the header of the partition of the key.
+func (f FsConfig) KeyToHeader(
+	key pk.PrimaryKey,
+	wtr pk.PrimaryKeyWriter,
+) IO[PartitionHeader] {
+	return f.PartitionMetadata.KeyToHeader(
+		key,
+		wtr,
+		f.Input,
+		f.Deterministic,
+	)
+}
+
 func (f FsConfig) ToSaver(
 	pk2filename KeyToFilename,
 ) pk.RecordSaver {
@@ -178,19 +505,37 @@ func (f FsConfig) ToSaver(
 				return Empty, e
 			}
 
-			return Empty, f.WriteMap(
+			header, e := f.KeyToHeader(pk, pw)(ctx)
+			if nil != e {
+				return Empty, e
+			}
+
+			return Empty, f.WriteMapWithHeader(
 				m,
 				filename,
+				header,
 			)
 		}
 	}
 }
 
+// ToKeyToFilename creates the filenames using the extension of the format.
+func (f FsConfig) ToKeyToFilename() KeyToFilename {
+	return f.Dirname.ToBasenameToPathExt(
+		f.Config.OutputFormat.Ext(),
+	).ToKeyToFilename()
+}
+
 func (f FsConfig) SaverFromDirnameDefault() pk.RecordSaver {
-	var key2filename = f.Dirname.ToKeyToFilenameDefault()
+	var key2filename = f.ToKeyToFilename()
 	return f.ToSaver(key2filename)
 }
 
+// KeyAsFilename uses the encoded key as the name(e.g, for database sinks).
+func KeyAsFilename(key pk.PrimaryKey, wtr pk.PrimaryKeyWriter) IO[string] {
+	return key(wtr)
+}
+
 type BasenameToPath func(string) IO[string]
 
 func (d BasenameToPath) ToKeyToFilename() KeyToFilename {
@@ -233,13 +578,17 @@ func (d Dirname) ToBasenameToPath(
 	}
 }
 
-func (d Dirname) ToBasenameToPathDefault() BasenameToPath {
+func (d Dirname) ToBasenameToPathExt(ext Ext) BasenameToPath {
 	return d.ToBasenameToPath(
 		JoinPathDefault,
-		ExtDefault.ToBasenameWithExt(),
+		ext.ToBasenameWithExt(),
 	)
 }
 
+func (d Dirname) ToBasenameToPathDefault() BasenameToPath {
+	return d.ToBasenameToPathExt(ExtDefault)
+}
+
 func (d Dirname) ToKeyToFilenameDefault() KeyToFilename {
 	return d.ToBasenameToPathDefault().ToKeyToFilename()
 }
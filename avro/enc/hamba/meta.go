@@ -0,0 +1,172 @@
+package enc
+
+import (
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	ha "github.com/hamba/avro/v2"
+
+	. "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/util"
+
+	pk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/pkey"
+)
+
+// Metadata keys reserved by the Avro spec start with this prefix.
+const MetaReservedPrefix string = "avro."
+
+// Provenance metadata keys.
+const (
+	MetaKeyPkeyName  string = "pkey.name"
+	MetaKeyPkeyValue string = "pkey.key"
+	MetaKeySource    string = "pkey.source"
+	MetaKeyRunId     string = "pkey.run_id"
+	MetaKeyWrittenAt string = "pkey.written_at"
+)
+
+// InputMetadata keeps the header metadata of the input.
+//
+// The decoder sets the metadata before the first record. InputMetadata is
+// safe for concurrent use.
+type InputMetadata struct {
+	mu   sync.Mutex
+	meta map[string][]byte
+}
+
+// Set keeps the metadata(e.g, as the MetadataHandler of the decoder).
+func (i *InputMetadata) Set(meta map[string][]byte) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.meta = meta
+}
+
+// Get returns the metadata(nil: not set or no input metadata).
+func (i *InputMetadata) Get() map[string][]byte {
+	if nil == i {
+		return nil
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.meta
+}
+
+// PartitionMetadata creates the user metadata of a partition file using the
+// metadata of the input and the encoded key.
+type PartitionMetadata func(
+	input map[string][]byte,
+	key string,
+) map[string][]byte
+
+func (p PartitionMetadata) ToMetadata(
+	input map[string][]byte,
+	key string,
+) map[string][]byte {
+	if nil == p {
+		return nil
+	}
+	return p(input, key)
+}
+
+// PartitionHeader is the partition specific header config.
+type PartitionHeader struct {
+	Key      string
+	Metadata map[string][]byte
+
+	// Derives the sync marker from the schema and the key if true.
+	Deterministic bool
+}
+
+func (p PartitionHeader) Apply(c OcfConfig, s ha.Schema) OcfConfig {
+	c.Metadata = p.Metadata
+	if p.Deterministic {
+		c.Sync = DeterministicSync(s, p.Key)
+	}
+	return c
+}
+
+// KeyToHeader encodes the key to create the header.
+func (p PartitionMetadata) KeyToHeader(
+	key pk.PrimaryKey,
+	wtr pk.PrimaryKeyWriter,
+	input *InputMetadata,
+	deterministic bool,
+) IO[PartitionHeader] {
+	return Bind(
+		key(wtr),
+		Lift(func(encoded string) (PartitionHeader, error) {
+			return PartitionHeader{
+				Key:           encoded,
+				Metadata:      p.ToMetadata(input.Get(), encoded),
+				Deterministic: deterministic,
+			}, nil
+		}),
+	)
+}
+
+// CopyMetadata copies the selected keys of the input metadata.
+//
+// A key "*" selects all keys. Reserved keys(avro.*) are never copied.
+func CopyMetadata(
+	input map[string][]byte,
+	keys []string,
+) map[string][]byte {
+	var ret map[string][]byte = map[string][]byte{}
+	for _, key := range keys {
+		switch key {
+		case "*":
+			maps.Copy(ret, input)
+		default:
+			val, found := input[key]
+			if found {
+				ret[key] = val
+			}
+		}
+	}
+	maps.DeleteFunc(ret, func(key string, _ []byte) bool {
+		return strings.HasPrefix(key, MetaReservedPrefix)
+	})
+	return ret
+}
+
+type Provenance struct {
+	// The name of the primary key field.
+	KeyName string
+
+	// The name of the input(e.g, stdin, filename).
+	Source string
+
+	RunId string
+
+	// Returns the write time(nil: no write time, e.g, reproducible output).
+	Clock func() time.Time
+}
+
+func (p Provenance) addTo(meta map[string][]byte, key string) {
+	meta[MetaKeyPkeyName] = []byte(p.KeyName)
+	meta[MetaKeyPkeyValue] = []byte(key)
+	meta[MetaKeySource] = []byte(p.Source)
+	meta[MetaKeyRunId] = []byte(p.RunId)
+	if nil != p.Clock {
+		meta[MetaKeyWrittenAt] = []byte(
+			p.Clock().UTC().Format(time.RFC3339Nano),
+		)
+	}
+}
+
+// ToPartitionMetadata merges the copied keys of the input metadata(see
+// CopyMetadata) and the provenance.
+func (p Provenance) ToPartitionMetadata(keys []string) PartitionMetadata {
+	return func(input map[string][]byte, key string) map[string][]byte {
+		var meta map[string][]byte = CopyMetadata(input, keys)
+		p.addTo(meta, key)
+		return meta
+	}
+}
+
+// CopiedOnly copies the keys of the input metadata without the provenance.
+func CopiedOnly(keys []string) PartitionMetadata {
+	return func(input map[string][]byte, _ string) map[string][]byte {
+		return CopyMetadata(input, keys)
+	}
+}
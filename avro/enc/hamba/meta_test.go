@@ -0,0 +1,206 @@
+package enc_test
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+
+	dh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/dec/hamba"
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+	pk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/pkey"
+)
+
+// inputOcf encodes the rows(deflate) with the user metadata of the input.
+func inputOcf(
+	t *testing.T,
+	rows []map[string]any,
+	meta map[string][]byte,
+) []byte {
+	t.Helper()
+	var cfg eh.OcfConfig = codecConfig(t, bp.CodecDeflate)
+	cfg.Metadata = meta
+	var buf bytes.Buffer
+	_ = encodeRows(t, &buf, cfg, rows)
+	return buf.Bytes()
+}
+
+// saveInput decodes the input and saves the rows by the id.
+func saveInput(t *testing.T, fc eh.FsConfig, input []byte) {
+	t.Helper()
+	fc.Input = &eh.InputMetadata{}
+	_, e := fc.SaverFromDirnameDefault().ToRecordsSaver()(
+		dh.ReaderToMapsMeta(
+			bytes.NewReader(input),
+			bp.DecodeConfigDefault,
+			fc.Input.Set,
+		),
+		pk.MapToKeyNew("id"),
+		&pk.StringKeyWriterDefault,
+	)(context.Background())
+	if nil != e {
+		t.Fatal(e)
+	}
+}
+
+func partitionFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	found, e := filepath.Glob(filepath.Join(dir, "*.avro"))
+	if nil != e {
+		t.Fatal(e)
+	}
+	return found
+}
+
+func TestMetadataProvenance(t *testing.T) {
+	var dir string = t.TempDir()
+	var fc eh.FsConfig = memConfig(nil)
+	fc.Dirname = eh.Dirname(dir)
+	fc.PartitionMetadata = eh.Provenance{
+		KeyName: "id",
+		Source:  "in.avro",
+		RunId:   "run1",
+		Clock: func() time.Time {
+			return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		},
+	}.ToPartitionMetadata([]string{"src.copied", "avro.codec"})
+
+	saveInput(t, fc, inputOcf(t, testRows(3), map[string][]byte{
+		"src.copied": []byte("v"),
+		"src.other":  []byte("x"),
+	}))
+
+	var files []string = partitionFiles(t, dir)
+	if 3 != len(files) {
+		t.Fatalf("unexpected partitions: %v", files)
+	}
+	for _, filename := range files {
+		f, e := os.Open(filename)
+		if nil != e {
+			t.Fatal(e)
+		}
+		h, e := eh.ReadOcfHeader(f)
+		_ = f.Close()
+		if nil != e {
+			t.Fatal(e)
+		}
+
+		var key string = strings.TrimSuffix(filepath.Base(filename), ".avro")
+		var expected map[string]string = map[string]string{
+			"src.copied":        "v",
+			eh.MetaKeyPkeyName:  "id",
+			eh.MetaKeyPkeyValue: key,
+			eh.MetaKeySource:    "in.avro",
+			eh.MetaKeyRunId:     "run1",
+			eh.MetaKeyWrittenAt: "2024-01-02T03:04:05Z",
+
+			// the reserved keys of the input are never copied
+			eh.OcfCodecKey: string(bp.CodecNull),
+		}
+		for name, val := range expected {
+			if val != string(h.Meta[name]) {
+				t.Errorf("%s: %s=%q", key, name, h.Meta[name])
+			}
+		}
+		if _, found := h.Meta["src.other"]; found {
+			t.Errorf("%s: not selected key copied", key)
+		}
+	}
+}
+
+func TestMetadataCopiedOnly(t *testing.T) {
+	var dir string = t.TempDir()
+	var fc eh.FsConfig = memConfig(nil)
+	fc.Dirname = eh.Dirname(dir)
+	fc.PartitionMetadata = eh.CopiedOnly([]string{"*"})
+
+	saveInput(t, fc, inputOcf(t, testRows(1), map[string][]byte{
+		"src.a": []byte("a"),
+		"src.b": []byte("b"),
+	}))
+
+	f, e := os.Open(partitionFiles(t, dir)[0])
+	if nil != e {
+		t.Fatal(e)
+	}
+	defer f.Close()
+	h, e := eh.ReadOcfHeader(f)
+	if nil != e {
+		t.Fatal(e)
+	}
+	if "a" != string(h.Meta["src.a"]) || "b" != string(h.Meta["src.b"]) {
+		t.Fatalf("unexpected metadata: %v", h.Meta)
+	}
+	if _, found := h.Meta[eh.MetaKeyRunId]; found {
+		t.Fatal("provenance written")
+	}
+}
+
+// deterministicRun writes the partitions of the input using the pool.
+func deterministicRun(t *testing.T, deterministic bool) map[string][]byte {
+	t.Helper()
+	var dir string = t.TempDir()
+	var fc eh.FsConfig = memConfig(nil)
+	fc.Dirname = eh.Dirname(dir)
+	fc.Deterministic = deterministic
+	fc.Config.EncodeConfig.Codec = bp.CodecDeflate
+	fc.PartitionMetadata = eh.Provenance{
+		KeyName: "id",
+		Source:  "stdin",
+	}.ToPartitionMetadata([]string{"*"})
+	fc.Input = &eh.InputMetadata{}
+
+	pool, e := fc.ToPool(2)
+	if nil != e {
+		t.Fatal(e)
+	}
+	var rows []map[string]any = testRows(30)
+	for _, row := range rows {
+		row["id"] = row["id"].(int64) % 3
+	}
+	var input []byte = inputOcf(t, rows, map[string][]byte{"src": []byte("v")})
+	_, e = pool.ToRecordsSaver(fc.ToKeyToFilename())(
+		dh.ReaderToMapsMeta(
+			bytes.NewReader(input),
+			bp.DecodeConfigDefault,
+			fc.Input.Set,
+		),
+		pk.MapToKeyNew("id"),
+		&pk.StringKeyWriterDefault,
+	)(context.Background())
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	var ret map[string][]byte = map[string][]byte{}
+	for _, filename := range partitionFiles(t, dir) {
+		data, e := os.ReadFile(filename)
+		if nil != e {
+			t.Fatal(e)
+		}
+		ret[filepath.Base(filename)] = data
+	}
+	return ret
+}
+
+// Two deterministic runs of the same input write byte-identical partitions.
+func TestDeterministicRuns(t *testing.T) {
+	var first map[string][]byte = deterministicRun(t, true)
+	if 3 != len(first) {
+		t.Fatalf("unexpected partitions: %v", len(first))
+	}
+	if !maps.EqualFunc(first, deterministicRun(t, true), bytes.Equal) {
+		t.Fatal("deterministic runs differ")
+	}
+
+	// the sync markers are random otherwise
+	if maps.EqualFunc(first, deterministicRun(t, false), bytes.Equal) {
+		t.Fatal("random sync markers are identical")
+	}
+}
@@ -0,0 +1,387 @@
+package enc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	ha "github.com/hamba/avro/v2"
+	ho "github.com/hamba/avro/v2/ocf"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+)
+
+const (
+	OcfSchemaKey string = "avro.schema"
+	OcfCodecKey  string = "avro.codec"
+)
+
+var OcfMagic [4]byte = [4]byte{'O', 'b', 'j', 1}
+
+var ErrInvalidOcf error = errors.New("invalid object container file")
+
+type OcfConfig struct {
+	BlockLength int
+	BlockCodec
+
+	// Chooses the codec of a new file(nil: always use the BlockCodec).
+	Select CodecSelector
+
+	// The blocks are kept until this many bytes are encoded(or the file is
+	// closed), then the Select gets all of them(0: the first block only).
+	SelectBytes int
+
+	// User metadata of a new file(reserved keys are ignored).
+	Metadata map[string][]byte
+
+	// The sync marker of a new file(zero: random).
+	Sync [16]byte
+}
+
+func ConfigToOcfConfig(cfg bp.EncodeConfig) (OcfConfig, error) {
+	codec, e := CodecNew(cfg.Codec, cfg.CompressionLevel)
+	if nil != e {
+		return OcfConfig{}, e
+	}
+	sel, e := ConfigToSelector(codec, cfg.Adaptive)
+	var selectBytes int
+	if bp.CodecSelectionSize == cfg.Adaptive.CodecSelection {
+		selectBytes = cfg.Adaptive.Threshold
+	}
+	return OcfConfig{
+		BlockLength: max(1, cfg.BlockLength),
+		BlockCodec:  codec,
+		Select:      sel,
+		SelectBytes: selectBytes,
+	}, e
+}
+
+type countWriter struct {
+	io.Writer
+	count int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, e := c.Writer.Write(p)
+	c.count += int64(n)
+	return n, e
+}
+
+// OcfEncoder writes an object container file.
+//
+// Unlike ocf.Encoder, the codec is pluggable(e.g, bzip2, xz).
+type OcfEncoder struct {
+	w      countWriter
+	codec  BlockCodec
+	sync   [16]byte
+	maxLen int
+
+	meta map[string][]byte
+
+	// the header will be written with the first block if not nil
+	schema ha.Schema
+	sel    CodecSelector
+
+	// the uncompressed blocks kept until the codec is selected
+	pending     []pendingBlock
+	pendingLen  int
+	selectBytes int
+
+	count   int
+	records int
+	buf     bytes.Buffer
+	enc     *ha.Encoder
+}
+
+// ReadOcfHeader reads the header of an object container file.
+func ReadOcfHeader(r io.Reader) (ho.Header, error) {
+	var h ho.Header
+	var rdr *ha.Reader = ha.NewReader(r, 1024)
+	rdr.ReadVal(ho.HeaderSchema, &h)
+	if nil != rdr.Error {
+		return h, rdr.Error
+	}
+	if OcfMagic != h.Magic {
+		return h, ErrInvalidOcf
+	}
+	return h, nil
+}
+
+// CheckHeader returns ErrSchemaMismatch if the header schema is not s.
+func CheckHeader(h ho.Header, s ha.Schema) error {
+	existing, e := ha.Parse(string(h.Meta[OcfSchemaKey]))
+	if nil != e {
+		return e
+	}
+	if existing.Fingerprint() != s.Fingerprint() {
+		return ErrSchemaMismatch
+	}
+	return nil
+}
+
+// WriteOcfHeader writes the header with the metadata sorted by key.
+func WriteOcfHeader(
+	w io.Writer,
+	meta map[string][]byte,
+	sync [16]byte,
+) error {
+	var wtr *ha.Writer = ha.NewWriter(w, 512)
+	_, _ = wtr.Write(OcfMagic[:])
+
+	var keys []string = slices.Sorted(maps.Keys(meta))
+	if 0 < len(keys) {
+		wtr.WriteLong(int64(len(keys)))
+		for _, key := range keys {
+			wtr.WriteString(key)
+			wtr.WriteBytes(meta[key])
+		}
+	}
+	wtr.WriteLong(0)
+
+	_, _ = wtr.Write(sync[:])
+	return wtr.Flush()
+}
+
+// DeterministicSync derives the sync marker from the schema and the key.
+func DeterministicSync(s ha.Schema, key string) (sync [16]byte) {
+	var fp [32]byte = s.Fingerprint()
+	var h hash.Hash = sha256.New()
+	_, _ = h.Write(fp[:])       // never returns an error
+	_, _ = h.Write([]byte(key)) // never returns an error
+	copy(sync[:], h.Sum(nil))
+	return sync
+}
+
+func (o *OcfEncoder) writeHeader(s ha.Schema) error {
+	var meta map[string][]byte = map[string][]byte{}
+	for key, val := range o.meta {
+		if !strings.HasPrefix(key, MetaReservedPrefix) {
+			meta[key] = val
+		}
+	}
+	meta[OcfSchemaKey] = []byte(s.String())
+	meta[OcfCodecKey] = []byte(o.codec.Name())
+
+	if [16]byte{} == o.sync {
+		_, _ = rand.Read(o.sync[:]) // never returns an error
+	}
+
+	return WriteOcfHeader(&o.w, meta, o.sync)
+}
+
+// pendingBlock is an uncompressed block written after the header.
+type pendingBlock struct {
+	count int
+	data  []byte
+}
+
+// writeHeaderLazy selects the codec using the pending blocks and the
+// buffered records, then writes the header and the pending blocks.
+func (o *OcfEncoder) writeHeaderLazy() error {
+	if nil == o.schema {
+		return nil
+	}
+
+	if nil != o.sel && 0 < o.pendingLen+o.buf.Len() {
+		var encoded []byte = make([]byte, 0, o.pendingLen+o.buf.Len())
+		for _, block := range o.pending {
+			encoded = append(encoded, block.data...)
+		}
+		codec, e := o.sel(append(encoded, o.buf.Bytes()...))
+		if nil != e {
+			return e
+		}
+		o.codec = codec
+	}
+
+	var s ha.Schema = o.schema
+	o.schema = nil
+	e := o.writeHeader(s)
+	if nil != e {
+		return e
+	}
+
+	var pending []pendingBlock = o.pending
+	o.pending = nil
+	o.pendingLen = 0
+	for _, block := range pending {
+		e = o.writeRaw(block.count, block.data)
+		if nil != e {
+			return e
+		}
+	}
+	return nil
+}
+
+// appendTo prepares to append blocks to the non-empty file.
+//
+// The schema and the codec of the existing file must match.
+func (o *OcfEncoder) appendTo(f io.ReadSeeker, s ha.Schema) error {
+	_, e := f.Seek(0, io.SeekStart)
+	if nil != e {
+		return e
+	}
+
+	h, e := ReadOcfHeader(f)
+	if nil != e {
+		return e
+	}
+
+	e = CheckHeader(h, s)
+	if nil != e {
+		return e
+	}
+
+	var codec bp.Codec = bp.Codec(h.Meta[OcfCodecKey])
+	if "" == codec {
+		// the spec: a missing codec means null
+		codec = bp.CodecNull
+	}
+	if codec != o.codec.Name() {
+		o.codec, e = CodecNew(codec, bp.CompressionLevelDefault)
+		if nil != e {
+			return e
+		}
+	}
+
+	o.sync = h.Sync
+
+	// the file must end with a sync marker
+	_, e = f.Seek(0, io.SeekEnd)
+	return e
+}
+
+// OcfEncoderNew creates an encoder.
+//
+// If w is a non-empty seekable file(e.g, *os.File), the records will be
+// appended to it.
+func OcfEncoderNew(
+	s ha.Schema,
+	w io.Writer,
+	cfg OcfConfig,
+) (*OcfEncoder, error) {
+	if nil == cfg.BlockCodec {
+		cfg.BlockCodec = NullCodec{}
+	}
+
+	o := &OcfEncoder{
+		w:      countWriter{Writer: w},
+		codec:  cfg.BlockCodec,
+		maxLen: max(1, cfg.BlockLength),
+		meta:   cfg.Metadata,
+		sync:   cfg.Sync,
+	}
+	o.enc = ha.NewEncoderForSchema(s, &o.buf)
+
+	rs, seekable := w.(io.ReadSeeker)
+	if seekable {
+		size, e := rs.Seek(0, io.SeekEnd)
+		if nil == e && 0 < size {
+			e = o.appendTo(rs, s)
+			if nil != e {
+				return nil, fmt.Errorf("unable to append: %w", e)
+			}
+			return o, nil
+		}
+	}
+
+	if nil != cfg.Select {
+		o.schema = s
+		o.sel = cfg.Select
+		o.selectBytes = cfg.SelectBytes
+		return o, nil
+	}
+
+	return o, o.writeHeader(s)
+}
+
+// Codec returns the codec actually used.
+func (o *OcfEncoder) Codec() bp.Codec { return o.codec.Name() }
+
+// Records returns the number of the encoded records.
+func (o *OcfEncoder) Records() int { return o.records }
+
+// Bytes returns the number of bytes written so far.
+func (o *OcfEncoder) Bytes() int64 { return o.w.count }
+
+// Buffered returns the number of the encoded bytes of the blocks not written
+// yet(uncompressed).
+func (o *OcfEncoder) Buffered() int64 {
+	return int64(o.pendingLen + o.buf.Len())
+}
+
+// writeRaw compresses and writes the block of the records.
+func (o *OcfEncoder) writeRaw(count int, raw []byte) error {
+	compressed, e := o.codec.Encode(raw)
+	if nil != e {
+		return e
+	}
+
+	var wtr *ha.Writer = ha.NewWriter(&o.w, 512)
+	wtr.WriteLong(int64(count))
+	wtr.WriteLong(int64(len(compressed)))
+	_, _ = wtr.Write(compressed)
+	_, _ = wtr.Write(o.sync[:])
+	return wtr.Flush()
+}
+
+func (o *OcfEncoder) writeBlock() error {
+	var selecting bool = nil != o.schema && nil != o.sel
+	if selecting && o.pendingLen+o.buf.Len() < o.selectBytes {
+		o.pending = append(o.pending, pendingBlock{
+			count: o.count,
+			data:  bytes.Clone(o.buf.Bytes()),
+		})
+		o.pendingLen += o.buf.Len()
+		o.count = 0
+		o.buf.Reset()
+		return nil
+	}
+
+	e := o.writeHeaderLazy()
+	if nil != e {
+		return e
+	}
+
+	e = o.writeRaw(o.count, o.buf.Bytes())
+	o.count = 0
+	o.buf.Reset()
+	return e
+}
+
+func (o *OcfEncoder) Encode(v any) error {
+	e := o.enc.Encode(v)
+	if nil != e {
+		return e
+	}
+
+	o.count++
+	o.records++
+	if o.count < o.maxLen {
+		return nil
+	}
+	return o.writeBlock()
+}
+
+// Flush writes the buffered records as a block.
+func (o *OcfEncoder) Flush() error {
+	if 0 == o.count {
+		return nil
+	}
+	return o.writeBlock()
+}
+
+// Close flushes the records and writes the header if not written yet.
+func (o *OcfEncoder) Close() error {
+	return errors.Join(
+		o.Flush(),
+		o.writeHeaderLazy(),
+	)
+}
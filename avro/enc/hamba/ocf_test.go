@@ -0,0 +1,450 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	ha "github.com/hamba/avro/v2"
+	ho "github.com/hamba/avro/v2/ocf"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+
+	dh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/dec/hamba"
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+const testSchema string = `{
+	"type": "record",
+	"name": "Row",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "name", "type": "string"},
+		{"name": "data", "type": "bytes"}
+	]
+}`
+
+var allCodecs []bp.Codec = []bp.Codec{
+	bp.CodecNull,
+	bp.CodecDeflate,
+	bp.CodecSnappy,
+	bp.CodecZstd,
+	bp.CodecBzip2,
+	bp.CodecXz,
+}
+
+func mustParse(t *testing.T, schema string) ha.Schema {
+	t.Helper()
+	s, e := ha.Parse(schema)
+	if nil != e {
+		t.Fatal(e)
+	}
+	return s
+}
+
+func testRows(n int) []map[string]any {
+	var rows []map[string]any
+	for i := range n {
+		rows = append(rows, map[string]any{
+			"id":   int64(i),
+			"name": fmt.Sprintf("row-%v", i),
+			"data": bytes.Repeat([]byte{byte(i)}, i%17),
+		})
+	}
+	return rows
+}
+
+func encodeRows(
+	t *testing.T,
+	w io.Writer,
+	cfg eh.OcfConfig,
+	rows []map[string]any,
+) *eh.OcfEncoder {
+	t.Helper()
+	enc, e := eh.OcfEncoderNew(mustParse(t, testSchema), w, cfg)
+	if nil != e {
+		t.Fatal(e)
+	}
+	for _, row := range rows {
+		e := enc.Encode(row)
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+	e = enc.Close()
+	if nil != e {
+		t.Fatal(e)
+	}
+	return enc
+}
+
+func decodeRows(t *testing.T, r io.Reader) ([]map[string]any, error) {
+	t.Helper()
+	var rows []map[string]any
+	for row, e := range dh.ReaderToMaps(r, bp.DecodeConfigDefault) {
+		if nil != e {
+			return rows, e
+		}
+		var cloned map[string]any = map[string]any{}
+		for key, val := range row {
+			cloned[key] = val
+		}
+		rows = append(rows, cloned)
+	}
+	return rows, nil
+}
+
+func codecConfig(t *testing.T, c bp.Codec) eh.OcfConfig {
+	t.Helper()
+	ocfg, e := eh.ConfigToOcfConfig(bp.EncodeConfig{
+		BlockLength: 7,
+		Codec:       c,
+	})
+	if nil != e {
+		t.Fatal(e)
+	}
+	return ocfg
+}
+
+func TestOcfEncoderRoundTrip(t *testing.T) {
+	var rows []map[string]any = testRows(100)
+	for _, codec := range allCodecs {
+		t.Run(string(codec), func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := encodeRows(t, &buf, codecConfig(t, codec), rows)
+			if codec != enc.Codec() {
+				t.Fatalf("codec: %v", enc.Codec())
+			}
+			if len(rows) != enc.Records() {
+				t.Fatalf("records: %v", enc.Records())
+			}
+			if int64(buf.Len()) != enc.Bytes() {
+				t.Fatalf("bytes: %v != %v", enc.Bytes(), buf.Len())
+			}
+
+			decoded, e := decodeRows(t, bytes.NewReader(buf.Bytes()))
+			if nil != e {
+				t.Fatal(e)
+			}
+			if !reflect.DeepEqual(rows, decoded) {
+				t.Fatal("decoded rows differ")
+			}
+		})
+	}
+}
+
+// The files of the codecs supported by hamba are readable by ocf.Decoder.
+func TestOcfEncoderHambaCompatible(t *testing.T) {
+	var rows []map[string]any = testRows(30)
+	for _, codec := range allCodecs[:4] {
+		t.Run(string(codec), func(t *testing.T) {
+			var buf bytes.Buffer
+			_ = encodeRows(t, &buf, codecConfig(t, codec), rows)
+
+			dec, e := ho.NewDecoder(&buf)
+			if nil != e {
+				t.Fatal(e)
+			}
+			var count int
+			for dec.HasNext() {
+				var row map[string]any
+				e := dec.Decode(&row)
+				if nil != e {
+					t.Fatal(e)
+				}
+				if !reflect.DeepEqual(rows[count], row) {
+					t.Fatalf("row %v differs", count)
+				}
+				count++
+			}
+			if nil != dec.Error() || len(rows) != count {
+				t.Fatalf("records: %v, error: %v", count, dec.Error())
+			}
+		})
+	}
+}
+
+func TestOcfEncoderEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	_ = encodeRows(t, &buf, codecConfig(t, bp.CodecZstd), nil)
+
+	decoded, e := decodeRows(t, &buf)
+	if nil != e || 0 != len(decoded) {
+		t.Fatalf("records: %v, error: %v", len(decoded), e)
+	}
+}
+
+func TestOcfEncoderDeterministic(t *testing.T) {
+	var cfg eh.OcfConfig = codecConfig(t, bp.CodecDeflate)
+	cfg.Sync = eh.DeterministicSync(mustParse(t, testSchema), "key")
+	cfg.Metadata = map[string][]byte{"b": []byte("2"), "a": []byte("1")}
+
+	var rows []map[string]any = testRows(20)
+	var first, second bytes.Buffer
+	_ = encodeRows(t, &first, cfg, rows)
+	_ = encodeRows(t, &second, cfg, rows)
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Fatal("not deterministic")
+	}
+}
+
+func TestOcfEncoderMetadata(t *testing.T) {
+	var cfg eh.OcfConfig = codecConfig(t, bp.CodecNull)
+	cfg.Metadata = map[string][]byte{
+		"user.key":  []byte("val"),
+		"avro.evil": []byte("ignored"),
+	}
+	var buf bytes.Buffer
+	_ = encodeRows(t, &buf, cfg, testRows(1))
+
+	h, e := eh.ReadOcfHeader(&buf)
+	if nil != e {
+		t.Fatal(e)
+	}
+	if "val" != string(h.Meta["user.key"]) {
+		t.Fatalf("user metadata: %v", h.Meta)
+	}
+	if _, found := h.Meta["avro.evil"]; found {
+		t.Fatal("reserved metadata written")
+	}
+}
+
+func TestOcfEncoderAppend(t *testing.T) {
+	var filename string = filepath.Join(t.TempDir(), "p.avro")
+	var rows []map[string]any = testRows(40)
+
+	f, e := os.Create(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	_ = encodeRows(t, f, codecConfig(t, bp.CodecXz), rows[:25])
+	_ = f.Close()
+
+	// the codec of the existing file is used
+	f, e = os.OpenFile(filename, os.O_RDWR, 0)
+	if nil != e {
+		t.Fatal(e)
+	}
+	enc := encodeRows(t, f, codecConfig(t, bp.CodecNull), rows[25:])
+	_ = f.Close()
+	if bp.CodecXz != enc.Codec() {
+		t.Fatalf("codec: %v", enc.Codec())
+	}
+
+	f, e = os.Open(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	defer f.Close()
+	decoded, e := decodeRows(t, f)
+	if nil != e {
+		t.Fatal(e)
+	}
+	if !reflect.DeepEqual(rows, decoded) {
+		t.Fatal("decoded rows differ")
+	}
+}
+
+func TestOcfEncoderAppendSchemaMismatch(t *testing.T) {
+	var filename string = filepath.Join(t.TempDir(), "p.avro")
+	f, e := os.Create(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	_ = encodeRows(t, f, codecConfig(t, bp.CodecNull), testRows(1))
+	_ = f.Close()
+
+	f, e = os.OpenFile(filename, os.O_RDWR, 0)
+	if nil != e {
+		t.Fatal(e)
+	}
+	defer f.Close()
+	_, e = eh.OcfEncoderNew(mustParse(t, `"long"`), f, eh.OcfConfig{})
+	if !errors.Is(e, eh.ErrSchemaMismatch) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+}
+
+// The records are appended without compression to a file without codec.
+func TestOcfEncoderAppendNoCodec(t *testing.T) {
+	header, e := ha.Marshal(ho.HeaderSchema, ho.Header{
+		Magic: [4]byte{'O', 'b', 'j', 1},
+		Meta:  map[string][]byte{"avro.schema": []byte(testSchema)},
+		Sync:  [16]byte{1, 2, 3},
+	})
+	if nil != e {
+		t.Fatal(e)
+	}
+	var filename string = filepath.Join(t.TempDir(), "p.avro")
+	e = os.WriteFile(filename, header, 0o644)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	f, e := os.OpenFile(filename, os.O_RDWR, 0)
+	if nil != e {
+		t.Fatal(e)
+	}
+	var rows []map[string]any = testRows(10)
+	enc := encodeRows(t, f, codecConfig(t, bp.CodecDeflate), rows)
+	_ = f.Close()
+	if bp.CodecNull != enc.Codec() {
+		t.Fatalf("codec: %v", enc.Codec())
+	}
+
+	f, e = os.Open(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	defer f.Close()
+	decoded, e := decodeRows(t, f)
+	if nil != e {
+		t.Fatal(e)
+	}
+	if !reflect.DeepEqual(rows, decoded) {
+		t.Fatal("decoded rows differ")
+	}
+}
+
+func TestOcfDecoderTruncated(t *testing.T) {
+	for _, codec := range allCodecs {
+		t.Run(string(codec), func(t *testing.T) {
+			var buf bytes.Buffer
+			_ = encodeRows(t, &buf, codecConfig(t, codec), testRows(50))
+
+			var truncated []byte = buf.Bytes()[:buf.Len()-5]
+			_, e := decodeRows(t, bytes.NewReader(truncated))
+			if nil == e {
+				t.Fatal("truncated file decoded")
+			}
+		})
+	}
+}
+
+func TestOcfEncoderSelect(t *testing.T) {
+	large, e := eh.CodecNew(bp.CodecXz, bp.CompressionLevelDefault)
+	if nil != e {
+		t.Fatal(e)
+	}
+	var small eh.BlockCodec = eh.NullCodec{}
+
+	tests := []struct {
+		name     string
+		sel      eh.CodecSelector
+		rows     int
+		expected bp.Codec
+	}{
+		{"size/small", eh.SelectBySize(small, large, 1<<20), 5, bp.CodecNull},
+		{"size/large", eh.SelectBySize(small, large, 16), 5, bp.CodecXz},
+		{"trial/tiny", eh.SelectByTrial(small, large), 1, bp.CodecNull},
+		{"trial/repeated", eh.SelectByTrial(small, large), 500, bp.CodecXz},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var cfg eh.OcfConfig = eh.OcfConfig{
+				BlockLength: 1000,
+				BlockCodec:  large,
+				Select:      test.sel,
+			}
+			var buf bytes.Buffer
+			enc := encodeRows(t, &buf, cfg, testRows(test.rows))
+			if test.expected != enc.Codec() {
+				t.Fatalf("codec: %v", enc.Codec())
+			}
+
+			h, e := eh.ReadOcfHeader(bytes.NewReader(buf.Bytes()))
+			if nil != e {
+				t.Fatal(e)
+			}
+			if string(test.expected) != string(h.Meta[eh.OcfCodecKey]) {
+				t.Fatalf("header codec: %s", h.Meta[eh.OcfCodecKey])
+			}
+
+			decoded, e := decodeRows(t, &buf)
+			if nil != e || test.rows != len(decoded) {
+				t.Fatalf("records: %v, error: %v", len(decoded), e)
+			}
+		})
+	}
+}
+
+func TestCodecNew(t *testing.T) {
+	_, e := eh.CodecNew("lz4", bp.CompressionLevelDefault)
+	if !errors.Is(e, bp.ErrUnknownCodec) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+
+	_, e = eh.CodecNew(bp.CodecDeflate, 42)
+	if nil == e {
+		t.Fatal("invalid deflate level accepted")
+	}
+
+	for _, codec := range allCodecs {
+		c, e := eh.CodecNew(codec, bp.CompressionLevelDefault)
+		if nil != e {
+			t.Fatal(e)
+		}
+		if codec != c.Name() {
+			t.Fatalf("name: %v", c.Name())
+		}
+
+		var raw []byte = bytes.Repeat([]byte("compressible "), 100)
+		compressed, e := c.Encode(raw)
+		if nil != e {
+			t.Fatal(e)
+		}
+		d, e := dh.CodecToDecoder(codec)
+		if nil != e {
+			t.Fatal(e)
+		}
+		decompressed, e := d.Decode(compressed)
+		if nil != e {
+			t.Fatal(e)
+		}
+		if !bytes.Equal(raw, decompressed) {
+			t.Fatalf("%v: round trip failed", codec)
+		}
+	}
+}
+
+func TestZstdCodecShared(t *testing.T) {
+	a, e := eh.ZstdCodecNew(3)
+	if nil != e {
+		t.Fatal(e)
+	}
+	b, e := eh.ZstdCodecNew(3)
+	if nil != e {
+		t.Fatal(e)
+	}
+	if a.Encoder != b.Encoder {
+		t.Fatal("encoder created per codec")
+	}
+}
+
+func TestConfigToOpts(t *testing.T) {
+	var rows []map[string]any = testRows(10)
+	for _, codec := range allCodecs[:4] {
+		var buf bytes.Buffer
+		e := eh.MapToWriterHamba(
+			rows[0],
+			&buf,
+			mustParse(t, testSchema),
+			eh.ConfigToOpts(bp.EncodeConfig{BlockLength: 1, Codec: codec})...,
+		)
+		if nil != e {
+			t.Fatal(e)
+		}
+		h, e := eh.ReadOcfHeader(&buf)
+		if nil != e {
+			t.Fatal(e)
+		}
+		if string(eh.CodecConv(codec)) != string(h.Meta[eh.OcfCodecKey]) {
+			t.Fatalf("codec: %s", h.Meta[eh.OcfCodecKey])
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package enc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+
+	ha "github.com/hamba/avro/v2"
+
+	. "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/util"
+
+	pk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/pkey"
+)
+
+// PlanAction is what a run would do to a partition.
+type PlanAction string
+
+const (
+	PlanCreate    PlanAction = "create"
+	PlanOverwrite PlanAction = "overwrite"
+	PlanAppend    PlanAction = "append"
+	PlanSkip      PlanAction = "skip"
+	PlanFail      PlanAction = "fail"
+)
+
+// PlanEntry describes a partition a run would write.
+type PlanEntry struct {
+	Key  string `json:"key"`
+	Path string `json:"path"`
+
+	// True if the file existed before the run(nil: unknown).
+	Exists *bool `json:"exists"`
+
+	Action PlanAction `json:"action"`
+
+	Records int `json:"records"`
+
+	// The size of the encoded records(uncompressed, without the container
+	// header and before the blobs are extracted).
+	EstimatedBytes int64 `json:"estimated_bytes"`
+}
+
+// Exists reports if the partition exists(e.g, os.Stat for local files).
+type Exists func(filename string) (bool, error)
+
+// LocalExists checks the local file.
+func LocalExists(filename string) (bool, error) {
+	_, e := os.Stat(filename)
+	switch {
+	case nil == e:
+		return true, nil
+	case errors.Is(e, fs.ErrNotExist):
+		return false, nil
+	default:
+		return false, e
+	}
+}
+
+// Planner computes the partitions of the records without writing them.
+type Planner struct {
+	ExistPolicy
+	Rolling
+
+	// True if a partition is written once per run(the EncoderPool);
+	// otherwise each record is written separately.
+	Grouped bool
+
+	// nil: the existence is unknown(assumed not to exist).
+	Exists
+
+	schema ha.Schema
+
+	// requested filename -> the current entry
+	current map[string]*PlanEntry
+
+	// all the entries(a requested file may have many versions)
+	planned []*PlanEntry
+	paths   map[string]struct{}
+
+	// requested filename -> the current segment(rolling only)
+	segments map[string]*segment
+}
+
+// PlannerNew creates a planner which encodes the records using the schema.
+func PlannerNew(schema string, policy ExistPolicy) (*Planner, error) {
+	parsed, e := ha.Parse(schema)
+	if nil != e {
+		return nil, e
+	}
+	return &Planner{
+		ExistPolicy: policy,
+		schema:      parsed,
+		current:     map[string]*PlanEntry{},
+		paths:       map[string]struct{}{},
+		segments:    map[string]*segment{},
+	}, nil
+}
+
+func (p *Planner) exists(filename string) (*bool, error) {
+	if nil == p.Exists {
+		return nil, nil
+	}
+	found, e := p.Exists(filename)
+	return &found, e
+}
+
+// versioned finds the first unused name like CreateVersioned.
+func (p *Planner) versioned(filename string) (string, error) {
+	var name string = filename
+	for version := 1; ; version++ {
+		_, planned := p.paths[name]
+		exists, e := p.exists(name)
+		if nil != e {
+			return "", e
+		}
+		if !planned && (nil == exists || !*exists) {
+			return name, nil
+		}
+		name = VersionedName(filename, version)
+	}
+}
+
+func (ent *PlanEntry) action(policy ExistPolicy) PlanAction {
+	if nil == ent.Exists || !*ent.Exists {
+		return PlanCreate
+	}
+	switch policy {
+	case ExistSkip:
+		return PlanSkip
+	case ExistFail:
+		return PlanFail
+	case ExistAppend:
+		return PlanAppend
+	default:
+		return PlanOverwrite
+	}
+}
+
+// create plans the first write of the requested file in this run.
+func (p *Planner) create(key string, filename string) error {
+	var path string = filename
+	var e error
+	if ExistVersion == p.ExistPolicy {
+		path, e = p.versioned(filename)
+		if nil != e {
+			return e
+		}
+	}
+
+	exists, e := p.exists(path)
+	if nil != e {
+		return e
+	}
+	var ent *PlanEntry = &PlanEntry{Key: key, Path: path, Exists: exists}
+	ent.Action = ent.action(p.ExistPolicy)
+
+	p.current[filename] = ent
+	p.planned = append(p.planned, ent)
+	p.paths[path] = struct{}{}
+	return nil
+}
+
+// target returns the name of the file(or the segment) to be written.
+func (p *Planner) target(filename string, size int64) string {
+	if !p.Grouped || !p.Rolling.Enabled() {
+		return filename
+	}
+	seg, found := p.segments[filename]
+	if !found {
+		seg = &segment{seq: SegmentFirst}
+		p.segments[filename] = seg
+	}
+	if p.Rolling.Full(seg.bytes, seg.records) {
+		seg.next()
+	}
+	seg.bytes += size
+	seg.records++
+	return SegmentName(filename, seg.seq)
+}
+
+// Add plans the write of the record.
+//
+// The records of a skipped partition are counted as dropped records.
+func (p *Planner) Add(key string, filename string, m map[string]any) error {
+	encoded, e := ha.Marshal(p.schema, m)
+	if nil != e {
+		return e
+	}
+	var size int64 = int64(len(encoded))
+
+	var name string = p.target(filename, size)
+	ent, found := p.current[name]
+	switch {
+	case !found:
+		e = p.create(key, name)
+	case p.Grouped:
+	case ExistVersion == p.ExistPolicy:
+		// each write creates a new version
+		e = p.create(key, name)
+	case ExistOverwrite == p.ExistPolicy:
+		ent.Records = 0
+		ent.EstimatedBytes = 0
+	case ExistSkip == p.ExistPolicy && PlanSkip != ent.Action:
+		// the file written by this run exists
+		return nil
+	case ExistFail == p.ExistPolicy:
+		ent.Action = PlanFail
+	}
+	if nil != e {
+		return e
+	}
+
+	ent = p.current[name]
+	ent.Records++
+	ent.EstimatedBytes += size
+	return nil
+}
+
+// Entries returns the planned partitions sorted by path.
+func (p *Planner) Entries() []PlanEntry {
+	var ret []PlanEntry = make([]PlanEntry, 0, len(p.planned))
+	for _, ent := range p.planned {
+		ret = append(ret, *ent)
+	}
+	slices.SortFunc(ret, func(a, b PlanEntry) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return ret
+}
+
+// WriteJsonl writes the entries as json lines.
+func (p *Planner) WriteJsonl(w io.Writer) error {
+	var enc *json.Encoder = json.NewEncoder(w)
+	for _, ent := range p.Entries() {
+		e := enc.Encode(ent)
+		if nil != e {
+			return e
+		}
+	}
+	return nil
+}
+
+func (p *Planner) ToSaver(pk2filename KeyToFilename) pk.RecordSaver {
+	return func(
+		key pk.PrimaryKey,
+		pw pk.PrimaryKeyWriter,
+		m map[string]any,
+	) IO[Void] {
+		return func(ctx context.Context) (Void, error) {
+			encoded, e := key(pw)(ctx)
+			if nil != e {
+				return Empty, e
+			}
+			filename, e := pk2filename(key, pw)(ctx)
+			if nil != e {
+				return Empty, e
+			}
+			return Empty, p.Add(encoded, filename, m)
+		}
+	}
+}
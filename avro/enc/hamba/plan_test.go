@@ -0,0 +1,170 @@
+package enc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+// planJsonl plans the rows of the keys and decodes the json lines.
+func planJsonl(
+	t *testing.T,
+	p *eh.Planner,
+	dir string,
+	keys []string,
+) []eh.PlanEntry {
+	t.Helper()
+	var rows []map[string]any = testRows(len(keys))
+	for i, key := range keys {
+		e := p.Add(key, filepath.Join(dir, key+".avro"), rows[i])
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+
+	var buf bytes.Buffer
+	e := p.WriteJsonl(&buf)
+	if nil != e {
+		t.Fatal(e)
+	}
+	var ret []eh.PlanEntry
+	var dec *json.Decoder = json.NewDecoder(&buf)
+	for dec.More() {
+		var ent eh.PlanEntry
+		e = dec.Decode(&ent)
+		if nil != e {
+			t.Fatal(e)
+		}
+		ent.Path = filepath.Base(ent.Path)
+		ret = append(ret, ent)
+	}
+	return ret
+}
+
+func plannerNew(t *testing.T, policy eh.ExistPolicy) *eh.Planner {
+	t.Helper()
+	p, e := eh.PlannerNew(testSchema, policy)
+	if nil != e {
+		t.Fatal(e)
+	}
+	p.Exists = eh.LocalExists
+	return p
+}
+
+// planned is the path, the action and the records of an entry.
+type planned struct {
+	path    string
+	action  eh.PlanAction
+	records int
+}
+
+func toPlanned(entries []eh.PlanEntry) []planned {
+	var ret []planned
+	for _, ent := range entries {
+		ret = append(ret, planned{ent.Path, ent.Action, ent.Records})
+	}
+	return ret
+}
+
+func TestPlanActions(t *testing.T) {
+	var dir string = t.TempDir()
+	_ = writeExisting(t, filepath.Join(dir, "a.avro"))
+
+	var tests map[eh.ExistPolicy][]planned = map[eh.ExistPolicy][]planned{
+		eh.ExistOverwrite: {
+			{"a.avro", eh.PlanOverwrite, 2},
+			{"b.avro", eh.PlanCreate, 1},
+		},
+		eh.ExistAppend: {
+			{"a.avro", eh.PlanAppend, 2},
+			{"b.avro", eh.PlanCreate, 1},
+		},
+		eh.ExistSkip: {
+			{"a.avro", eh.PlanSkip, 2},
+			{"b.avro", eh.PlanCreate, 1},
+		},
+		eh.ExistFail: {
+			{"a.avro", eh.PlanFail, 2},
+			{"b.avro", eh.PlanCreate, 1},
+		},
+		eh.ExistVersion: {
+			{"a.1.avro", eh.PlanCreate, 2},
+			{"b.avro", eh.PlanCreate, 1},
+		},
+	}
+	for policy, expected := range tests {
+		t.Run(string(policy), func(t *testing.T) {
+			var p *eh.Planner = plannerNew(t, policy)
+			p.Grouped = true
+			var actual []planned = toPlanned(
+				planJsonl(t, p, dir, []string{"a", "b", "a"}),
+			)
+			if !slices.Equal(expected, actual) {
+				t.Fatalf("unexpected plan: %v", actual)
+			}
+		})
+	}
+}
+
+// Each record is written separately without the pool.
+func TestPlanUngrouped(t *testing.T) {
+	var dir string = t.TempDir()
+	_ = writeExisting(t, filepath.Join(dir, "k.avro"))
+	var keys []string = []string{"k", "k", "k"}
+
+	var overwrite []planned = toPlanned(
+		planJsonl(t, plannerNew(t, eh.ExistOverwrite), dir, keys),
+	)
+	// the last record wins
+	if !slices.Equal([]planned{{"k.avro", eh.PlanOverwrite, 1}}, overwrite) {
+		t.Fatalf("unexpected plan: %v", overwrite)
+	}
+
+	var version []planned = toPlanned(
+		planJsonl(t, plannerNew(t, eh.ExistVersion), dir, keys),
+	)
+	var expected []planned = []planned{
+		{"k.1.avro", eh.PlanCreate, 1},
+		{"k.2.avro", eh.PlanCreate, 1},
+		{"k.3.avro", eh.PlanCreate, 1},
+	}
+	if !slices.Equal(expected, version) {
+		t.Fatalf("unexpected plan: %v", version)
+	}
+
+	// the versions written by the run are the planned versions
+	var stats []eh.PartitionStat
+	var fc eh.FsConfig = existConfig(dir, eh.ExistVersion, &stats)
+	for _, row := range testRows(len(keys)) {
+		e := fc.WriteMap(row, filepath.Join(dir, "k.avro"))
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+	for i, stat := range stats {
+		if expected[i].path != filepath.Base(stat.Filename) {
+			t.Fatalf("unexpected stat: %v", stat)
+		}
+	}
+}
+
+func TestPlanRolling(t *testing.T) {
+	var p *eh.Planner = plannerNew(t, eh.ExistOverwrite)
+	p.Grouped = true
+	p.Rolling = eh.Rolling{MaxRecords: 2}
+	var actual []planned = toPlanned(
+		planJsonl(t, p, t.TempDir(), []string{"k", "k", "k", "k", "k"}),
+	)
+	var expected []planned = []planned{
+		{"k.0001.avro", eh.PlanCreate, 2},
+		{"k.0002.avro", eh.PlanCreate, 2},
+		{"k.0003.avro", eh.PlanCreate, 1},
+	}
+	if !slices.Equal(expected, actual) {
+		t.Fatalf("unexpected plan: %v", actual)
+	}
+}
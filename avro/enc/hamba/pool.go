@@ -0,0 +1,408 @@
+package enc
+
+import (
+	"cmp"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+
+	ha "github.com/hamba/avro/v2"
+
+	. "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/util"
+
+	pk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/pkey"
+)
+
+const MaxOpenFilesDefault int = 64
+
+var ErrEvictionUnsupported error = errors.New(
+	"the partitions of the sink can not be evicted",
+)
+
+// PoolSize returns the number of the open partitions of the pool(0: no
+// pool).
+//
+// The rolling requires the pool(MaxOpenFilesDefault if maxOpen is not
+// positive). A sink which can not reopen the partitions(e.g, an archive)
+// keeps all the partitions open; maxOpen must not be positive.
+func PoolSize(maxOpen int, r Rolling, reopen bool) (int, error) {
+	switch {
+	case !reopen && 0 < maxOpen:
+		return 0, fmt.Errorf(
+			"%w: max open: %v",
+			ErrEvictionUnsupported,
+			maxOpen,
+		)
+	case !reopen:
+		return math.MaxInt, nil
+	case r.Enabled() && maxOpen <= 0:
+		return MaxOpenFilesDefault, nil
+	default:
+		return maxOpen, nil
+	}
+}
+
+type openPartition struct {
+	filename string
+	key      string
+	header   PartitionHeader
+	w        PartitionWriter
+	enc      RecordEncoder
+	elem     *list.Element
+
+	// the sequence number of the segment(0: no rolling)
+	seq int
+}
+
+func (o *openPartition) close(obs StatObserver) error {
+	e := o.enc.Close()
+	if nil != e {
+		return errors.Join(e, o.w.Abort())
+	}
+	stat, e := PartitionToStat(o.enc, o.w, o.key)
+	if nil != e {
+		return errors.Join(e, o.w.Abort())
+	}
+	stat.Segment = o.seq
+	e = o.w.Commit()
+	if nil != e {
+		return e
+	}
+	return obs.Observe(stat)
+}
+
+// EncoderPool keeps at most MaxOpen encoders(partitions of the sink) open.
+//
+// The least recently used encoder will be flushed and closed when a new
+// partition file is required. A file closed this way will be appended when
+// the same partition is written again; the StatObserver receives a stat for
+// each close.
+//
+// The partitions are split into segments if the Rolling is enabled. The
+// existing segments of the local files are found at the first write of a
+// partition: appended partitions continue after the last segment, and the
+// segments of overwritten partitions not written again are removed on Close
+// (the StatObserver receives the removed stats).
+//
+// The pool is not safe for concurrent use.
+type EncoderPool struct {
+	FsConfig
+	MaxOpen int
+
+	schema ha.Schema
+	ocfg   OcfConfig
+	sink   Sink
+
+	// most recently used first
+	lru  *list.List
+	open map[string]*openPartition
+
+	// requested filename -> created filename(empty: skipped)
+	created map[string]string
+
+	// requested filename -> the current segment
+	segments map[string]*segment
+}
+
+func (f FsConfig) ToPool(maxOpen int) (*EncoderPool, error) {
+	parsed, e := ha.Parse(f.Config.Schema)
+	if nil != e {
+		return nil, e
+	}
+	ocfg, e := ConfigToOcfConfig(f.Config.EncodeConfig)
+	if nil != e {
+		return nil, e
+	}
+	if nil == f.Digests {
+		f.Digests = DigestStatesNew()
+	}
+	return &EncoderPool{
+		FsConfig: f,
+		MaxOpen:  max(1, maxOpen),
+
+		schema: parsed,
+		ocfg:   ocfg,
+		sink:   f.ToSink(),
+
+		lru:     list.New(),
+		open:    map[string]*openPartition{},
+		created: map[string]string{},
+
+		segments: map[string]*segment{},
+	}, nil
+}
+
+func (p *EncoderPool) evict() error {
+	var back *list.Element = p.lru.Back()
+	if nil == back {
+		return nil
+	}
+
+	return p.closePartition(back.Value.(*openPartition))
+}
+
+func (p *EncoderPool) closePartition(o *openPartition) error {
+	p.lru.Remove(o.elem)
+	delete(p.open, o.filename)
+	e := o.close(p.StatObserver)
+	seg, found := p.segments[o.filename]
+	if found {
+		seg.add(o.enc)
+	}
+	return e
+}
+
+// segment returns the current segment of the partition(nil: no rolling).
+func (p *EncoderPool) segment(filename string) (*segment, error) {
+	if !p.Rolling.Enabled() {
+		return nil, nil
+	}
+	seg, found := p.segments[filename]
+	if found {
+		return seg, nil
+	}
+
+	seg = &segment{seq: SegmentFirst}
+	if nil == p.FsConfig.Sink {
+		existing, e := ExistingSegments(filename)
+		if nil != e {
+			return nil, e
+		}
+		switch p.ExistPolicy {
+		case ExistAppend:
+			if 0 < len(existing) {
+				seg.seq = existing[len(existing)-1] + 1
+			}
+		case ExistSkip, ExistFail, ExistVersion:
+		default:
+			seg.stale = existing
+		}
+	}
+	p.segments[filename] = seg
+	return seg, nil
+}
+
+// removeStale removes the segments of the overwritten partitions which were
+// not written again.
+func (p *EncoderPool) removeStale() error {
+	for _, filename := range slices.Sorted(maps.Keys(p.segments)) {
+		var seg *segment = p.segments[filename]
+		for _, seq := range seg.stale {
+			if seq <= seg.seq {
+				continue
+			}
+			var name string = SegmentName(filename, seq)
+			e := RemoveFile(name)
+			if nil == e {
+				e = p.StatObserver.Observe(PartitionStat{
+					Filename: name,
+					Key:      seg.key,
+					Segment:  seq,
+					Removed:  true,
+				})
+			}
+			if nil != e {
+				return e
+			}
+		}
+		seg.stale = nil
+	}
+	return nil
+}
+
+// roll closes the full segment and starts the next segment.
+func (p *EncoderPool) roll(o *openPartition) (*openPartition, error) {
+	var seg *segment = p.segments[o.filename]
+	e := p.closePartition(o)
+	if nil != e {
+		return nil, e
+	}
+	seg.next()
+	return p.get(o.filename, o.header)
+}
+
+func (p *EncoderPool) create(
+	filename string,
+	key string,
+) (PartitionWriter, error) {
+	created, found := p.created[filename]
+	if found {
+		// the partition was created by this pool; append to it
+		return p.sink.Reopen(created)
+	}
+
+	w, e := p.sink.Create(filename)
+	if errors.Is(e, ErrSkipPartition) {
+		p.created[filename] = ""
+		return nil, p.StatObserver.Observe(PartitionStat{
+			Filename: filename,
+			Key:      key,
+			Skipped:  true,
+		})
+	}
+	if nil != e {
+		return nil, e
+	}
+
+	p.created[filename] = w.Name()
+	return w, nil
+}
+
+func (p *EncoderPool) get(
+	filename string,
+	header PartitionHeader,
+) (*openPartition, error) {
+	o, found := p.open[filename]
+	if found {
+		p.lru.MoveToFront(o.elem)
+		return o, nil
+	}
+
+	var name string = filename
+	var seq int
+	seg, e := p.segment(filename)
+	if nil != e {
+		return nil, e
+	}
+	if nil != seg {
+		seg.key = header.Key
+		name = SegmentName(filename, seg.seq)
+		seq = seg.seq
+	}
+
+	created, found := p.created[name]
+	if found && "" == created {
+		return nil, nil
+	}
+
+	for p.MaxOpen <= p.lru.Len() {
+		e := p.evict()
+		if nil != e {
+			return nil, e
+		}
+	}
+
+	w, e := p.create(name, header.Key)
+	if nil == w || nil != e {
+		return nil, e
+	}
+	// the segments of a partition are counted once
+	w, e = p.Limiter.Created(w, cmp.Or(header.Key, filename))
+	if nil != e {
+		return nil, e
+	}
+
+	enc, e := p.Config.OutputFormat.EncoderNew(
+		p.schema,
+		w,
+		header.Apply(p.ocfg, p.schema),
+	)
+	if nil != e {
+		return nil, errors.Join(e, w.Abort())
+	}
+
+	o = &openPartition{
+		filename: filename,
+		key:      header.Key,
+		header:   header,
+		w:        w,
+		enc:      enc,
+		seq:      seq,
+	}
+	o.elem = p.lru.PushFront(o)
+	p.open[filename] = o
+	return o, nil
+}
+
+// WriteMap encodes the map using the (possibly cached) encoder.
+//
+// The header will be used only if a new file is created; the next segment
+// will use the header of the full segment.
+func (p *EncoderPool) WriteMap(
+	m map[string]any,
+	filename string,
+	header PartitionHeader,
+) error {
+	o, e := p.get(filename, header)
+	if nil == o || nil != e {
+		return e
+	}
+	if 0 < o.seq && p.segments[filename].full(p.Rolling, o.enc) {
+		o, e = p.roll(o)
+		if nil == o || nil != e {
+			return e
+		}
+	}
+	converted, e := p.ToConverter(o.w.Name())(m)
+	if nil != e {
+		return e
+	}
+	return o.enc.Encode(converted)
+}
+
+// Close flushes and closes all the open encoders, then removes the stale
+// segments.
+func (p *EncoderPool) Close() error {
+	var errs []error
+	var limited bool
+	for 0 < p.lru.Len() {
+		e := p.evict()
+		switch {
+		case nil == e:
+		case errors.Is(e, ErrLimitExceeded):
+			// the Limiter fails all the encoders with the same error
+			if !limited {
+				errs = append(errs, e)
+			}
+			limited = true
+		default:
+			errs = append(errs, e)
+		}
+	}
+	if 0 < len(errs) {
+		return errors.Join(errs...)
+	}
+	return p.removeStale()
+}
+
+func (p *EncoderPool) ToSaver(pk2filename KeyToFilename) pk.RecordSaver {
+	return func(
+		pk pk.PrimaryKey,
+		pw pk.PrimaryKeyWriter,
+		m map[string]any,
+	) IO[Void] {
+		return func(ctx context.Context) (Void, error) {
+			filename, e := pk2filename(pk, pw)(ctx)
+			if nil != e {
+				return Empty, e
+			}
+
+			_, opened := p.open[filename]
+			if opened {
+				return Empty, p.WriteMap(m, filename, PartitionHeader{})
+			}
+
+			header, e := p.FsConfig.KeyToHeader(pk, pw)(ctx)
+			if nil != e {
+				return Empty, e
+			}
+
+			return Empty, p.WriteMap(m, filename, header)
+		}
+	}
+}
+
+// ToRecordsSaver creates a saver which closes the pool after saving all.
+func (p *EncoderPool) ToRecordsSaver(
+	pk2filename KeyToFilename,
+) pk.RecordsSaver {
+	return p.ToSaver(pk2filename).WithCloser(p.Close)
+}
+
+func (p *EncoderPool) SaverFromDirnameDefault() pk.RecordsSaver {
+	return p.ToRecordsSaver(p.FsConfig.ToKeyToFilename())
+}
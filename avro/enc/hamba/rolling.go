@@ -0,0 +1,117 @@
+package enc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Rolling starts the next segment of a partition when the current segment is
+// full; zero values disable the limits.
+//
+// The segments are named using the sequence number(e.g, key.0001.avro,
+// key.0002.avro) and the stats(and the manifest) have the sequence numbers.
+type Rolling struct {
+	// The maximum size of a segment in bytes.
+	//
+	// The size(including the buffered records before compressed) is checked
+	// before each record; the segment can exceed the limit by a record.
+	MaxBytes int64
+
+	// The maximum number of records of a segment.
+	MaxRecords int
+}
+
+// Enabled is true if any limit is set.
+func (r Rolling) Enabled() bool { return 0 < r.MaxBytes || 0 < r.MaxRecords }
+
+// Full is true if no more records should be written to the segment.
+func (r Rolling) Full(bytes int64, records int) bool {
+	var sizeFull bool = 0 < r.MaxBytes && r.MaxBytes <= bytes
+	var countFull bool = 0 < r.MaxRecords && r.MaxRecords <= records
+	return sizeFull || countFull
+}
+
+// The first sequence number.
+const SegmentFirst int = 1
+
+// SegmentName returns the name of the segment(e.g, key.0001.avro).
+func SegmentName(filename string, seq int) string {
+	var ext string = filepath.Ext(filename)
+	var noext string = strings.TrimSuffix(filename, ext)
+	return fmt.Sprintf("%s.%04d%s", noext, seq, ext)
+}
+
+// SegmentSeq returns the sequence number of the segment of the partition
+// (false: not a segment of the partition).
+func SegmentSeq(filename string, segment string) (int, bool) {
+	var ext string = filepath.Ext(filename)
+	var noext string = strings.TrimSuffix(filename, ext)
+	digits, found := strings.CutPrefix(segment, noext+".")
+	if !found || !strings.HasSuffix(digits, ext) {
+		return 0, false
+	}
+	digits = strings.TrimSuffix(digits, ext)
+	var numeric bool = !strings.ContainsFunc(digits, func(r rune) bool {
+		return r < '0' || '9' < r
+	})
+	if len(digits) < 4 || !numeric {
+		return 0, false
+	}
+	seq, e := strconv.Atoi(digits)
+	return seq, nil == e && SegmentFirst <= seq
+}
+
+// ExistingSegments returns the sorted sequence numbers of the segments of
+// the local partition.
+func ExistingSegments(filename string) ([]int, error) {
+	var base string = filepath.Base(filename)
+	entries, e := os.ReadDir(filepath.Dir(filename))
+	if errors.Is(e, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if nil != e {
+		return nil, e
+	}
+	var ret []int
+	for _, ent := range entries {
+		seq, found := SegmentSeq(base, ent.Name())
+		if found && !ent.IsDir() {
+			ret = append(ret, seq)
+		}
+	}
+	slices.Sort(ret)
+	return ret, nil
+}
+
+// segment is the current segment of a partition.
+type segment struct {
+	seq int
+	key string
+
+	// the existing segments to be removed after the run(overwrite)
+	stale []int
+
+	// the bytes and the records written by the closed encoders
+	bytes   int64
+	records int
+}
+
+func (s *segment) full(r Rolling, enc RecordEncoder) bool {
+	var size int64 = s.bytes + enc.Bytes() + enc.Buffered()
+	return r.Full(size, s.records+enc.Records())
+}
+
+func (s *segment) add(enc RecordEncoder) {
+	s.bytes += enc.Bytes()
+	s.records += enc.Records()
+}
+
+func (s *segment) next() {
+	*s = segment{seq: s.seq + 1, key: s.key, stale: s.stale}
+}
@@ -0,0 +1,186 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+// writeRolled writes the rows to the segments of k.avro(2 records each).
+func writeRolled(
+	t *testing.T,
+	dir string,
+	policy eh.ExistPolicy,
+	rows int,
+) []eh.PartitionStat {
+	t.Helper()
+	var stats []eh.PartitionStat
+	var fc eh.FsConfig = memConfig(nil)
+	fc.ExistPolicy = policy
+	fc.Rolling = eh.Rolling{MaxRecords: 2}
+	fc.StatObserver = func(s eh.PartitionStat) error {
+		stats = append(stats, s)
+		return nil
+	}
+	pool, e := fc.ToPool(1)
+	if nil != e {
+		t.Fatal(e)
+	}
+	for _, row := range testRows(rows) {
+		e = pool.WriteMap(row, filepath.Join(dir, "k.avro"), eh.PartitionHeader{})
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+	e = pool.Close()
+	if nil != e {
+		t.Fatal(e)
+	}
+	return stats
+}
+
+func segmentRecords(t *testing.T, dir string) []int {
+	t.Helper()
+	var ret []int
+	for _, name := range dirNames(t, dir) {
+		f, e := os.Open(filepath.Join(dir, name))
+		if nil != e {
+			t.Fatal(e)
+		}
+		rows, e := decodeRows(t, f)
+		_ = f.Close()
+		if nil != e {
+			t.Fatal(e)
+		}
+		ret = append(ret, len(rows))
+	}
+	return ret
+}
+
+func TestRollingAppend(t *testing.T) {
+	var dir string = t.TempDir()
+	writeRolled(t, dir, eh.ExistAppend, 3)
+	writeRolled(t, dir, eh.ExistAppend, 3)
+
+	// the appended segments follow the existing segments
+	var expected []string = []string{
+		"k.0001.avro", "k.0002.avro", "k.0003.avro", "k.0004.avro",
+	}
+	if !slices.Equal(expected, dirNames(t, dir)) {
+		t.Fatalf("unexpected segments: %v", dirNames(t, dir))
+	}
+	if !slices.Equal([]int{2, 1, 2, 1}, segmentRecords(t, dir)) {
+		t.Fatalf("unexpected records: %v", segmentRecords(t, dir))
+	}
+}
+
+func TestRollingOverwrite(t *testing.T) {
+	var dir string = t.TempDir()
+	writeRolled(t, dir, eh.ExistOverwrite, 6)
+	var stats []eh.PartitionStat = writeRolled(t, dir, eh.ExistOverwrite, 3)
+
+	// the stale segment is removed
+	var expected []string = []string{"k.0001.avro", "k.0002.avro"}
+	if !slices.Equal(expected, dirNames(t, dir)) {
+		t.Fatalf("unexpected segments: %v", dirNames(t, dir))
+	}
+	if !slices.Equal([]int{2, 1}, segmentRecords(t, dir)) {
+		t.Fatalf("unexpected records: %v", segmentRecords(t, dir))
+	}
+	var last eh.PartitionStat = stats[len(stats)-1]
+	if !last.Removed || 3 != last.Segment {
+		t.Fatalf("unexpected stat: %v", last)
+	}
+}
+
+func TestSegmentSeq(t *testing.T) {
+	var cases = map[string]int{
+		"k.0001.avro":       1,
+		"k.12345.avro":      12345,
+		"k.0000.avro":       0,
+		"k.001.avro":        0,
+		"k.0001.avro.sig":   0,
+		"k.abcd.avro":       0,
+		"k.x.0001.avro":     0,
+		"k.0001.avro.1.tmp": 0,
+	}
+	for name, expected := range cases {
+		seq, found := eh.SegmentSeq("k.avro", name)
+		if expected != seq || found != (0 < expected) {
+			t.Errorf("%s: %v %v", name, seq, found)
+		}
+	}
+}
+
+// The buffered records count toward the size of the segment; a segment
+// exceeds the size by a record at most.
+func TestRollingLargeRecords(t *testing.T) {
+	var dir string = t.TempDir()
+	var fc eh.FsConfig = memConfig(nil)
+	fc.Rolling = eh.Rolling{MaxBytes: 4096}
+	pool, e := fc.ToPool(1)
+	if nil != e {
+		t.Fatal(e)
+	}
+	// the records fit in a block
+	for i, row := range testRows(20) {
+		row["data"] = bytes.Repeat([]byte{byte(i)}, 1000)
+		e = pool.WriteMap(row, filepath.Join(dir, "k.avro"), eh.PartitionHeader{})
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+	e = pool.Close()
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	if !slices.Equal([]int{4, 4, 4, 4, 4}, segmentRecords(t, dir)) {
+		t.Fatalf("unexpected records: %v", segmentRecords(t, dir))
+	}
+	// a record and the header
+	var margin int64 = 1024 + int64(len(testSchema)) + 128
+	for _, name := range dirNames(t, dir) {
+		stat, e := os.Stat(filepath.Join(dir, name))
+		if nil != e {
+			t.Fatal(e)
+		}
+		if fc.Rolling.MaxBytes+margin < stat.Size() {
+			t.Fatalf("%s: %v bytes", name, stat.Size())
+		}
+	}
+}
+
+func TestPoolSize(t *testing.T) {
+	var rolled eh.Rolling = eh.Rolling{MaxRecords: 2}
+	tests := []struct {
+		maxOpen  int
+		rolling  eh.Rolling
+		reopen   bool
+		expected int
+	}{
+		{0, eh.Rolling{}, true, 0},
+		{8, eh.Rolling{}, true, 8},
+		{0, rolled, true, eh.MaxOpenFilesDefault},
+		{8, rolled, true, 8},
+		{0, eh.Rolling{}, false, math.MaxInt},
+		{0, rolled, false, math.MaxInt},
+	}
+	for _, test := range tests {
+		size, e := eh.PoolSize(test.maxOpen, test.rolling, test.reopen)
+		if nil != e || test.expected != size {
+			t.Fatalf("%+v: size: %v, error: %v", test, size, e)
+		}
+	}
+
+	_, e := eh.PoolSize(8, eh.Rolling{}, false)
+	if !errors.Is(e, eh.ErrEvictionUnsupported) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+}
@@ -0,0 +1,57 @@
+package enc
+
+import (
+	"errors"
+
+	pk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/pkey"
+)
+
+// SaverConfig creates the savers of the partitions.
+type SaverConfig struct {
+	Fs FsConfig
+
+	// The number of the open partitions of the pool(0: no pool).
+	MaxOpen int
+
+	Cas CasConfig
+
+	// The blobs only mode extracts the blobs without the partitions; the
+	// blobs of the other modes are extracted by the FsConfig.
+	Blob BlobConfig
+}
+
+// ToRecordsSaver creates a saver which stores the large blobs first, then
+// extracts the blobs(BlobOnly) or writes the partitions.
+//
+// The blobs count toward the limits of the FsConfig. The pool and the json
+// sidecars are closed after saving all the records; the sink is not.
+func (c SaverConfig) ToRecordsSaver(
+	pk2filename KeyToFilename,
+) (pk.RecordsSaver, error) {
+	var saver pk.RecordSaver = c.Fs.ToSaver(pk2filename)
+	var closer func() error = func() error { return nil }
+	if 0 < c.MaxOpen {
+		pool, e := c.Fs.ToPool(c.MaxOpen)
+		if nil != e {
+			return nil, e
+		}
+		saver = pool.ToSaver(pk2filename)
+		closer = pool.Close
+	}
+
+	var cas CasConfig = c.Cas
+	if nil != c.Fs.Limiter {
+		cas.Store.Reserve = c.Fs.Limiter.Reserve
+	}
+	if BlobOnly != c.Blob.BlobMode {
+		return cas.Wrap(saver).WithCloser(closer), nil
+	}
+
+	var bc BlobConfig = c.Blob
+	bc.Limiter = c.Fs.Limiter
+	bc.SidecarPolicy = c.Fs.ExistPolicy
+	var ext *BlobExtractor = bc.ToExtractor()
+	return cas.Wrap(ext.Wrap(saver, pk2filename)).WithCloser(func() error {
+		return errors.Join(closer(), ext.Close())
+	}), nil
+}
@@ -0,0 +1,429 @@
+package enc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+var (
+	// ErrSkipPartition tells the saver to keep the existing partition.
+	ErrSkipPartition error = errors.New("partition skipped")
+
+	ErrPartitionNotFound error = errors.New("partition not found")
+
+	ErrTruncateUnsupported error = errors.New("truncate unsupported")
+	ErrReplaceUnsupported  error = errors.New("replace unsupported")
+)
+
+// PartitionWriter is a partition being written to a Sink.
+//
+// A writer which also implements io.ReadSeeker allows appending to an
+// existing OCF.
+type PartitionWriter interface {
+	io.Writer
+
+	// The actual name of the partition(e.g, a versioned filename).
+	Name() string
+
+	// Commit makes the written partition durable and releases the writer.
+	Commit() error
+
+	// Abort releases the writer without committing.
+	Abort() error
+}
+
+// UnwrapPartition returns the wrapped partition(nil if not wrapped).
+func UnwrapPartition(w PartitionWriter) PartitionWriter {
+	u, ok := w.(interface{ Unwrap() PartitionWriter })
+	if !ok {
+		return nil
+	}
+	return u.Unwrap()
+}
+
+// TruncatePartition truncates the (possibly wrapped) partition.
+func TruncatePartition(w PartitionWriter, size int64) error {
+	for nil != w {
+		t, ok := w.(interface{ Truncate(int64) error })
+		if ok {
+			return t.Truncate(size)
+		}
+		w = UnwrapPartition(w)
+	}
+	return ErrTruncateUnsupported
+}
+
+// ReplacePartition lets the (possibly wrapped) partition be written again
+// from the start; the existing content is kept until the commit.
+func ReplacePartition(w PartitionWriter) error {
+	for nil != w {
+		r, ok := w.(interface{ Replace() error })
+		if ok {
+			return r.Replace()
+		}
+		w = UnwrapPartition(w)
+	}
+	return ErrReplaceUnsupported
+}
+
+// FinishPartition writes the trailer of the partition before the commit.
+//
+// The partitions which write the trailers(e.g, the last encrypted chunk)
+// implement Finish; the others are ignored.
+func FinishPartition(w PartitionWriter) error {
+	f, ok := w.(interface{ Finish() error })
+	if !ok {
+		return nil
+	}
+	return f.Finish()
+}
+
+// readSeeker exposes the io.ReadSeeker of a wrapped partition(e.g, to
+// append to an OCF) and keeps the offset of the wrapper.
+type readSeeker struct {
+	rs  io.ReadSeeker
+	pos *int64
+
+	// Called after a seek(nil: ignored).
+	seeked func(pos int64)
+}
+
+func (r readSeeker) Read(buf []byte) (int, error) {
+	n, e := r.rs.Read(buf)
+	*r.pos += int64(n)
+	return n, e
+}
+
+func (r readSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, e := r.rs.Seek(offset, whence)
+	if nil != e {
+		return pos, e
+	}
+	*r.pos = pos
+	if nil != r.seeked {
+		r.seeked(pos)
+	}
+	return pos, nil
+}
+
+// existingSize returns the size of the partition and rewinds it.
+func existingSize(rs io.ReadSeeker) (int64, error) {
+	size, e := rs.Seek(0, io.SeekEnd)
+	if nil != e {
+		return 0, e
+	}
+	_, e = rs.Seek(0, io.SeekStart)
+	return size, e
+}
+
+// RemoveFile removes the file; a missing file is ignored.
+func RemoveFile(name string) error {
+	e := os.Remove(name)
+	if errors.Is(e, fs.ErrNotExist) {
+		return nil
+	}
+	return e
+}
+
+// Sink stores partitions by their paths.
+type Sink interface {
+	// Create starts writing the partition.
+	//
+	// The error wraps ErrSkipPartition if the existing partition must be
+	// kept.
+	Create(path string) (PartitionWriter, error)
+
+	// Reopen continues writing the partition committed in this run.
+	Reopen(name string) (PartitionWriter, error)
+}
+
+// CloseSink closes the sink if it is an io.Closer(e.g, a database).
+func CloseSink(s Sink) error {
+	closer, ok := s.(io.Closer)
+	if !ok {
+		return nil
+	}
+	return closer.Close()
+}
+
+// FsSink writes partitions as local files.
+type FsSink struct {
+	ExistPolicy
+
+	// Called on commit(nil: no sync).
+	Sync func(*os.File) error
+}
+
+func (s FsSink) sync() func(*os.File) error {
+	if nil == s.Sync {
+		return func(_ *os.File) error { return nil }
+	}
+	return s.Sync
+}
+
+// fsPartition is a file which is synced on commit.
+//
+// Abort rolls back the partition: a created file is removed and an existing
+// file is truncated to its size before the writes.
+type fsPartition struct {
+	*os.File
+	sync func(*os.File) error
+
+	// The name of the partition if the file is a temporary file.
+	name string
+
+	created bool
+	size    int64
+}
+
+func (f *fsPartition) Name() string {
+	if "" == f.name {
+		return f.File.Name()
+	}
+	return f.name
+}
+
+func (f *fsPartition) Commit() error {
+	e := errors.Join(f.sync(f.File), f.File.Close())
+	if nil != e || "" == f.name {
+		return e
+	}
+	return os.Rename(f.File.Name(), f.name)
+}
+
+func (f *fsPartition) Abort() error {
+	var closed error = f.File.Close()
+	switch {
+	case f.created:
+		return errors.Join(closed, os.Remove(f.File.Name()))
+	case f.size < 0:
+		// a file not opened by the sink is kept as it is
+		return closed
+	default:
+		return errors.Join(closed, os.Truncate(f.File.Name(), f.size))
+	}
+}
+
+// FileToPartitionWriter wraps the file; Abort only closes the file.
+func FileToPartitionWriter(
+	f *os.File,
+	sync func(*os.File) error,
+) PartitionWriter {
+	return &fsPartition{File: f, sync: sync, size: -1}
+}
+
+// Replace writes the partition to a temporary file which replaces the
+// existing file on commit; a created file is truncated.
+func (f *fsPartition) Replace() error {
+	switch {
+	case f.created:
+		_, e := f.File.Seek(0, io.SeekStart)
+		if nil != e {
+			return e
+		}
+		return f.File.Truncate(0)
+	case f.size < 0:
+		return ErrReplaceUnsupported
+	}
+
+	tmp, e := tempFile(f.File.Name())
+	if nil != e {
+		return e
+	}
+	e = f.File.Close()
+	if nil != e {
+		return errors.Join(e, tmp.Close(), os.Remove(tmp.Name()))
+	}
+	f.name = f.File.Name()
+	f.File = tmp
+	f.created = true
+	return nil
+}
+
+// openExisting opens the file to be rolled back to its current size.
+func (s FsSink) openExisting(name string, flag int) (PartitionWriter, error) {
+	f, e := os.OpenFile(name, flag, FileModeDefault)
+	if nil != e {
+		return nil, e
+	}
+	stat, e := f.Stat()
+	if nil != e {
+		return nil, errors.Join(e, f.Close())
+	}
+	return &fsPartition{File: f, sync: s.sync(), size: stat.Size()}, nil
+}
+
+// tempFile creates a temporary file next to the path(e.g,
+// key.avro.0123.tmp).
+func tempFile(path string) (*os.File, error) {
+	f, e := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if nil != e {
+		return nil, e
+	}
+	e = f.Chmod(FileModeDefault)
+	if nil != e {
+		return nil, errors.Join(e, f.Close(), os.Remove(f.Name()))
+	}
+	return f, nil
+}
+
+// createTemp writes the partition to a temporary file which replaces the
+// existing file on commit.
+func (s FsSink) createTemp(path string) (PartitionWriter, error) {
+	f, e := tempFile(path)
+	if nil != e {
+		return nil, e
+	}
+	return &fsPartition{File: f, sync: s.sync(), name: path, created: true}, nil
+}
+
+func (s FsSink) Create(path string) (PartitionWriter, error) {
+	switch s.ExistPolicy {
+	case ExistAppend:
+		w, e := s.openExisting(path, os.O_RDWR)
+		if !errors.Is(e, fs.ErrNotExist) {
+			return w, e
+		}
+	case ExistSkip, ExistFail, ExistVersion:
+	default:
+		return s.createTemp(path)
+	}
+
+	f, e := s.ExistPolicy.Create(path)
+	if s.ExistPolicy.Skip(e) {
+		return nil, fmt.Errorf("%w: %w", ErrSkipPartition, e)
+	}
+	if nil != e {
+		return nil, e
+	}
+	return &fsPartition{File: f, sync: s.sync(), created: true}, nil
+}
+
+func (s FsSink) Reopen(name string) (PartitionWriter, error) {
+	return s.openExisting(name, os.O_RDWR)
+}
+
+// MemSink keeps the committed partitions in memory(e.g, for tests).
+//
+// Create always overwrites an existing partition. MemSink is safe for
+// concurrent use.
+type MemSink struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func MemSinkNew() *MemSink {
+	return &MemSink{files: map[string][]byte{}}
+}
+
+// MemFile is an in-memory seekable file.
+type MemFile struct {
+	Data   []byte
+	offset int64
+}
+
+func (m *MemFile) Read(p []byte) (int, error) {
+	if int64(len(m.Data)) <= m.offset {
+		return 0, io.EOF
+	}
+	var n int = copy(p, m.Data[m.offset:])
+	m.offset += int64(n)
+	return n, nil
+}
+
+func (m *MemFile) Write(p []byte) (int, error) {
+	var end int64 = m.offset + int64(len(p))
+	if int64(len(m.Data)) < end {
+		m.Data = append(m.Data, make([]byte, end-int64(len(m.Data)))...)
+	}
+	copy(m.Data[m.offset:], p)
+	m.offset = end
+	return len(p), nil
+}
+
+// Truncate changes the size; the offset is kept.
+func (m *MemFile) Truncate(size int64) error {
+	if size < 0 {
+		return fs.ErrInvalid
+	}
+	if size <= int64(len(m.Data)) {
+		m.Data = m.Data[:size]
+		return nil
+	}
+	m.Data = append(m.Data, make([]byte, size-int64(len(m.Data)))...)
+	return nil
+}
+
+func (m *MemFile) Seek(offset int64, whence int) (int64, error) {
+	var base int64
+	switch whence {
+	case io.SeekStart:
+		base = 0
+	case io.SeekCurrent:
+		base = m.offset
+	case io.SeekEnd:
+		base = int64(len(m.Data))
+	default:
+		return 0, fs.ErrInvalid
+	}
+	if base+offset < 0 {
+		return 0, fs.ErrInvalid
+	}
+	m.offset = base + offset
+	return m.offset, nil
+}
+
+type memPartition struct {
+	MemFile
+	sink *MemSink
+	name string
+}
+
+func (m *memPartition) Name() string { return m.name }
+
+func (m *memPartition) Commit() error {
+	m.sink.mu.Lock()
+	defer m.sink.mu.Unlock()
+	m.sink.files[m.name] = m.Data
+	return nil
+}
+
+func (m *memPartition) Abort() error { return nil }
+
+func (s *MemSink) Create(path string) (PartitionWriter, error) {
+	return &memPartition{sink: s, name: path}, nil
+}
+
+func (s *MemSink) Reopen(name string) (PartitionWriter, error) {
+	data, found := s.Get(name)
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrPartitionNotFound, name)
+	}
+	return &memPartition{
+		MemFile: MemFile{Data: data},
+		sink:    s,
+		name:    name,
+	}, nil
+}
+
+// Get returns a copy of the committed partition.
+func (s *MemSink) Get(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, found := s.files[name]
+	return slices.Clone(data), found
+}
+
+// Names returns the sorted names of the committed partitions.
+func (s *MemSink) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.files))
+}
@@ -0,0 +1,234 @@
+package enc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+func writePartition(t *testing.T, w eh.PartitionWriter, data string) {
+	t.Helper()
+	_, e := w.Write([]byte(data))
+	if nil != e {
+		t.Fatal(e)
+	}
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, e := os.ReadDir(dir)
+	if nil != e {
+		t.Fatal(e)
+	}
+	var ret []string
+	for _, ent := range entries {
+		ret = append(ret, ent.Name())
+	}
+	return ret
+}
+
+func TestFsSinkAbort(t *testing.T) {
+	var cases = []struct {
+		policy   eh.ExistPolicy
+		existing bool
+	}{
+		{eh.ExistOverwrite, false},
+		{eh.ExistOverwrite, true},
+		{eh.ExistAppend, false},
+		{eh.ExistAppend, true},
+		{eh.ExistFail, false},
+		{eh.ExistVersion, true},
+	}
+	for _, c := range cases {
+		t.Run(string(c.policy), func(t *testing.T) {
+			var dir string = t.TempDir()
+			var name string = filepath.Join(dir, "k.avro")
+			if c.existing {
+				e := os.WriteFile(name, []byte("old"), 0o644)
+				if nil != e {
+					t.Fatal(e)
+				}
+			}
+
+			w, e := eh.FsSink{ExistPolicy: c.policy}.Create(name)
+			if nil != e {
+				t.Fatal(e)
+			}
+			if rs, ok := w.(io.Seeker); ok {
+				_, e = rs.Seek(0, io.SeekEnd)
+				if nil != e {
+					t.Fatal(e)
+				}
+			}
+			writePartition(t, w, "new")
+			e = w.Abort()
+			if nil != e {
+				t.Fatal(e)
+			}
+
+			var expected []string
+			if c.existing {
+				expected = []string{"k.avro"}
+				data, e := os.ReadFile(name)
+				if nil != e || "old" != string(data) {
+					t.Fatalf("existing partition modified: %q %v", data, e)
+				}
+			}
+			var names []string = dirNames(t, dir)
+			if len(expected) != len(names) {
+				t.Fatalf("unexpected files: %v", names)
+			}
+		})
+	}
+}
+
+func TestFsSinkOverwriteCommit(t *testing.T) {
+	var dir string = t.TempDir()
+	var name string = filepath.Join(dir, "k.avro")
+	e := os.WriteFile(name, []byte("old content"), 0o644)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	w, e := eh.FsSink{ExistPolicy: eh.ExistOverwrite}.Create(name)
+	if nil != e {
+		t.Fatal(e)
+	}
+	if name != w.Name() {
+		t.Fatalf("unexpected name: %s", w.Name())
+	}
+	writePartition(t, w, "new")
+
+	// the existing partition is kept until the commit
+	data, e := os.ReadFile(name)
+	if nil != e || "old content" != string(data) {
+		t.Fatalf("overwritten before the commit: %q %v", data, e)
+	}
+
+	e = w.Commit()
+	if nil != e {
+		t.Fatal(e)
+	}
+	data, e = os.ReadFile(name)
+	if nil != e || "new" != string(data) {
+		t.Fatalf("unexpected content: %q %v", data, e)
+	}
+	if names := dirNames(t, dir); 1 != len(names) {
+		t.Fatalf("unexpected files: %v", names)
+	}
+}
+
+func TestFsSinkSkip(t *testing.T) {
+	var dir string = t.TempDir()
+	var name string = filepath.Join(dir, "k.avro")
+	e := os.WriteFile(name, []byte("old"), 0o644)
+	if nil != e {
+		t.Fatal(e)
+	}
+	_, e = eh.FsSink{ExistPolicy: eh.ExistSkip}.Create(name)
+	if !errors.Is(e, eh.ErrSkipPartition) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+}
+
+func memConfig(sink eh.Sink) eh.FsConfig {
+	return eh.FsConfig{
+		Config: eh.Config{
+			Schema:       testSchema,
+			EncodeConfig: bp.EncodeConfigDefault,
+		},
+		FsyncType: eh.FsyncFast,
+		Sink:      sink,
+	}
+}
+
+func TestMemSinkPool(t *testing.T) {
+	var sink *eh.MemSink = eh.MemSinkNew()
+	pool, e := memConfig(sink).ToPool(1)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	// each write evicts the other partition(reopened by the next write)
+	for i, row := range testRows(6) {
+		var name string = []string{"a.avro", "b.avro"}[i%2]
+		e = pool.WriteMap(row, name, eh.PartitionHeader{})
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+	e = pool.Close()
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	var names []string = sink.Names()
+	if 2 != len(names) || "a.avro" != names[0] || "b.avro" != names[1] {
+		t.Fatalf("unexpected partitions: %v", names)
+	}
+	for _, name := range names {
+		data, _ := sink.Get(name)
+		rows, e := decodeRows(t, bytes.NewReader(data))
+		if nil != e || 3 != len(rows) {
+			t.Fatalf("%s: %v rows: %v", name, len(rows), e)
+		}
+	}
+}
+
+func TestMemSinkAbort(t *testing.T) {
+	var sink *eh.MemSink = eh.MemSinkNew()
+	e := memConfig(sink).WriteMap(map[string]any{"id": "invalid"}, "a.avro")
+	if nil == e {
+		t.Fatal("expected an error")
+	}
+	if 0 != len(sink.Names()) {
+		t.Fatalf("aborted partition committed: %v", sink.Names())
+	}
+}
+
+func TestCreateValue(t *testing.T) {
+	var values map[string][]byte = map[string][]byte{"old": []byte("v")}
+	var loads int
+	var load eh.LoadValue = func(key string) ([]byte, bool, error) {
+		loads++
+		data, found := values[key]
+		return data, found, nil
+	}
+
+	var cases = []struct {
+		policy   eh.ExistPolicy
+		key      string
+		expected string
+		err      error
+	}{
+		{eh.ExistOverwrite, "old", "", nil},
+		{eh.ExistAppend, "old", "v", nil},
+		{eh.ExistAppend, "new", "", nil},
+		{eh.ExistSkip, "old", "", eh.ErrSkipPartition},
+		{eh.ExistSkip, "new", "", nil},
+		{eh.ExistFail, "old", "", fs.ErrExist},
+		{eh.ExistFail, "new", "", nil},
+		{eh.ExistVersion, "new", "", eh.ErrUnsupportedPolicy},
+	}
+	for _, c := range cases {
+		data, e := c.policy.CreateValue(c.key, load, nil)
+		if !errors.Is(e, c.err) || c.expected != string(data) {
+			t.Errorf("%s %s: %q %v", c.policy, c.key, data, e)
+		}
+	}
+
+	// the overwrite reads nothing
+	loads = 0
+	_, e := eh.ExistOverwrite.CreateValue("old", load, nil)
+	if nil != e || 0 != loads {
+		t.Fatalf("loads=%v: %v", loads, e)
+	}
+}
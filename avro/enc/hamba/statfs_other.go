@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || freebsd)
+
+package enc
+
+func freeBytes(_ string) (int64, error) {
+	return 0, ErrStatfsUnsupported
+}
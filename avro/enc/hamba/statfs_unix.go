@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package enc
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// freeBytes returns the space available to the unprivileged users.
+func freeBytes(dirname string) (int64, error) {
+	var st unix.Statfs_t
+	e := unix.Statfs(dirname, &st)
+	if nil != e {
+		return 0, e
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
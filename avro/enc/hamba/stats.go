@@ -0,0 +1,155 @@
+package enc
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+)
+
+// PartitionStat describes a written(or skipped) partition file.
+type PartitionStat struct {
+	Filename string   `json:"filename"`
+	Key      string   `json:"key,omitempty"`
+	Codec    bp.Codec `json:"codec"`
+	Records  int      `json:"records"`
+	Bytes    int64    `json:"bytes"`
+	Skipped  bool     `json:"skipped"`
+
+	// The sequence number of the segment(0: not rolled).
+	Segment int `json:"segment,omitempty"`
+
+	// The digest of the whole partition if computed.
+	Digest *Digest `json:"digest,omitempty"`
+
+	// The partition was removed(e.g, a stale segment); the sidecars of it
+	// should be removed too.
+	Removed bool `json:"removed,omitempty"`
+}
+
+func EncoderToStat(enc RecordEncoder, filename string) PartitionStat {
+	return PartitionStat{
+		Filename: filename,
+		Codec:    enc.Codec(),
+		Records:  enc.Records(),
+		Bytes:    enc.Bytes(),
+	}
+}
+
+// PartitionToStat finishes the encoded partition and creates its stat.
+//
+// The digest must be got before the commit.
+func PartitionToStat(
+	enc RecordEncoder,
+	w PartitionWriter,
+	key string,
+) (PartitionStat, error) {
+	var stat PartitionStat = EncoderToStat(enc, w.Name())
+	stat.Key = key
+
+	e := FinishPartition(w)
+	if nil != e {
+		return stat, e
+	}
+
+	dg, found, e := PartitionDigest(w)
+	if found {
+		stat.Digest = &dg
+	}
+	return stat, e
+}
+
+// StatObservers notifies the observers in order.
+func StatObservers(obs ...StatObserver) StatObserver {
+	return func(s PartitionStat) error {
+		for _, o := range obs {
+			e := o.Observe(s)
+			if nil != e {
+				return e
+			}
+		}
+		return nil
+	}
+}
+
+func (o *OcfEncoder) ToStat(filename string) PartitionStat {
+	return EncoderToStat(o, filename)
+}
+
+// StatObserver receives the stat of each partition file.
+type StatObserver func(PartitionStat) error
+
+func (o StatObserver) Observe(s PartitionStat) error {
+	if nil == o {
+		return nil
+	}
+	return o(s)
+}
+
+type CodecStat struct {
+	Files   int   `json:"files"`
+	Records int   `json:"records"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// Stats summarizes the partition files by codec.
+//
+// A file is counted once even if observed many times(e.g, reopened by the
+// EncoderPool or appended by each record).
+//
+// Stats is safe for concurrent use.
+type Stats struct {
+	mu sync.Mutex
+
+	ByCodec map[bp.Codec]CodecStat `json:"by_codec"`
+	Skipped int                    `json:"skipped"`
+	Removed int                    `json:"removed"`
+
+	files map[string]struct{}
+}
+
+func StatsNew() *Stats {
+	return &Stats{
+		ByCodec: map[bp.Codec]CodecStat{},
+		files:   map[string]struct{}{},
+	}
+}
+
+func (s *Stats) Observe(p PartitionStat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.Removed {
+		s.Removed++
+		return nil
+	}
+
+	_, found := s.files[p.Filename]
+	s.files[p.Filename] = struct{}{}
+
+	if p.Skipped {
+		if !found {
+			s.Skipped++
+		}
+		return nil
+	}
+
+	var c CodecStat = s.ByCodec[p.Codec]
+	if !found {
+		c.Files++
+	}
+	c.Records += p.Records
+	c.Bytes += p.Bytes
+	s.ByCodec[p.Codec] = c
+	return nil
+}
+
+func (s *Stats) ToObserver() StatObserver { return s.Observe }
+
+// WriteJson writes the summary as a json object.
+func (s *Stats) WriteJson(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || freebsd || netbsd)
+
+package enc
+
+func setxattr(_ string, _ string, _ []byte) error {
+	return ErrXattrUnsupported
+}
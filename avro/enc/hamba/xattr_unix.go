@@ -0,0 +1,11 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package enc
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+func setxattr(filename string, name string, value []byte) error {
+	return unix.Setxattr(filename, name, value, 0)
+}
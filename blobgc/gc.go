@@ -0,0 +1,223 @@
+// Package blobgc removes the blobs of the content-addressed store which no
+// partition references.
+package blobgc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+
+	bs "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/blobstore"
+	ec "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/encryption"
+	mf "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/manifest"
+	ss "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/schemastore"
+
+	dh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/dec/hamba"
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+var (
+	ErrUnknownPartition error = errors.New("unknown partition format")
+	ErrNoPartitions     error = errors.New("no partitions found")
+)
+
+// Partition is a partition file and its format.
+type Partition struct {
+	Filename string
+	eh.OutputFormat
+}
+
+type Config struct {
+	// The output root of the partitions.
+	Root string
+
+	Store bs.Store
+
+	// The fields which may have the digests.
+	Fields []string
+
+	// Decrypts the encrypted partitions.
+	Keyring ec.Keyring
+
+	// Has the schemas of the headerless partitions.
+	Schemas ss.Store
+
+	// The manifest filename(empty: root/_manifest.jsonl).
+	Manifest string
+
+	// Sweeps all the blobs even if no partition was found.
+	AllowEmpty bool
+
+	bp.DecodeConfig
+}
+
+func (c Config) manifest() string {
+	if "" == c.Manifest {
+		return filepath.Join(c.Root, mf.FilenameDefault)
+	}
+	return c.Manifest
+}
+
+// ToPartition finds the format of the file using its extension.
+//
+// Sidecar blobs(e.g, key.data.0123456789abcdef.bin) are not partitions.
+func ToPartition(filename string) (Partition, bool) {
+	f, found := eh.FilenameToOutputFormat(filename)
+	if !found {
+		return Partition{}, false
+	}
+	return Partition{Filename: filename, OutputFormat: f}, true
+}
+
+// Partitions lists the partitions in the root and in the manifest.
+//
+// The store directories are skipped; the paths of the manifest which do
+// not exist are ignored.
+func (c Config) Partitions() ([]Partition, error) {
+	var found map[string]Partition = map[string]Partition{}
+	e := filepath.WalkDir(c.Root, func(p string, d fs.DirEntry, e error) error {
+		if nil != e {
+			return e
+		}
+		if d.IsDir() && (p == c.Store.Root || p == c.Schemas.Root) {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || p == c.manifest() {
+			return nil
+		}
+		part, isPartition := ToPartition(p)
+		if isPartition {
+			found[p] = part
+		}
+		return nil
+	})
+	if nil != e {
+		return nil, e
+	}
+
+	entries, e := mf.LoadFile(c.manifest())
+	if nil != e {
+		return nil, e
+	}
+	for path := range entries {
+		var filename string = filepath.Join(c.Root, filepath.FromSlash(path))
+		_, listed := found[filename]
+		if listed {
+			continue
+		}
+		_, e := os.Stat(filename)
+		if errors.Is(e, fs.ErrNotExist) {
+			continue
+		}
+		if nil != e {
+			return nil, e
+		}
+		part, isPartition := ToPartition(filename)
+		if !isPartition {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownPartition, path)
+		}
+		found[filename] = part
+	}
+
+	return slices.SortedFunc(
+		maps.Values(found),
+		func(a, b Partition) int {
+			return strings.Compare(a.Filename, b.Filename)
+		},
+	), nil
+}
+
+// decoder returns the decoder of the format of the partition.
+func (c Config) decoder(p Partition) dh.MapsDecoder {
+	return func(r io.Reader) iter.Seq2[map[string]any, error] {
+		switch p.OutputFormat {
+		case eh.OutputSingleObject:
+			return dh.SingleObjectsToMaps(
+				r,
+				c.Schemas.ToResolver(),
+				c.DecodeConfig,
+			)
+		case eh.OutputRaw:
+			return dh.PartitionRawToMaps(
+				r,
+				c.Schemas,
+				p.Filename,
+				c.DecodeConfig,
+			)
+		case eh.OutputJson:
+			return dh.PartitionJsonToMaps(r, c.Schemas, p.Filename)
+		default:
+			return dh.ReaderToMaps(r, c.DecodeConfig)
+		}
+	}
+}
+
+// AddDigests adds the digests referenced by the partition.
+func (c Config) AddDigests(p Partition, referenced map[string]struct{}) error {
+	f, e := os.Open(p.Filename)
+	if nil != e {
+		return e
+	}
+	defer f.Close()
+
+	var rows iter.Seq2[map[string]any, error] = dh.DecryptWith(
+		f,
+		c.Keyring,
+		c.decoder(p),
+	)
+	for row, e := range rows {
+		if nil != e {
+			return fmt.Errorf("%s: %w", p.Filename, e)
+		}
+		for _, field := range c.Fields {
+			digest, isDigest := row[field].(string)
+			if isDigest {
+				referenced[digest] = struct{}{}
+			}
+		}
+	}
+	return nil
+}
+
+// Referenced marks the blobs referenced by the partitions.
+//
+// Any unreadable partition is an error.
+func (c Config) Referenced(parts []Partition) (map[string]struct{}, error) {
+	var ret map[string]struct{} = map[string]struct{}{}
+	for _, part := range parts {
+		e := c.AddDigests(part, ret)
+		if nil != e {
+			return nil, e
+		}
+	}
+	return ret, nil
+}
+
+// Run removes the blobs not referenced.
+//
+// No blob will be removed if any partition is unreadable or if no partition
+// was found(unless AllowEmpty).
+func (c Config) Run() (removed int, e error) {
+	parts, e := c.Partitions()
+	if nil != e {
+		return 0, e
+	}
+	if 0 == len(parts) && !c.AllowEmpty {
+		return 0, fmt.Errorf("%w: %s", ErrNoPartitions, c.Root)
+	}
+
+	referenced, e := c.Referenced(parts)
+	if nil != e {
+		return 0, e
+	}
+	return c.Store.Sweep(referenced)
+}
@@ -0,0 +1,204 @@
+package blobgc_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+
+	gc "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/blobgc"
+	bs "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/blobstore"
+	ss "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/schemastore"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+const testSchema string = `{
+	"type": "record",
+	"name": "Row",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "data", "type": "bytes"}
+	]
+}`
+
+type tree struct {
+	root  string
+	store bs.Store
+	cas   eh.CasConfig
+}
+
+func newTree(t *testing.T) tree {
+	t.Helper()
+	var root string = t.TempDir()
+	var store bs.Store = bs.Store{
+		Root:   filepath.Join(root, bs.DirnameDefault),
+		FanOut: bs.FanOutDefault,
+	}
+	return tree{
+		root:  root,
+		store: store,
+		cas: eh.CasConfig{
+			Fields:    []string{"data"},
+			Threshold: 4,
+			Store:     store,
+		},
+	}
+}
+
+func (tr tree) config() gc.Config {
+	return gc.Config{
+		Root:         tr.root,
+		Store:        tr.store,
+		Fields:       tr.cas.Fields,
+		Schemas:      ss.Store{Root: filepath.Join(tr.root, ss.DirnameDefault)},
+		DecodeConfig: bp.DecodeConfigDefault,
+	}
+}
+
+// write saves the blob(referenced by the partition) and returns its digest.
+func (tr tree) write(
+	t *testing.T,
+	f eh.OutputFormat,
+	codec bp.Codec,
+	blob []byte,
+) string {
+	t.Helper()
+	var ecfg bp.EncodeConfig = bp.EncodeConfigDefault
+	ecfg.Codec = codec
+	cfg, e := eh.Config{
+		Schema:       testSchema,
+		EncodeConfig: ecfg,
+		OutputFormat: f,
+	}.WithCas(tr.cas)
+	if nil != e {
+		t.Fatal(e)
+	}
+	row, e := tr.cas.Dedup(map[string]any{"id": int64(1), "data": blob})
+	if nil != e {
+		t.Fatal(e)
+	}
+	fc, e := eh.FsConfig{
+		Config:      cfg,
+		FsyncType:   eh.FsyncFast,
+		Dirname:     eh.Dirname(tr.root),
+		ExistPolicy: eh.ExistAppend,
+	}.WithSchemaStore(tr.config().Schemas)
+	if nil != e {
+		t.Fatal(e)
+	}
+	var filename string = filepath.Join(tr.root, "k."+string(f.Ext()))
+	e = fc.WriteMap(row, filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	return bs.Digest(blob)
+}
+
+func (tr tree) exists(t *testing.T, digest string) bool {
+	t.Helper()
+	name, e := tr.store.ObjectPath(digest)
+	if nil != e {
+		t.Fatal(e)
+	}
+	_, e = os.Stat(name)
+	return nil == e
+}
+
+func TestRunAllFormats(t *testing.T) {
+	var cases = []struct {
+		name   string
+		format eh.OutputFormat
+		codec  bp.Codec
+	}{
+		{"ocf", eh.OutputOcf, bp.CodecNull},
+		{"ocf-bzip2", eh.OutputOcf, bp.CodecBzip2},
+		{"ocf-xz", eh.OutputOcf, bp.CodecXz},
+		{"single-object", eh.OutputSingleObject, bp.CodecNull},
+		{"raw", eh.OutputRaw, bp.CodecNull},
+		{"json", eh.OutputJson, bp.CodecNull},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var tr tree = newTree(t)
+			live := tr.write(t, c.format, c.codec, []byte("live blob"))
+			dead, e := tr.store.Put([]byte("dead blob"))
+			if nil != e {
+				t.Fatal(e)
+			}
+
+			removed, e := tr.config().Run()
+			if nil != e {
+				t.Fatal(e)
+			}
+			if 1 != removed || !tr.exists(t, live) || tr.exists(t, dead) {
+				t.Fatalf("removed=%v live=%v dead=%v",
+					removed, tr.exists(t, live), tr.exists(t, dead))
+			}
+		})
+	}
+}
+
+func TestRunUnreadablePartition(t *testing.T) {
+	var tr tree = newTree(t)
+	live := tr.write(t, eh.OutputOcf, bp.CodecNull, []byte("live blob"))
+	dead, e := tr.store.Put([]byte("dead blob"))
+	if nil != e {
+		t.Fatal(e)
+	}
+	e = os.WriteFile(filepath.Join(tr.root, "bad.json"), []byte("{"), 0o644)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	removed, e := tr.config().Run()
+	if nil == e {
+		t.Fatal("expected an error")
+	}
+	if 0 != removed || !tr.exists(t, live) || !tr.exists(t, dead) {
+		t.Fatalf("blobs removed: %v", removed)
+	}
+}
+
+func TestRunNoPartitions(t *testing.T) {
+	var tr tree = newTree(t)
+	dead, e := tr.store.Put([]byte("dead blob"))
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	_, e = tr.config().Run()
+	if !errors.Is(e, gc.ErrNoPartitions) || !tr.exists(t, dead) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+
+	var cfg gc.Config = tr.config()
+	cfg.AllowEmpty = true
+	removed, e := cfg.Run()
+	if nil != e || 1 != removed {
+		t.Fatalf("removed=%v: %v", removed, e)
+	}
+}
+
+func TestToPartition(t *testing.T) {
+	var cases = map[string]bool{
+		"k.avro":                         true,
+		"k.soe":                          true,
+		"k.bin":                          true,
+		"k.json":                         true,
+		"k.data.0123456789abcdef.bin":    false,
+		"k.jsonl":                        false,
+		"k.avro.sig":                     false,
+		"_manifest.jsonl":                false,
+		"k.0001.avro":                    true,
+		"k.data.0123456789abcdef.bin.gz": false,
+	}
+	for name, expected := range cases {
+		_, isPartition := gc.ToPartition(name)
+		if expected != isPartition {
+			t.Errorf("%s: %v", name, isPartition)
+		}
+	}
+}
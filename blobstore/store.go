@@ -0,0 +1,169 @@
+// Package blobstore stores blobs by their sha256 digest.
+package blobstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var ErrInvalidDigest error = errors.New("invalid digest")
+
+const FanOutDefault int = 2
+
+const DirnameDefault string = "_blobs"
+
+// Store keeps each blob once as a file named by its sha256 digest.
+//
+// e.g, root/ab/cd/abcd0123...
+type Store struct {
+	Root string
+
+	// The number of the directory levels(2 hex chars each).
+	FanOut int
+
+	Sync func(*os.File) error
+
+	// Writes the new blob to the path elsewhere(nil: local files), e.g, to
+	// a bucket; the blob may already exist.
+	Write func(path string, blob []byte) error
+
+	// Converts the new blob before written(nil: written as is), e.g, to
+	// encrypt it; the digest is the digest of the original blob.
+	Seal func(blob []byte) ([]byte, error)
+
+	// Checks the new blob before written(nil: no check), e.g, the limits of
+	// a run.
+	Reserve func(path string, size int64) error
+}
+
+func (s Store) seal(blob []byte) ([]byte, error) {
+	if nil == s.Seal {
+		return blob, nil
+	}
+	return s.Seal(blob)
+}
+
+func (s Store) sync(f *os.File) error {
+	if nil == s.Sync {
+		return nil
+	}
+	return s.Sync(f)
+}
+
+// Digest returns the sha256 digest(hex) of the blob.
+func Digest(blob []byte) string {
+	var d [32]byte = sha256.Sum256(blob)
+	return hex.EncodeToString(d[:])
+}
+
+func ValidDigest(digest string) bool {
+	if sha256.Size*2 != len(digest) {
+		return false
+	}
+	_, e := hex.DecodeString(digest)
+	return nil == e
+}
+
+// ObjectPath returns the path of the blob.
+func (s Store) ObjectPath(digest string) (string, error) {
+	if !ValidDigest(digest) {
+		return "", ErrInvalidDigest
+	}
+	var parts []string = []string{s.Root}
+	for i := range s.FanOut {
+		parts = append(parts, digest[2*i:2*i+2])
+	}
+	parts = append(parts, digest)
+	return filepath.Join(parts...), nil
+}
+
+// Put stores the blob unless it is already stored.
+func (s Store) Put(blob []byte) (digest string, e error) {
+	digest = Digest(blob)
+	name, e := s.ObjectPath(digest)
+	if nil != e {
+		return "", e
+	}
+
+	_, e = os.Stat(name)
+	if nil == e {
+		return digest, nil
+	}
+	sealed, e := s.seal(blob)
+	if nil == e && nil != s.Reserve {
+		e = s.Reserve(name, int64(len(sealed)))
+	}
+	if nil != e {
+		return "", e
+	}
+	if nil != s.Write {
+		return digest, s.Write(name, sealed)
+	}
+
+	var dir string = filepath.Dir(name)
+	e = os.MkdirAll(dir, 0o755)
+	if nil != e {
+		return "", e
+	}
+
+	// writes a temporary file to avoid partially written objects
+	tmp, e := os.CreateTemp(dir, digest+".tmp.*")
+	if nil != e {
+		return "", e
+	}
+	_, e = tmp.Write(sealed)
+	e = errors.Join(e, s.sync(tmp), tmp.Close())
+	if nil == e {
+		e = os.Rename(tmp.Name(), name)
+	}
+	if nil != e {
+		return "", errors.Join(e, os.Remove(tmp.Name()))
+	}
+	return digest, nil
+}
+
+// Open opens the stored(possibly sealed) blob.
+func (s Store) Open(digest string) (io.ReadCloser, error) {
+	name, e := s.ObjectPath(digest)
+	if nil != e {
+		return nil, e
+	}
+	return os.Open(name)
+}
+
+// Walk calls the function for each stored blob.
+func (s Store) Walk(f func(digest string, path string) error) error {
+	return filepath.WalkDir(
+		s.Root,
+		func(path string, d fs.DirEntry, e error) error {
+			if errors.Is(e, fs.ErrNotExist) && path == s.Root {
+				return nil
+			}
+			if nil != e {
+				return e
+			}
+			if d.IsDir() || !ValidDigest(d.Name()) {
+				return nil
+			}
+			return f(d.Name(), path)
+		},
+	)
+}
+
+// Sweep removes the blobs not referenced.
+func (s Store) Sweep(referenced map[string]struct{}) (removed int, e error) {
+	e = s.Walk(func(digest string, path string) error {
+		_, found := referenced[digest]
+		if found {
+			return nil
+		}
+		removed++
+		return os.Remove(path)
+	})
+	return removed, e
+}
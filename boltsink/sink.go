@@ -0,0 +1,190 @@
+// Package boltsink stores each partition as a value of a bbolt database.
+package boltsink
+
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+var ErrUnsupportedPolicy error = eh.ErrUnsupportedPolicy
+
+const (
+	BucketDefault    string = "partitions"
+	BatchSizeDefault int    = 1000
+)
+
+// The key of the schema fingerprint in the metadata bucket.
+const MetaKeySchemaFp string = "schema_fp"
+
+// MetaBucketName returns the name of the bucket which keeps the metadata of
+// the partitions bucket.
+func MetaBucketName(bucket string) string { return bucket + ".meta" }
+
+type Config struct {
+	// The name of the database file.
+	Filename string
+
+	Bucket string
+
+	eh.ExistPolicy
+
+	// The fingerprint of the schema of the partitions(empty: not saved).
+	SchemaFp string
+
+	// The number of the values written in a transaction.
+	BatchSize int
+}
+
+// Sink puts the partitions keyed by the encoded primary keys.
+//
+// The values are written in transactions of BatchSize values; Close commits
+// the last transaction. Sink is safe for concurrent use.
+type Sink struct {
+	Config
+
+	mu      sync.Mutex
+	db      *bolt.DB
+	tx      *bolt.Tx
+	pending int
+}
+
+func (c Config) Open() (*Sink, error) {
+	if "" == c.Bucket {
+		c.Bucket = BucketDefault
+	}
+	e := c.ExistPolicy.CheckValue()
+	if nil != e {
+		return nil, e
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = BatchSizeDefault
+	}
+
+	db, e := bolt.Open(c.Filename, eh.FileModeDefault, nil)
+	if nil != e {
+		return nil, e
+	}
+
+	e = db.Update(func(tx *bolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists([]byte(c.Bucket))
+		if nil != e || "" == c.SchemaFp {
+			return e
+		}
+		meta, e := tx.CreateBucketIfNotExists([]byte(MetaBucketName(c.Bucket)))
+		if nil != e {
+			return e
+		}
+		return meta.Put([]byte(MetaKeySchemaFp), []byte(c.SchemaFp))
+	})
+	if nil != e {
+		return nil, errors.Join(e, db.Close())
+	}
+	return &Sink{Config: c, db: db}, nil
+}
+
+// bucket starts a writable transaction unless started.
+func (s *Sink) bucket() (*bolt.Bucket, error) {
+	if nil == s.tx {
+		tx, e := s.db.Begin(true)
+		if nil != e {
+			return nil, e
+		}
+		s.tx = tx
+	}
+	return s.tx.Bucket([]byte(s.Bucket)), nil
+}
+
+func (s *Sink) commit() error {
+	if nil == s.tx {
+		return nil
+	}
+	var tx *bolt.Tx = s.tx
+	s.tx = nil
+	s.pending = 0
+	return tx.Commit()
+}
+
+// Get returns a copy of the partition.
+func (s *Sink) Get(key string) (data []byte, found bool, e error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, e := s.bucket()
+	if nil != e {
+		return nil, false, e
+	}
+
+	// the value is valid only in the transaction
+	var val []byte = b.Get([]byte(key))
+	return slices.Clone(val), nil != val, nil
+}
+
+// Put sets the partition.
+func (s *Sink) Put(key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, e := s.bucket()
+	if nil != e {
+		return e
+	}
+
+	e = b.Put([]byte(key), data)
+	if nil != e {
+		return e
+	}
+
+	s.pending++
+	if s.pending < s.BatchSize {
+		return nil
+	}
+	return s.commit()
+}
+
+// Close commits the pending values and closes the database.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.commit(), s.db.Close())
+}
+
+// valuePartition is a partition which will be put on commit.
+type valuePartition struct {
+	eh.MemFile
+	sink *Sink
+	key  string
+}
+
+func (v *valuePartition) Name() string  { return v.key }
+func (v *valuePartition) Commit() error { return v.sink.Put(v.key, v.Data) }
+func (v *valuePartition) Abort() error  { return nil }
+
+func (s *Sink) Create(key string) (eh.PartitionWriter, error) {
+	data, e := s.ExistPolicy.CreateValue(key, s.Get, nil)
+	if nil != e {
+		return nil, e
+	}
+	return &valuePartition{
+		MemFile: eh.MemFile{Data: data},
+		sink:    s,
+		key:     key,
+	}, nil
+}
+
+// Reopen reads the value to append to it.
+func (s *Sink) Reopen(key string) (eh.PartitionWriter, error) {
+	data, _, e := s.Get(key)
+	if nil != e {
+		return nil, e
+	}
+	return &valuePartition{
+		MemFile: eh.MemFile{Data: data},
+		sink:    s,
+		key:     key,
+	}, nil
+}
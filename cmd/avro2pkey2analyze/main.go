@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+	. "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/util"
+
+	an "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/analyze"
+	pk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/pkey"
+
+	dh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/dec/hamba"
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+var EnvValByKey func(string) IO[string] = Lift(
+	func(key string) (string, error) {
+		val, found := os.LookupEnv(key)
+		switch found {
+		case true:
+			return val, nil
+		default:
+			return "", fmt.Errorf("env var %s missing", key)
+		}
+	},
+)
+
+var decodeConfig IO[bp.DecodeConfig] = Bind(
+	EnvValByKey("ENV_BLOB_SIZE_MAX").Or(Of(strconv.Itoa(bp.BlobSizeMaxDefault))),
+	Lift(func(s string) (bp.DecodeConfig, error) {
+		i, e := strconv.Atoi(s)
+		return bp.DecodeConfig{BlobSizeMax: i}, e
+	}),
+)
+
+// The schema to compute the record sizes(empty: the schema of the input).
+var schema IO[string] = Bind(
+	EnvValByKey("ENV_SCHEMA_FILENAME").Or(Of("")),
+	Lift(func(filename string) (string, error) {
+		if "" == filename {
+			return "", nil
+		}
+		data, e := os.ReadFile(filename)
+		return string(data), e
+	}),
+)
+
+// The layout of the partitions(ENV_SAVE_DIRNAME_ROOT and the extension of
+// ENV_OUTPUT_FORMAT).
+var keyToFilename IO[eh.KeyToFilename] = Bind(
+	All(
+		EnvValByKey("ENV_SAVE_DIRNAME_ROOT").Or(Of(".")),
+		EnvValByKey("ENV_OUTPUT_FORMAT").Or(Of("ocf")),
+	),
+	Lift(func(s []string) (eh.KeyToFilename, error) {
+		of, e := eh.StringToOutputFormat(s[1])
+		return eh.Dirname(s[0]).ToBasenameToPathExt(of.Ext()).ToKeyToFilename(), e
+	}),
+)
+
+var config IO[an.Config] = Bind(
+	All(
+		EnvValByKey("ENV_PKEY_NAME"),
+		EnvValByKey("ENV_ANALYZE_EXACT").Or(Of("false")),
+		EnvValByKey("ENV_ANALYZE_PRECISION").Or(Of("0")),
+		EnvValByKey("ENV_TOP_N").Or(Of("0")),
+		EnvValByKey("ENV_BLOB_FIELDS").Or(Of("")),
+	),
+	func(s []string) IO[an.Config] {
+		return Bind(
+			schema,
+			func(sch string) IO[an.Config] {
+				return Bind(
+					keyToFilename,
+					Lift(func(k2f eh.KeyToFilename) (an.Config, error) {
+						exact, ee := strconv.ParseBool(s[1])
+						precision, ep := strconv.Atoi(s[2])
+						topN, et := strconv.Atoi(s[3])
+						return an.Config{
+							KeyField:  s[0],
+							Exact:     exact,
+							Precision: precision,
+							TopN:      topN,
+							Schema:    sch,
+							BlobFields: strings.FieldsFunc(s[4], func(r rune) bool {
+								return ',' == r
+							}),
+							KeyToFilename: k2f,
+						}, errors.Join(ee, ep, et)
+					}),
+				)
+			},
+		)
+	},
+)
+
+var pkWriter pk.PrimaryKeyWriter = &pk.StringKeyWriterDefault
+
+// Analyzes the records of the stdin and prints the report as json.
+var analyze IO[Void] = Bind(
+	config,
+	func(c an.Config) IO[Void] {
+		return Bind(
+			decodeConfig,
+			func(dc bp.DecodeConfig) IO[Void] {
+				return func(ctx context.Context) (Void, error) {
+					a, e := c.New()
+					if nil != e {
+						return Empty, e
+					}
+					e = a.AddAll(
+						ctx,
+						dh.ReaderToMapsMeta(os.Stdin, dc, a.OnMetadata),
+						pkWriter,
+					)
+					if nil != e {
+						return Empty, e
+					}
+					var enc *json.Encoder = json.NewEncoder(os.Stdout)
+					enc.SetIndent("", "  ")
+					return Empty, enc.Encode(a.Report())
+				}
+			},
+		)
+	},
+)
+
+var sub IO[Void] = func(ctx context.Context) (Void, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	return analyze(ctx)
+}
+
+func main() {
+	_, e := sub(context.Background())
+	if nil != e {
+		log.Printf("%v\n", e)
+		os.Exit(1)
+	}
+}
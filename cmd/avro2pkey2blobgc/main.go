@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+	. "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/util"
+
+	gc "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/blobgc"
+	bs "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/blobstore"
+	ec "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/encryption"
+	ss "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/schemastore"
+)
+
+var EnvValByKey func(string) IO[string] = Lift(
+	func(key string) (string, error) {
+		val, found := os.LookupEnv(key)
+		switch found {
+		case true:
+			return val, nil
+		default:
+			return "", fmt.Errorf("env var %s missing", key)
+		}
+	},
+)
+
+var dirname IO[string] = EnvValByKey("ENV_SAVE_DIRNAME_ROOT")
+
+var casDirname IO[string] = EnvValByKey("ENV_CAS_DIRNAME").Or(Bind(
+	dirname,
+	Lift(func(d string) (string, error) {
+		return filepath.Join(d, bs.DirnameDefault), nil
+	}),
+))
+
+var casFields IO[[]string] = Bind(
+	EnvValByKey("ENV_CAS_FIELDS"),
+	Lift(func(s string) ([]string, error) {
+		return strings.Split(s, ","), nil
+	}),
+)
+
+var schemaDirname IO[string] = EnvValByKey("ENV_SCHEMA_STORE_DIRNAME").Or(
+	Bind(
+		dirname,
+		Lift(func(d string) (string, error) {
+			return filepath.Join(d, ss.DirnameDefault), nil
+		}),
+	),
+)
+
+// The manifest(default: root/_manifest.jsonl) lists the partitions, too.
+var manifestFilename IO[string] = EnvValByKey("ENV_MANIFEST_FILENAME").Or(
+	Of(""),
+)
+
+// Removes all the blobs even if no partition was found.
+var allowEmpty IO[bool] = Bind(
+	EnvValByKey("ENV_ALLOW_EMPTY").Or(Of("false")),
+	Lift(strconv.ParseBool),
+)
+
+// Decrypts the partitions using the keys of ENV_ENCRYPTION_KEY_FILENAME,
+// ENV_ENCRYPTION_KEY and ENV_DECRYPTION_KEY_FILENAMES.
+var keyring IO[ec.Keyring] = Bind(
+	All(
+		EnvValByKey("ENV_ENCRYPTION_KEY_FILENAME").Or(Of("")),
+		EnvValByKey("ENV_ENCRYPTION_KEY").Or(Of("")),
+		EnvValByKey("ENV_DECRYPTION_KEY_FILENAMES").Or(Of("")),
+	),
+	Lift(func(s []string) (ec.Keyring, error) {
+		var ring ec.Keyring = ec.Keyring{}
+		var filenames []string = append(
+			[]string{s[0]},
+			strings.Split(s[2], ",")...,
+		)
+		for _, filename := range filenames {
+			if "" == filename {
+				continue
+			}
+			key, e := ec.LoadKey(filename)
+			if nil != e {
+				return nil, e
+			}
+			ring[key.Id] = key
+		}
+		if "" != s[1] {
+			key, e := ec.ParseKey([]byte(s[1]))
+			if nil != e {
+				return nil, e
+			}
+			ring[key.Id] = key
+		}
+		return ring, nil
+	}),
+)
+
+var gcConfig IO[gc.Config] = Bind(
+	All(dirname, casDirname, schemaDirname, manifestFilename),
+	func(s []string) IO[gc.Config] {
+		return Bind(
+			keyring,
+			func(ring ec.Keyring) IO[gc.Config] {
+				return Bind(
+					casFields,
+					func(fields []string) IO[gc.Config] {
+						return Bind(
+							allowEmpty,
+							Lift(func(empty bool) (gc.Config, error) {
+								return gc.Config{
+									Root: s[0],
+									Store: bs.Store{
+										Root:   s[1],
+										FanOut: bs.FanOutDefault,
+									},
+									Fields:       fields,
+									Keyring:      ring,
+									Schemas:      ss.Store{Root: s[2]},
+									Manifest:     s[3],
+									AllowEmpty:   empty,
+									DecodeConfig: bp.DecodeConfigDefault,
+								}, nil
+							}),
+						)
+					},
+				)
+			},
+		)
+	},
+)
+
+// Any unreadable partition aborts the gc(no blob will be removed).
+var sweep IO[int] = Bind(
+	gcConfig,
+	Lift(func(cfg gc.Config) (int, error) { return cfg.Run() }),
+)
+
+var sub IO[Void] = func(ctx context.Context) (Void, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	removed, e := sweep(ctx)
+	log.Printf("removed blobs: %v\n", removed)
+	return Empty, e
+}
+
+func main() {
+	_, e := sub(context.Background())
+	if nil != e {
+		log.Printf("%v\n", e)
+	}
+}
@@ -1,19 +1,29 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"iter"
 	"log"
 	"os"
-	"strconv"
 	"strings"
+	"time"
+
+	ha "github.com/hamba/avro/v2"
 
-	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
 	. "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/util"
 
+	as "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/archivesink"
+	bs "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/blobstore"
+	bk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/boltsink"
+	mf "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/manifest"
 	pk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/pkey"
+	s3 "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/s3sink"
+	ss "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/schemastore"
+	sg "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/signature"
 
 	dh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/dec/hamba"
 	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
@@ -31,18 +41,6 @@ var EnvValByKey func(string) IO[string] = Lift(
 	},
 )
 
-var blobSizeMax IO[int] = Bind(
-	EnvValByKey("ENV_BLOB_SIZE_MAX"),
-	Lift(strconv.Atoi),
-).Or(Of(bp.BlobSizeMaxDefault))
-
-var decodeConfig IO[bp.DecodeConfig] = Bind(
-	blobSizeMax,
-	Lift(func(i int) (bp.DecodeConfig, error) {
-		return bp.DecodeConfig{BlobSizeMax: i}, nil
-	}),
-)
-
 var schemaFilename IO[string] = EnvValByKey("ENV_SCHEMA_FILENAME")
 
 func FilenameToStringLimited(limit int64) func(string) IO[string] {
@@ -73,121 +71,476 @@ var schemaContent IO[string] = Bind(
 	FilenameToStringLimited(SchemaFileSizeLimitDefault),
 )
 
-var stdin2avro2maps IO[iter.Seq2[map[string]any, error]] = Bind(
-	decodeConfig,
-	Lift(func(c bp.DecodeConfig) (iter.Seq2[map[string]any, error], error) {
-		return dh.StdinToMaps(c), nil
-	}),
-)
+var pkWriter pk.PrimaryKeyWriter = &pk.StringKeyWriterDefault
 
-var codec IO[bp.Codec] = Bind(
-	EnvValByKey("ENV_CODEC_NAME"),
-	Lift(func(codec string) (bp.Codec, error) {
-		return bp.CodecFromString(codec), nil
-	}),
-).Or(Of(bp.CodecNull))
-
-var encodeConfig IO[bp.EncodeConfig] = Bind(
-	codec,
-	Lift(func(c bp.Codec) (bp.EncodeConfig, error) {
-		return bp.EncodeConfig{
-			BlockLength: bp.BlockLengthDefault,
-			Codec:       c,
-		}, nil
-	}),
-)
+// runner writes the partitions using the options.
+//
+// The syncer, the header metadata of the input and the stats are shared by
+// all the partitions, the blobs and the workers.
+type runner struct {
+	options
 
-var ecfg IO[eh.Config] = Bind(
-	encodeConfig,
-	func(c bp.EncodeConfig) IO[eh.Config] {
-		return Bind(
-			schemaContent,
-			Lift(func(schema string) (eh.Config, error) {
-				return eh.Config{
-					Schema:       schema,
-					EncodeConfig: c,
-				}, nil
-			}),
-		)
-	},
-)
+	syncer *eh.Syncer
+	input  *eh.InputMetadata
+	stats  *eh.Stats
 
-var fsyncType IO[eh.FsyncType] = Bind(
-	EnvValByKey("ENV_FSYNC_TYPE").Or(Of("fsync")),
-	Lift(eh.StringToFsyncType),
-)
+	// Uploads the partitions, the blobs and the schemas(nil: no bucket).
+	remote *s3.Sink
+}
 
-var dirname IO[eh.Dirname] = Bind(
-	EnvValByKey("ENV_SAVE_DIRNAME_ROOT"),
-	Lift(func(dname string) (eh.Dirname, error) {
-		return eh.Dirname(dname), nil
-	}),
-)
+func (o options) newRunner() (*runner, error) {
+	var r *runner = &runner{
+		options: o,
+		syncer:  o.sync.ToSyncer(),
+		input:   &eh.InputMetadata{},
+		stats:   eh.StatsNew(),
+	}
+	if "" == o.s3.Bucket {
+		return r, nil
+	}
+	client, e := o.s3.ToClient()
+	if nil != e {
+		return nil, e
+	}
+	remote, e := client.ToSink(o.existPolicy)
+	r.remote = &remote
+	return r, e
+}
 
-var fscfg IO[eh.FsConfig] = Bind(
-	ecfg,
-	func(c eh.Config) IO[eh.FsConfig] {
-		return Bind(
-			fsyncType,
-			func(ft eh.FsyncType) IO[eh.FsConfig] {
-				return Bind(
-					dirname,
-					Lift(func(dn eh.Dirname) (eh.FsConfig, error) {
-						return eh.FsConfig{
-							Config:    c,
-							FsyncType: ft,
-							Dirname:   dn,
-						}, nil
-					}),
-				)
-			},
-		)
-	},
-)
+// Decrypts the input if encrypted(the keys are required).
+func (r *runner) records() iter.Seq2[map[string]any, error] {
+	var onMeta dh.MetadataHandler = r.input.Set
+	if 0 == len(r.keyring) {
+		return dh.StdinToMapsMeta(r.decode, onMeta)
+	}
+	return dh.DecryptToMapsMeta(os.Stdin, r.keyring, r.decode, onMeta)
+}
 
-var saver IO[pk.RecordSaver] = Bind(
-	fscfg,
-	Lift(func(fc eh.FsConfig) (pk.RecordSaver, error) {
-		return fc.SaverFromDirnameDefault(), nil
-	}),
-)
+// Encrypts the partitions and the blobs if the encryption key is set.
+func (r *runner) encryption() *eh.Encryption {
+	if nil == r.encryptionKey {
+		return nil
+	}
+	return &eh.Encryption{
+		Key:       *r.encryptionKey,
+		Keyring:   r.keyring,
+		ChunkSize: r.chunkSize,
+	}
+}
 
-var primaryKeyName IO[string] = EnvValByKey("ENV_PKEY_NAME")
+// The config without the blobs, the sink and the limits(also used by the
+// planner).
+func (r *runner) baseConfig() eh.FsConfig {
+	return eh.FsConfig{
+		Config:       r.config,
+		FsyncType:    r.syncer.FsyncType,
+		Syncer:       r.syncer,
+		Dirname:      r.dirname,
+		ExistPolicy:  r.existPolicy,
+		StatObserver: r.stats.ToObserver(),
+		Input:        r.input,
+	}
+}
 
-var map2pkey IO[pk.MapToPrimaryKey] = Bind(
-	primaryKeyName,
-	Lift(func(pkey string) (pk.MapToPrimaryKey, error) {
-		return pk.MapToKeyNew(pkey), nil
-	}),
-)
+// The blobs of the database sinks are written under ENV_SAVE_DIRNAME_ROOT
+// as if the partitions were local files; the blobs are encrypted like the
+// partitions.
+func (r *runner) blobConfig() (eh.BlobConfig, error) {
+	var bc eh.BlobConfig = r.blob
+	nullable, e := eh.NullableFields(r.config.Schema, bc.Fields)
+	bc.Nullable = nullable
+	bc.FsyncType = r.syncer.FsyncType
+	bc.Syncer = r.syncer
+	if r.database() {
+		bc.KeyDirname = r.dirname
+		bc.KeyExt = r.config.OutputFormat.Ext()
+	}
+	bc.Encryption = r.encryption()
+	return bc, e
+}
 
-var pkWriter pk.PrimaryKeyWriter = &pk.StringKeyWriterDefault
+// The large blobs are uploaded to the bucket if ENV_S3_BUCKET is set and
+// encrypted like the partitions.
+func (r *runner) casConfig() eh.CasConfig {
+	var cc eh.CasConfig = r.cas
+	cc.Store = bs.Store{
+		Root:   r.casDirname,
+		FanOut: bs.FanOutDefault,
+		Sync:   r.syncer.Sync,
+	}
+	if nil != r.remote {
+		cc.Store.Write = r.remote.WriteFileOnce
+	}
+	var enc *eh.Encryption = r.encryption()
+	if nil != enc {
+		cc.Store.Seal = enc.Seal
+	}
+	return cc
+}
 
-var stdin2avro2maps2partitioned IO[Void] = Bind(
-	stdin2avro2maps,
-	func(m iter.Seq2[map[string]any, error]) IO[Void] {
-		return Bind(
-			map2pkey,
-			func(mp pk.MapToPrimaryKey) IO[Void] {
-				return Bind(
-					saver,
-					func(rs pk.RecordSaver) IO[Void] {
-						return rs.SaveAll(
-							m,
-							mp,
-							pkWriter,
-						)
-					},
-				)
-			},
+func schemaFingerprint(c eh.Config) (string, error) {
+	parsed, e := ha.Parse(c.Schema)
+	if nil != e {
+		return "", e
+	}
+	fp, e := ss.FingerprintOf(parsed)
+	return fp.String(), e
+}
+
+// Puts the partitions into the bbolt database.
+func (r *runner) boltSink(c eh.Config) (eh.Sink, error) {
+	fp, e := schemaFingerprint(c)
+	if nil != e {
+		return nil, e
+	}
+	return bk.Config{
+		Filename:    r.sinks.bolt,
+		Bucket:      r.sinks.boltBucket,
+		ExistPolicy: r.existPolicy,
+		SchemaFp:    fp,
+		BatchSize:   r.sinks.boltBatchSize,
+	}.Open()
+}
+
+// Writes the archive to ENV_ARCHIVE_FILENAME(empty or "-": stdout).
+//
+// The entries are relative to ENV_SAVE_DIRNAME_ROOT and get the epoch as
+// the mtime if ENV_DETERMINISTIC is true.
+func (r *runner) archiveSink() (eh.Sink, error) {
+	f, e := as.StringToFormat(r.sinks.archiveFormat)
+	if nil != e {
+		return nil, e
+	}
+	var cfg as.Config = as.Config{Format: f, Root: string(r.dirname)}
+	if r.deterministic {
+		cfg.Clock = func() time.Time { return time.Unix(0, 0) }
+	}
+	return cfg.Create(r.sinks.archiveFilename)
+}
+
+// Uses the database if ENV_SQLITE_FILENAME or ENV_BOLT_FILENAME is set, the
+// bucket if ENV_S3_BUCKET is set, the archive if ENV_ARCHIVE_FORMAT is set,
+// local files otherwise(nil).
+func (r *runner) partitionSink(c eh.Config) (eh.Sink, error) {
+	switch {
+	case "" != r.sinks.sqlite:
+		return r.sqliteSink(c)
+	case "" != r.sinks.bolt:
+		return r.boltSink(c)
+	case nil != r.remote:
+		return *r.remote, nil
+	case r.archive():
+		return r.archiveSink()
+	default:
+		return nil, nil
+	}
+}
+
+// The config with the blobs, the sink, the encryption and the limits.
+func (r *runner) fsConfig(bc eh.BlobConfig) (eh.FsConfig, error) {
+	var fc eh.FsConfig = r.baseConfig()
+	withCas, e := fc.Config.WithCas(r.casConfig())
+	if nil != e {
+		return fc, e
+	}
+	fc.Config, e = withCas.WithBlobRefs(bc)
+	if nil != e {
+		return fc, e
+	}
+	fc.PartitionMetadata = r.meta
+	fc.Deterministic = r.deterministic
+	if eh.BlobSidecar == bc.BlobMode {
+		fc.Blobs = bc.ToExtractor()
+	}
+
+	fc.Sink, e = r.partitionSink(fc.Config)
+	if nil != e {
+		return fc, e
+	}
+	fc.Encryption = r.encryption()
+	if r.limits.Enabled() {
+		fc.Limiter = r.limits.ToLimiter(string(fc.Dirname))
+		if nil != fc.Blobs {
+			fc.Blobs.Limiter = fc.Limiter
+		}
+	}
+	return fc, nil
+}
+
+// Opens the manifest(nil if disabled).
+func (r *runner) openManifest(
+	fc eh.FsConfig,
+	enabled bool,
+) (*mf.Manifest, error) {
+	if !enabled {
+		return nil, nil
+	}
+	fp, e := schemaFingerprint(fc.Config)
+	if nil != e {
+		return nil, e
+	}
+	var clock func() time.Time = time.Now
+	if fc.Deterministic {
+		clock = nil
+	}
+	return mf.Config{
+		Root:     string(fc.Dirname),
+		SchemaFp: fp,
+		Clock:    clock,
+		Sync:     fc.ToFsync(),
+	}.Open(r.digest.manifestFilename)
+}
+
+// FsConfig which also notifies the manifest(nil: no manifest).
+type manifestedConfig struct {
+	eh.FsConfig
+	manifest *mf.Manifest
+
+	// Signs the manifest on close(nil: unsigned).
+	manifestSigner *sg.Signer
+}
+
+// Close closes the sink and the manifest, then signs the manifest.
+func (m manifestedConfig) Close() error {
+	e := errors.Join(eh.CloseSink(m.Sink), m.manifest.Close())
+	if nil != e || nil == m.manifestSigner {
+		return e
+	}
+	return m.manifestSigner.SignFile(m.manifest.Name())
+}
+
+// The signer of ENV_SIGN_KEY_FILENAME(nil: no signature).
+func (r *runner) signer() (*sg.Signer, error) {
+	if "" == r.digest.signKeyFilename {
+		return nil, nil
+	}
+	key, e := sg.LoadPrivateKey(r.digest.signKeyFilename)
+	return &sg.Signer{Key: key, Sync: r.syncer.Sync}, e
+}
+
+// Computes the digests if ENV_DIGEST_ALGORITHM or ENV_DIGEST_STORE is set.
+//
+// The manifest is enabled if ENV_MANIFEST is true, the digests are kept in
+// the manifest or the manifest will be signed.
+func (r *runner) manifested(fc eh.FsConfig) (manifestedConfig, error) {
+	var d digestOptions = r.digest
+	var mc manifestedConfig = manifestedConfig{FsConfig: fc}
+	var e error
+	fc.DigestAlgorithm = d.algorithm
+	if d.enabled {
+		fc, e = fc.WithDigestStore(d.store)
+		if nil != e {
+			return mc, errors.Join(e, mc.Close())
+		}
+	}
+	signer, e := r.signer()
+	if nil != e {
+		return mc, errors.Join(e, mc.Close())
+	}
+
+	var signManifest bool = nil != signer && sg.ModeManifest == d.signMode
+	var enabled bool = d.manifest ||
+		(d.enabled && eh.DigestStoreManifest == d.store) ||
+		signManifest
+	m, e := r.openManifest(fc, enabled)
+	if nil != e {
+		return mc, errors.Join(e, mc.Close())
+	}
+	return withManifest(fc, m, signer, d.signMode)
+}
+
+func withManifest(
+	fc eh.FsConfig,
+	m *mf.Manifest,
+	signer *sg.Signer,
+	mode sg.Mode,
+) (manifestedConfig, error) {
+	var mc manifestedConfig = manifestedConfig{manifest: m}
+	if nil != m {
+		if eh.DigestNone == fc.DigestAlgorithm {
+			fc.DigestAlgorithm = eh.DigestSha256
+		}
+		fc.StatObserver = eh.StatObservers(fc.StatObserver, m.ToObserver())
+	}
+	if eh.DigestNone != fc.DigestAlgorithm {
+		// shared by the workers(and the writes without the pool)
+		fc.Digests = eh.DigestStatesNew()
+	}
+
+	switch {
+	case nil == signer:
+	case sg.ModeManifest == mode:
+		mc.manifestSigner = signer
+	case nil != fc.Sink:
+		mc.FsConfig = fc
+		return mc, errors.Join(sg.ErrUnsupportedSink, mc.Close())
+	default:
+		fc.StatObserver = eh.StatObservers(
+			fc.StatObserver,
+			signer.ToObserver(),
 		)
-	},
-)
+	}
+	mc.FsConfig = fc
+	return mc, nil
+}
+
+// Database sinks use the encoded keys as the names.
+func (r *runner) keyToFilename(fc eh.FsConfig) eh.KeyToFilename {
+	if r.database() {
+		return eh.KeyAsFilename
+	}
+	return fc.ToKeyToFilename()
+}
+
+// Stores the schema once if the partitions do not have the schema; the
+// schemas are uploaded to the bucket if ENV_S3_BUCKET is set.
+//
+// The fingerprint of each local partition is kept next to it.
+func (r *runner) withSchemaStore(fc eh.FsConfig) (eh.FsConfig, error) {
+	if !fc.OutputFormat.Headerless() {
+		return fc, nil
+	}
+	var store ss.Store = ss.Store{
+		Root: r.schemaStoreDirname,
+		Sync: r.syncer.Sync,
+	}
+	if nil != r.remote {
+		store.Write = r.remote.WriteFile
+	}
+	return fc.WithSchemaStore(store)
+}
+
+// The number of the open partitions of the pool(0: no pool); the archive
+// can not reopen the partitions.
+func (r *runner) poolSize() (int, error) {
+	return eh.PoolSize(r.maxOpen, r.rolling, !r.archive())
+}
+
+// Keeps the partition files open if ENV_MAX_OPEN_FILES is positive.
+//
+// Saves the records using ENV_WORKERS workers if positive; the open files
+// are divided among the workers. The sink and the manifest are closed after
+// the workers.
+func (r *runner) recordsSaver() (pk.RecordsSaver, error) {
+	maxOpen, e := r.poolSize()
+	if nil != e {
+		return nil, e
+	}
+	bc, e := r.blobConfig()
+	if nil != e {
+		return nil, e
+	}
+	fc, e := r.fsConfig(bc)
+	if nil != e {
+		return nil, errors.Join(e, eh.CloseSink(fc.Sink))
+	}
+	fc.Rolling = r.rolling
+	mc, e := r.manifested(fc)
+	if nil != e {
+		return nil, e
+	}
+	fc, e = r.withSchemaStore(mc.FsConfig)
+	if nil != e {
+		return nil, errors.Join(e, mc.Close())
+	}
+
+	var sc eh.SaverConfig = eh.SaverConfig{
+		Fs:      fc,
+		MaxOpen: maxOpen,
+		Cas:     r.casConfig(),
+		Blob:    bc,
+	}
+	var k2f eh.KeyToFilename = r.keyToFilename(fc)
+	var c pk.Concurrency = r.concurrency
+	if c.Workers <= 0 {
+		saver, e := sc.ToRecordsSaver(k2f)
+		if nil != e {
+			return nil, errors.Join(e, mc.Close())
+		}
+		return saver.WithCloser(mc.Close), nil
+	}
+
+	if 0 < sc.MaxOpen {
+		sc.MaxOpen = max(1, sc.MaxOpen/c.Workers)
+	}
+	return c.ToRecordsSaver(func(_ int) IO[pk.RecordsSaver] {
+		return func(_ context.Context) (pk.RecordsSaver, error) {
+			return sc.ToRecordsSaver(k2f)
+		}
+	}).WithCloser(mc.Close), nil
+}
+
+// Prints the planned partitions as json lines instead of writing them.
+//
+// The partitions are grouped if the pool keeps them open like the
+// recordsSaver; the local files are checked if no other sink is set.
+func (r *runner) plannedSaver() (pk.RecordsSaver, error) {
+	maxOpen, e := r.poolSize()
+	if nil != e {
+		return nil, e
+	}
+	var fc eh.FsConfig = r.baseConfig()
+	p, e := eh.PlannerNew(fc.Config.Schema, fc.ExistPolicy)
+	if nil != e {
+		return nil, e
+	}
+	p.Rolling = r.rolling
+	p.Grouped = 0 < maxOpen
+	if r.local() {
+		p.Exists = eh.LocalExists
+	}
+	return p.ToSaver(r.keyToFilename(fc)).WithCloser(func() error {
+		var w *bufio.Writer = bufio.NewWriter(os.Stdout)
+		return errors.Join(p.WriteJsonl(w), w.Flush())
+	}), nil
+}
+
+// Writes nothing if ENV_DRY_RUN is true; the files written after the last
+// sync are synced at the end.
+func (r *runner) run(ctx context.Context) error {
+	var saver pk.RecordsSaver
+	var e error
+	switch r.dryRun {
+	case true:
+		saver, e = r.plannedSaver()
+	default:
+		saver, e = r.recordsSaver()
+	}
+	if nil != e {
+		return e
+	}
+
+	_, e = saver(r.records(), pk.MapToKeyNew(r.keyName), pkWriter)(ctx)
+	if nil != e {
+		return e
+	}
+	if !r.dryRun {
+		e = r.syncer.Finish(string(r.dirname))
+		if nil != e {
+			return e
+		}
+	}
+	if r.printStats {
+		return r.stats.WriteJson(os.Stderr)
+	}
+	return nil
+}
 
 var sub IO[Void] = func(ctx context.Context) (Void, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	return stdin2avro2maps2partitioned(ctx)
+	return Bind(
+		loadOptions,
+		func(o options) IO[Void] {
+			return func(ctx context.Context) (Void, error) {
+				r, e := o.newRunner()
+				if nil != e {
+					return Empty, e
+				}
+				return Empty, r.run(ctx)
+			}
+		},
+	)(ctx)
 }
 
 func main() {
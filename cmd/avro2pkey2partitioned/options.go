@@ -0,0 +1,541 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+	. "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/util"
+
+	as "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/archivesink"
+	bs "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/blobstore"
+	bk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/boltsink"
+	ec "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/encryption"
+	mf "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/manifest"
+	pk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/pkey"
+	s3 "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/s3sink"
+	ss "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/schemastore"
+	sg "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/signature"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+// options are the parsed environment variables(read once by loadOptions).
+type options struct {
+	keyName     string
+	dirname     eh.Dirname
+	existPolicy eh.ExistPolicy
+
+	decode bp.DecodeConfig
+	config eh.Config
+	sync   eh.SyncConfig
+
+	// The encryption key(nil: none) and the keys to decrypt the input.
+	encryptionKey *ec.Key
+	keyring       ec.Keyring
+	chunkSize     int
+
+	meta          eh.PartitionMetadata
+	deterministic bool
+
+	// The blob mode, the fields and the json sidecar.
+	blob eh.BlobConfig
+
+	// The fields and the threshold(the root of the store: casDirname).
+	cas        eh.CasConfig
+	casDirname string
+
+	sinks sinkOptions
+
+	// The config of the bucket(empty bucket: no bucket).
+	s3 s3.Config
+
+	limits eh.Limits
+	digest digestOptions
+
+	schemaStoreDirname string
+
+	maxOpen     int
+	rolling     eh.Rolling
+	concurrency pk.Concurrency
+
+	dryRun     bool
+	printStats bool
+}
+
+// sinkOptions are the options of the database and the archive sinks.
+type sinkOptions struct {
+	// The table and the batch size of the sqlite(zero: the defaults).
+	sqlite          string
+	sqliteTable     string
+	sqliteBatchSize int
+
+	bolt          string
+	boltBucket    string
+	boltBatchSize int
+
+	archiveFormat   string
+	archiveFilename string
+}
+
+// digestOptions are the options of the digests, the manifest and the
+// signatures.
+type digestOptions struct {
+	algorithm eh.DigestAlgorithm
+	store     eh.DigestStore
+
+	// True if ENV_DIGEST_ALGORITHM or ENV_DIGEST_STORE is set.
+	enabled bool
+
+	manifest         bool
+	manifestFilename string
+
+	// empty: no signature
+	signKeyFilename string
+	signMode        sg.Mode
+}
+
+// database is true if ENV_SQLITE_FILENAME or ENV_BOLT_FILENAME is set.
+func (o options) database() bool {
+	return "" != o.sinks.sqlite || "" != o.sinks.bolt
+}
+
+// archive is true if the partitions are written to an archive.
+func (o options) archive() bool {
+	return !o.database() && "" == o.s3.Bucket && "" != o.sinks.archiveFormat
+}
+
+// local is true if the partitions are written as local files.
+func (o options) local() bool {
+	return !o.database() && "" == o.s3.Bucket && "" == o.sinks.archiveFormat
+}
+
+// atoiOr parses the integer; the invalid values are ignored.
+func atoiOr(s string, alt int) int {
+	i, e := strconv.Atoi(s)
+	if nil != e {
+		return alt
+	}
+	return i
+}
+
+var commaSeparated func(string) []string = func(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return ',' == r })
+}
+
+var decodeConfig IO[bp.DecodeConfig] = Bind(
+	EnvValByKey("ENV_BLOB_SIZE_MAX").Or(Of("")),
+	Lift(func(s string) (bp.DecodeConfig, error) {
+		return bp.DecodeConfig{
+			BlobSizeMax: atoiOr(s, bp.BlobSizeMaxDefault),
+		}, nil
+	}),
+)
+
+var encodeConfig IO[bp.EncodeConfig] = Bind(
+	All(
+		EnvValByKey("ENV_CODEC_NAME").Or(Of("null")),
+		EnvValByKey("ENV_COMPRESSION_LEVEL").Or(Of("")),
+		EnvValByKey("ENV_CODEC_SELECTION").Or(Of("fixed")),
+		EnvValByKey("ENV_SMALL_CODEC_NAME").Or(Of("null")),
+		EnvValByKey("ENV_CODEC_THRESHOLD").Or(Of(
+			strconv.Itoa(bp.AdaptiveThresholdDefault),
+		)),
+	),
+	Lift(func(s []string) (bp.EncodeConfig, error) {
+		c, ecodec := bp.ParseCodec(s[0])
+		sel, esel := bp.StringToCodecSelection(s[2])
+		small, esmall := bp.ParseCodec(s[3])
+		threshold, ethreshold := strconv.Atoi(s[4])
+		return bp.EncodeConfig{
+			BlockLength:      bp.BlockLengthDefault,
+			Codec:            c,
+			CompressionLevel: atoiOr(s[1], bp.CompressionLevelDefault),
+			Adaptive: bp.AdaptiveConfig{
+				CodecSelection: sel,
+				SmallCodec:     small,
+				Threshold:      threshold,
+			},
+		}, errors.Join(ecodec, esel, esmall, ethreshold)
+	}),
+)
+
+var ecfg IO[eh.Config] = Bind(
+	All(
+		schemaContent,
+		EnvValByKey("ENV_OUTPUT_FORMAT").Or(Of("ocf")),
+	),
+	func(s []string) IO[eh.Config] {
+		return Bind(
+			encodeConfig,
+			Lift(func(c bp.EncodeConfig) (eh.Config, error) {
+				of, e := eh.StringToOutputFormat(s[1])
+				return eh.Config{
+					Schema:       s[0],
+					EncodeConfig: c,
+					OutputFormat: of,
+				}, e
+			}),
+		)
+	},
+)
+
+// Syncs every ENV_FSYNC_BATCH_FILES files or ENV_FSYNC_BATCH_MS
+// milliseconds if ENV_FSYNC_TYPE is batch.
+var syncConfig IO[eh.SyncConfig] = Bind(
+	All(
+		EnvValByKey("ENV_FSYNC_TYPE").Or(Of("fsync")),
+		EnvValByKey("ENV_FSYNC_BATCH_FILES").Or(Of("0")),
+		EnvValByKey("ENV_FSYNC_BATCH_MS").Or(Of("0")),
+	),
+	Lift(func(s []string) (eh.SyncConfig, error) {
+		ft, eft := eh.StringToFsyncType(s[0])
+		files, ef := strconv.Atoi(s[1])
+		ms, ems := strconv.Atoi(s[2])
+		return eh.SyncConfig{
+			FsyncType:     ft,
+			BatchFiles:    files,
+			BatchInterval: time.Duration(ms) * time.Millisecond,
+		}, errors.Join(eft, ef, ems)
+	}),
+)
+
+// The key of ENV_ENCRYPTION_KEY_FILENAME or ENV_ENCRYPTION_KEY(nil: none).
+//
+// The key is "id:secret" or "secret"(32 bytes, hex or base64).
+var encryptionKey IO[*ec.Key] = Bind(
+	All(
+		EnvValByKey("ENV_ENCRYPTION_KEY_FILENAME").Or(Of("")),
+		EnvValByKey("ENV_ENCRYPTION_KEY").Or(Of("")),
+	),
+	Lift(func(s []string) (*ec.Key, error) {
+		var key ec.Key
+		var e error
+		switch {
+		case "" != s[0]:
+			key, e = ec.LoadKey(s[0])
+		case "" != s[1]:
+			key, e = ec.ParseKey([]byte(s[1]))
+		default:
+			return nil, nil
+		}
+		return &key, e
+	}),
+)
+
+// The old keys of ENV_DECRYPTION_KEY_FILENAMES(without the encryption key).
+var keyring IO[ec.Keyring] = Bind(
+	EnvValByKey("ENV_DECRYPTION_KEY_FILENAMES").Or(Of("")),
+	Lift(func(s string) (ec.Keyring, error) {
+		return ec.LoadKeyring(commaSeparated(s))
+	}),
+)
+
+func runIdRandom() string {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:]) // never returns an error
+	return hex.EncodeToString(buf[:])
+}
+
+// Adds the provenance if ENV_META_PROVENANCE is true(no random run id and
+// no write time if reproducible).
+func partitionMetadata(reproducible bool) IO[eh.PartitionMetadata] {
+	return Bind(
+		All(
+			EnvValByKey("ENV_META_COPY_KEYS").Or(Of("")),
+			EnvValByKey("ENV_META_PROVENANCE").Or(Of("false")),
+			EnvValByKey("ENV_META_SOURCE").Or(Of("stdin")),
+			EnvValByKey("ENV_META_RUN_ID").Or(Of("")),
+			EnvValByKey("ENV_PKEY_NAME"),
+		),
+		Lift(func(s []string) (eh.PartitionMetadata, error) {
+			var keys []string = commaSeparated(s[0])
+			switch {
+			case "true" == s[1]:
+				var p eh.Provenance = eh.Provenance{
+					KeyName: s[4],
+					Source:  s[2],
+					RunId:   s[3],
+					Clock:   time.Now,
+				}
+				if reproducible {
+					p.Clock = nil
+				}
+				if "" == p.RunId && !reproducible {
+					p.RunId = runIdRandom()
+				}
+				return p.ToPartitionMetadata(keys), nil
+			case 0 < len(keys):
+				return eh.CopiedOnly(keys), nil
+			default:
+				return nil, nil
+			}
+		}),
+	)
+}
+
+var blobConfig IO[eh.BlobConfig] = Bind(
+	All(
+		EnvValByKey("ENV_BLOB_MODE").Or(Of("inline")),
+		EnvValByKey("ENV_JSON_SIDECAR").Or(Of("false")),
+		EnvValByKey("ENV_BLOB_FIELDS").Or(Of("")),
+	),
+	Lift(func(s []string) (eh.BlobConfig, error) {
+		mode, emode := eh.StringToBlobMode(s[0])
+		jsonSidecar, ejson := strconv.ParseBool(s[1])
+		return eh.BlobConfig{
+			BlobMode:    mode,
+			Fields:      commaSeparated(s[2]),
+			JsonSidecar: jsonSidecar,
+		}, errors.Join(emode, ejson)
+	}),
+)
+
+var casConfig IO[eh.CasConfig] = Bind(
+	All(
+		EnvValByKey("ENV_CAS_FIELDS").Or(Of("")),
+		EnvValByKey("ENV_CAS_THRESHOLD").Or(Of(
+			strconv.Itoa(eh.CasThresholdDefault),
+		)),
+	),
+	Lift(func(s []string) (eh.CasConfig, error) {
+		threshold, e := strconv.Atoi(s[1])
+		return eh.CasConfig{
+			Fields:    commaSeparated(s[0]),
+			Threshold: threshold,
+		}, e
+	}),
+)
+
+var sinkOpts IO[sinkOptions] = Bind(
+	All(
+		EnvValByKey("ENV_SQLITE_FILENAME").Or(Of("")),
+		EnvValByKey("ENV_SQLITE_TABLE").Or(Of("")),
+		EnvValByKey("ENV_SQLITE_BATCH_SIZE").Or(Of("0")),
+		EnvValByKey("ENV_BOLT_FILENAME").Or(Of("")),
+		EnvValByKey("ENV_BOLT_BUCKET").Or(Of(bk.BucketDefault)),
+		EnvValByKey("ENV_BOLT_BATCH_SIZE").Or(Of(
+			strconv.Itoa(bk.BatchSizeDefault),
+		)),
+		EnvValByKey("ENV_ARCHIVE_FORMAT").Or(Of("")),
+		EnvValByKey("ENV_ARCHIVE_FILENAME").Or(Of("-")),
+	),
+	Lift(func(s []string) (sinkOptions, error) {
+		sqliteBatch, esq := strconv.Atoi(s[2])
+		boltBatch, ebk := strconv.Atoi(s[5])
+		var eas error
+		if "" != s[6] {
+			_, eas = as.StringToFormat(s[6])
+		}
+		return sinkOptions{
+			sqlite:          s[0],
+			sqliteTable:     s[1],
+			sqliteBatchSize: sqliteBatch,
+			bolt:            s[3],
+			boltBucket:      s[4],
+			boltBatchSize:   boltBatch,
+			archiveFormat:   s[6],
+			archiveFilename: s[7],
+		}, errors.Join(esq, ebk, eas)
+	}),
+)
+
+// The object keys are relative to ENV_SAVE_DIRNAME_ROOT(set by loadOptions).
+var s3Config IO[s3.Config] = Bind(
+	All(
+		EnvValByKey("ENV_S3_BUCKET").Or(Of("")),
+		EnvValByKey("ENV_S3_PREFIX").Or(Of("")),
+		EnvValByKey("ENV_S3_ENDPOINT").Or(Of("")),
+		EnvValByKey("ENV_S3_REGION").
+			Or(EnvValByKey("AWS_REGION")).
+			Or(Of(s3.RegionDefault)),
+		EnvValByKey("ENV_S3_PATH_STYLE").Or(Of("false")),
+		EnvValByKey("AWS_ACCESS_KEY_ID").Or(Of("")),
+		EnvValByKey("AWS_SECRET_ACCESS_KEY").Or(Of("")),
+		EnvValByKey("AWS_SESSION_TOKEN").Or(Of("")),
+		EnvValByKey("ENV_S3_PART_SIZE").Or(Of("0")),
+	),
+	Lift(func(s []string) (s3.Config, error) {
+		pathStyle, epath := strconv.ParseBool(s[4])
+		partSize, epart := strconv.ParseInt(s[8], 10, 64)
+		return s3.Config{
+			Bucket:    s[0],
+			Prefix:    s[1],
+			Endpoint:  s[2],
+			Region:    s[3],
+			PathStyle: pathStyle,
+			Credentials: s3.Credentials{
+				AccessKeyId:     s[5],
+				SecretAccessKey: s[6],
+				SessionToken:    s[7],
+			},
+			PartSize: partSize,
+		}, errors.Join(epath, epart)
+	}),
+)
+
+// Aborts the run if any limit is exceeded(0: no limit).
+var limits IO[eh.Limits] = Bind(
+	All(
+		EnvValByKey("ENV_MAX_PARTITIONS").Or(Of("0")),
+		EnvValByKey("ENV_MAX_TOTAL_BYTES").Or(Of("0")),
+		EnvValByKey("ENV_MIN_FREE_BYTES").Or(Of("0")),
+		EnvValByKey("ENV_MAX_FILE_BYTES").Or(Of("0")),
+	),
+	Lift(func(s []string) (eh.Limits, error) {
+		partitions, ep := strconv.Atoi(s[0])
+		total, et := strconv.ParseInt(s[1], 10, 64)
+		free, ef := strconv.ParseInt(s[2], 10, 64)
+		file, efile := strconv.ParseInt(s[3], 10, 64)
+		return eh.Limits{
+			MaxPartitions: partitions,
+			MaxTotalBytes: total,
+			MinFreeBytes:  free,
+			MaxFileBytes:  file,
+		}, errors.Join(ep, et, ef, efile)
+	}),
+)
+
+// The digests are kept in the manifest unless ENV_DIGEST_STORE is sidecar
+// or xattr; the partitions(or the manifest if ENV_SIGN_MODE is manifest)
+// are signed using the Ed25519 key of ENV_SIGN_KEY_FILENAME.
+var digestOpts IO[digestOptions] = Bind(
+	All(
+		EnvValByKey("ENV_DIGEST_ALGORITHM").Or(Of("")),
+		EnvValByKey("ENV_DIGEST_STORE").Or(Of("")),
+		EnvValByKey("ENV_MANIFEST").Or(Of("false")),
+		EnvValByKey("ENV_MANIFEST_FILENAME").Or(Of("")),
+		EnvValByKey("ENV_SIGN_KEY_FILENAME").Or(Of("")),
+		EnvValByKey("ENV_SIGN_MODE").Or(Of("partition")),
+	),
+	Lift(func(s []string) (digestOptions, error) {
+		da, eda := eh.StringToDigestAlgorithm(s[0])
+		ds, eds := eh.StringToDigestStore(s[1])
+		enabled, eme := strconv.ParseBool(s[2])
+		mode, emode := sg.StringToMode(s[5])
+		return digestOptions{
+			algorithm:        da,
+			store:            ds,
+			enabled:          "" != s[0] || "" != s[1],
+			manifest:         enabled,
+			manifestFilename: s[3],
+			signKeyFilename:  s[4],
+			signMode:         mode,
+		}, errors.Join(eda, eds, eme, emode)
+	}),
+)
+
+// Splits the partitions into segments using the limits(0: no limit).
+var rolling IO[eh.Rolling] = Bind(
+	All(
+		EnvValByKey("ENV_ROLL_MAX_BYTES").Or(Of("0")),
+		EnvValByKey("ENV_ROLL_MAX_RECORDS").Or(Of("0")),
+	),
+	Lift(func(s []string) (eh.Rolling, error) {
+		maxBytes, eb := strconv.ParseInt(s[0], 10, 64)
+		maxRecords, er := strconv.Atoi(s[1])
+		return eh.Rolling{
+			MaxBytes:   maxBytes,
+			MaxRecords: maxRecords,
+		}, errors.Join(eb, er)
+	}),
+)
+
+var concurrency IO[pk.Concurrency] = Bind(
+	All(
+		EnvValByKey("ENV_WORKERS").Or(Of("0")),
+		EnvValByKey("ENV_QUEUE_SIZE").Or(Of("0")),
+	),
+	Lift(func(s []string) (pk.Concurrency, error) {
+		workers, ew := strconv.Atoi(s[0])
+		queueSize, eq := strconv.Atoi(s[1])
+		return pk.Concurrency{
+			Workers:   workers,
+			QueueSize: queueSize,
+		}, errors.Join(ew, eq)
+	}),
+)
+
+// load runs the parser and keeps the parsed value.
+func load[T any](ctx context.Context, parse IO[T], parsed *T) error {
+	val, e := parse(ctx)
+	*parsed = val
+	return e
+}
+
+// Reads all the environment variables; the directories default to the
+// ones under ENV_SAVE_DIRNAME_ROOT.
+var loadOptions IO[options] = func(ctx context.Context) (options, error) {
+	var o options
+	var s []string
+	e := load(ctx, All(
+		EnvValByKey("ENV_PKEY_NAME"),
+		EnvValByKey("ENV_SAVE_DIRNAME_ROOT"),
+		EnvValByKey("ENV_EXIST_POLICY").Or(Of("overwrite")),
+		EnvValByKey("ENV_DETERMINISTIC").Or(Of("false")),
+		EnvValByKey("ENV_DRY_RUN").Or(Of("false")),
+		EnvValByKey("ENV_PRINT_STATS").Or(Of("false")),
+		EnvValByKey("ENV_ENCRYPTION_CHUNK_SIZE").Or(Of("")),
+		EnvValByKey("ENV_MAX_OPEN_FILES").Or(Of("")),
+		EnvValByKey("ENV_CAS_DIRNAME").Or(Of("")),
+		EnvValByKey("ENV_SCHEMA_STORE_DIRNAME").Or(Of("")),
+	), &s)
+	if nil != e {
+		return o, e
+	}
+
+	var ep, edet, edry error
+	o.keyName = s[0]
+	o.dirname = eh.Dirname(s[1])
+	o.existPolicy, ep = eh.StringToExistPolicy(s[2])
+	o.deterministic, edet = strconv.ParseBool(s[3])
+	o.dryRun, edry = strconv.ParseBool(s[4])
+	o.printStats = "true" == s[5]
+	o.chunkSize = atoiOr(s[6], ec.ChunkSizeDefault)
+	o.maxOpen = atoiOr(s[7], 0)
+	o.casDirname = cmp.Or(s[8], filepath.Join(s[1], bs.DirnameDefault))
+	o.schemaStoreDirname = cmp.Or(
+		s[9],
+		filepath.Join(s[1], ss.DirnameDefault),
+	)
+
+	e = errors.Join(
+		ep,
+		edet,
+		edry,
+		load(ctx, decodeConfig, &o.decode),
+		load(ctx, ecfg, &o.config),
+		load(ctx, syncConfig, &o.sync),
+		load(ctx, encryptionKey, &o.encryptionKey),
+		load(ctx, keyring, &o.keyring),
+		load(ctx, partitionMetadata(o.deterministic), &o.meta),
+		load(ctx, blobConfig, &o.blob),
+		load(ctx, casConfig, &o.cas),
+		load(ctx, sinkOpts, &o.sinks),
+		load(ctx, s3Config, &o.s3),
+		load(ctx, limits, &o.limits),
+		load(ctx, digestOpts, &o.digest),
+		load(ctx, rolling, &o.rolling),
+		load(ctx, concurrency, &o.concurrency),
+	)
+	if nil != e {
+		return o, e
+	}
+
+	o.s3.Root = s[1]
+	o.digest.manifestFilename = cmp.Or(
+		o.digest.manifestFilename,
+		filepath.Join(s[1], mf.FilenameDefault),
+	)
+	if nil != o.encryptionKey {
+		o.keyring[o.encryptionKey.Id] = *o.encryptionKey
+	}
+	return o, nil
+}
@@ -0,0 +1,23 @@
+//go:build (darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (openbsd && (amd64 || arm64)) || (windows && (386 || amd64 || arm64))
+
+package main
+
+import (
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+	sq "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/sqlitesink"
+)
+
+// Upserts the partitions as rows of the database.
+func (r *runner) sqliteSink(c eh.Config) (eh.Sink, error) {
+	fp, e := schemaFingerprint(c)
+	if nil != e {
+		return nil, e
+	}
+	return sq.Config{
+		Filename:    r.sinks.sqlite,
+		Table:       r.sinks.sqliteTable,
+		ExistPolicy: r.existPolicy,
+		SchemaFp:    fp,
+		BatchSize:   r.sinks.sqliteBatchSize,
+	}.Open()
+}
@@ -0,0 +1,15 @@
+//go:build !((darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (openbsd && (amd64 || arm64)) || (windows && (386 || amd64 || arm64)))
+
+package main
+
+import (
+	"errors"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+var ErrSqliteUnsupported error = errors.New("sqlite sink unsupported")
+
+func (r *runner) sqliteSink(_ eh.Config) (eh.Sink, error) {
+	return nil, ErrSqliteUnsupported
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	. "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/util"
+
+	mf "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/manifest"
+	sg "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/signature"
+)
+
+var EnvValByKey func(string) IO[string] = Lift(
+	func(key string) (string, error) {
+		val, found := os.LookupEnv(key)
+		switch found {
+		case true:
+			return val, nil
+		default:
+			return "", fmt.Errorf("env var %s missing", key)
+		}
+	},
+)
+
+var dirname IO[string] = EnvValByKey("ENV_SAVE_DIRNAME_ROOT")
+
+// Unsigned partitions fail the verification unless ENV_ALLOW_UNSIGNED is
+// true.
+var verifier IO[sg.Verifier] = Bind(
+	All(
+		EnvValByKey("ENV_VERIFY_KEY_FILENAME"),
+		EnvValByKey("ENV_MANIFEST_NAME").Or(Of(mf.FilenameDefault)),
+		EnvValByKey("ENV_ALLOW_UNSIGNED").Or(Of("false")),
+	),
+	Lift(func(s []string) (sg.Verifier, error) {
+		key, ekey := sg.LoadPublicKey(s[0])
+		allow, eallow := strconv.ParseBool(s[2])
+		return sg.Verifier{
+			Key:           key,
+			ManifestName:  s[1],
+			AllowUnsigned: allow,
+		}, errors.Join(ekey, eallow)
+	}),
+)
+
+// Writes the results as json lines.
+func writeResults(results []sg.Result) error {
+	var bw *bufio.Writer = bufio.NewWriter(os.Stdout)
+	var enc *json.Encoder = json.NewEncoder(bw)
+	for _, res := range results {
+		e := enc.Encode(res)
+		if nil != e {
+			return e
+		}
+	}
+	return bw.Flush()
+}
+
+// Checks the tree using the public key.
+var verify IO[Void] = Bind(
+	dirname,
+	func(root string) IO[Void] {
+		return Bind(
+			verifier,
+			Lift(func(v sg.Verifier) (Void, error) {
+				results, e := v.VerifyTree(root)
+				return Empty, errors.Join(e, writeResults(results))
+			}),
+		)
+	},
+)
+
+var sub IO[Void] = func(ctx context.Context) (Void, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	return verify(ctx)
+}
+
+func main() {
+	_, e := sub(context.Background())
+	if nil != e {
+		log.Printf("%v\n", e)
+		os.Exit(1)
+	}
+}
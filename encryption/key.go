@@ -0,0 +1,118 @@
+// Package encryption encrypts the partitions using chunked AES-256-GCM.
+package encryption
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ErrInvalidKey  error = errors.New("invalid encryption key")
+	ErrKeyNotFound error = errors.New("encryption key not found")
+)
+
+const KeySize int = 32
+
+// The maximum size of a key id.
+const KeyIdSizeMax int = 255
+
+// Key is an AES-256 key with its id.
+type Key struct {
+	// Stored in the header of the encrypted files to find the key.
+	Id string
+
+	Secret [KeySize]byte
+}
+
+// Fingerprint returns the default key id(the first 8 bytes of the SHA-256).
+func Fingerprint(secret [KeySize]byte) string {
+	var sum [sha256.Size]byte = sha256.Sum256(secret[:])
+	return hex.EncodeToString(sum[:8])
+}
+
+func decodeSecret(s string) ([]byte, error) {
+	if KeySize*2 == len(s) {
+		return hex.DecodeString(s)
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// ParseKey parses "id:secret" or "secret".
+//
+// The secret is raw(32 bytes), hex or base64 encoded. The fingerprint will be
+// used as the id if omitted.
+func ParseKey(data []byte) (Key, error) {
+	var ret Key
+	if KeySize == len(data) {
+		copy(ret.Secret[:], data)
+		ret.Id = Fingerprint(ret.Secret)
+		return ret, nil
+	}
+
+	var trimmed string = string(bytes.TrimSpace(data))
+	id, encoded, found := strings.Cut(trimmed, ":")
+	if !found {
+		id, encoded = "", trimmed
+	}
+
+	secret, e := decodeSecret(encoded)
+	if nil != e {
+		return ret, fmt.Errorf("%w: %w", ErrInvalidKey, e)
+	}
+	if KeySize != len(secret) || KeyIdSizeMax < len(id) {
+		return ret, fmt.Errorf("%w: size=%v", ErrInvalidKey, len(secret))
+	}
+	copy(ret.Secret[:], secret)
+
+	ret.Id = id
+	if "" == id {
+		ret.Id = Fingerprint(ret.Secret)
+	}
+	return ret, nil
+}
+
+func LoadKey(filename string) (Key, error) {
+	data, e := os.ReadFile(filename)
+	if nil != e {
+		return Key{}, e
+	}
+	return ParseKey(data)
+}
+
+// Keyring finds the keys by their ids(e.g, the current and the old keys).
+type Keyring map[string]Key
+
+func KeyringNew(keys ...Key) Keyring {
+	var ret Keyring = Keyring{}
+	for _, key := range keys {
+		ret[key.Id] = key
+	}
+	return ret
+}
+
+// LoadKeyring loads the keys of the files.
+func LoadKeyring(filenames []string) (Keyring, error) {
+	var ret Keyring = Keyring{}
+	for _, filename := range filenames {
+		key, e := LoadKey(filename)
+		if nil != e {
+			return nil, e
+		}
+		ret[key.Id] = key
+	}
+	return ret, nil
+}
+
+func (k Keyring) Get(id string) (Key, error) {
+	key, found := k[id]
+	if !found {
+		return key, fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	return key, nil
+}
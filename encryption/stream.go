@@ -0,0 +1,333 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	ErrInvalidHeader error = errors.New("invalid encryption header")
+	ErrTruncated     error = errors.New("encrypted stream truncated")
+	ErrTooLarge      error = errors.New("too many chunks")
+)
+
+// Magic is the first bytes of an encrypted file(name and version 1).
+var Magic [8]byte = [8]byte{'a', 'v', 'r', 'o', 'e', 'n', 'c', 1}
+
+const (
+	ChunkSizeDefault int = 64 * 1024
+	ChunkSizeMax     int = 16 * 1024 * 1024
+
+	saltSize        int = KeySize
+	noncePrefixSize int = 7
+)
+
+// The header of an encrypted file.
+//
+//	magic(8) | key id length(1) | key id | chunk size(uint32 BE) |
+//	salt(32) | nonce prefix(7)
+//
+// Each file is encrypted using its own key derived from the key and the
+// random salt(HKDF-SHA256), like the streaming AEAD of Tink; the nonces of
+// the files never collide under the same key.
+//
+// Each chunk is sealed using the nonce prefix, the chunk index(uint32 BE)
+// and the last chunk flag(1 byte); the header is the additional data. The
+// last chunk(possibly empty) detects truncation.
+type header struct {
+	keyId     string
+	chunkSize int
+	salt      [saltSize]byte
+	prefix    [noncePrefixSize]byte
+}
+
+func (h header) marshal() []byte {
+	var buf []byte = append([]byte{}, Magic[:]...)
+	buf = append(buf, byte(len(h.keyId)))
+	buf = append(buf, h.keyId...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(h.chunkSize))
+	buf = append(buf, h.salt[:]...)
+	return append(buf, h.prefix[:]...)
+}
+
+func readHeader(r io.Reader) (header, []byte, error) {
+	var ret header
+	var fixed [len(Magic) + 1]byte
+	_, e := io.ReadFull(r, fixed[:])
+	if nil != e {
+		return ret, nil, fmt.Errorf("%w: %w", ErrInvalidHeader, e)
+	}
+	if !bytes.Equal(Magic[:], fixed[:len(Magic)]) {
+		return ret, nil, ErrInvalidHeader
+	}
+
+	var rest []byte = make(
+		[]byte,
+		int(fixed[len(Magic)])+4+saltSize+noncePrefixSize,
+	)
+	_, e = io.ReadFull(r, rest)
+	if nil != e {
+		return ret, nil, fmt.Errorf("%w: %w", ErrInvalidHeader, e)
+	}
+
+	var idSize int = int(fixed[len(Magic)])
+	ret.keyId = string(rest[:idSize])
+	ret.chunkSize = int(binary.BigEndian.Uint32(rest[idSize:]))
+	copy(ret.salt[:], rest[idSize+4:])
+	copy(ret.prefix[:], rest[idSize+4+saltSize:])
+	if ret.chunkSize <= 0 || ChunkSizeMax < ret.chunkSize {
+		return ret, nil, fmt.Errorf("%w: chunk size", ErrInvalidHeader)
+	}
+	return ret, append(fixed[:], rest...), nil
+}
+
+// deriveKey derives the key of a file from the key and the salt.
+func deriveKey(key Key, salt []byte) ([KeySize]byte, error) {
+	var ret [KeySize]byte
+	_, e := io.ReadFull(
+		hkdf.New(sha256.New, key.Secret[:], salt, Magic[:]),
+		ret[:],
+	)
+	return ret, e
+}
+
+func newAead(key Key, salt []byte) (cipher.AEAD, error) {
+	derived, e := deriveKey(key, salt)
+	if nil != e {
+		return nil, e
+	}
+	block, e := aes.NewCipher(derived[:])
+	if nil != e {
+		return nil, e
+	}
+	return cipher.NewGCM(block)
+}
+
+type chunkNonce struct {
+	prefix [noncePrefixSize]byte
+	index  uint64
+	buf    [noncePrefixSize + 5]byte
+}
+
+func (c *chunkNonce) next(last bool) ([]byte, error) {
+	if math.MaxUint32 < c.index {
+		return nil, ErrTooLarge
+	}
+	copy(c.buf[:], c.prefix[:])
+	binary.BigEndian.PutUint32(c.buf[noncePrefixSize:], uint32(c.index))
+	c.buf[noncePrefixSize+4] = 0
+	if last {
+		c.buf[noncePrefixSize+4] = 1
+	}
+	c.index++
+	return c.buf[:], nil
+}
+
+// Writer encrypts the written bytes.
+//
+// Close must be called to write the last chunk; the underlying writer will
+// not be closed.
+type Writer struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	aad   []byte
+	nonce chunkNonce
+	size  int
+
+	plain  []byte
+	sealed []byte
+}
+
+// NewWriter writes the header and returns the encrypting writer.
+func (k Key) NewWriter(w io.Writer, chunkSize int) (*Writer, error) {
+	if chunkSize <= 0 {
+		chunkSize = ChunkSizeDefault
+	}
+	if ChunkSizeMax < chunkSize || KeyIdSizeMax < len(k.Id) {
+		return nil, ErrInvalidHeader
+	}
+
+	var h header = header{keyId: k.Id, chunkSize: chunkSize}
+	_, e := rand.Read(h.salt[:])
+	if nil == e {
+		_, e = rand.Read(h.prefix[:])
+	}
+	if nil != e {
+		return nil, e
+	}
+
+	aead, e := newAead(k, h.salt[:])
+	if nil != e {
+		return nil, e
+	}
+
+	var aad []byte = h.marshal()
+	_, e = w.Write(aad)
+	if nil != e {
+		return nil, e
+	}
+	return &Writer{
+		w:     w,
+		aead:  aead,
+		aad:   aad,
+		nonce: chunkNonce{prefix: h.prefix},
+		size:  chunkSize,
+		plain: make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (w *Writer) seal(plain []byte, last bool) error {
+	nonce, e := w.nonce.next(last)
+	if nil != e {
+		return e
+	}
+	w.sealed = w.aead.Seal(w.sealed[:0], nonce, plain, w.aad)
+	_, e = w.w.Write(w.sealed)
+	return e
+}
+
+func (w *Writer) Write(data []byte) (int, error) {
+	var written int
+	for 0 < len(data) {
+		// a full chunk is sealed only when more bytes follow
+		if w.size == len(w.plain) {
+			e := w.seal(w.plain, false)
+			if nil != e {
+				return written, e
+			}
+			w.plain = w.plain[:0]
+		}
+		var n int = min(w.size-len(w.plain), len(data))
+		w.plain = append(w.plain, data[:n]...)
+		data = data[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the last chunk.
+func (w *Writer) Close() error {
+	return w.seal(w.plain, true)
+}
+
+// Reader decrypts the chunks.
+type Reader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	aad   []byte
+	nonce chunkNonce
+	size  int
+
+	sealed []byte
+	plain  []byte
+	done   bool
+}
+
+// NewReader reads the header and finds the key in the keyring.
+func (k Keyring) NewReader(r io.Reader) (*Reader, error) {
+	h, aad, e := readHeader(r)
+	if nil != e {
+		return nil, e
+	}
+	key, e := k.Get(h.keyId)
+	if nil != e {
+		return nil, e
+	}
+	aead, e := newAead(key, h.salt[:])
+	if nil != e {
+		return nil, e
+	}
+	return &Reader{
+		r:      bufio.NewReader(r),
+		aead:   aead,
+		aad:    aad,
+		nonce:  chunkNonce{prefix: h.prefix},
+		size:   h.chunkSize,
+		sealed: make([]byte, h.chunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (r *Reader) open() error {
+	n, e := io.ReadFull(r.r, r.sealed)
+	var last bool
+	switch {
+	case errors.Is(e, io.ErrUnexpectedEOF):
+		last = true
+	case errors.Is(e, io.EOF):
+		return ErrTruncated
+	case nil != e:
+		return e
+	default:
+		_, e = r.r.Peek(1)
+		last = errors.Is(e, io.EOF)
+	}
+
+	nonce, e := r.nonce.next(last)
+	if nil != e {
+		return e
+	}
+	r.plain, e = r.aead.Open(r.plain[:0], nonce, r.sealed[:n], r.aad)
+	if nil != e {
+		// a non-last chunk can not be opened as the last chunk
+		return fmt.Errorf("%w: %w", ErrTruncated, e)
+	}
+	r.done = last
+	return nil
+}
+
+func (r *Reader) Read(buf []byte) (int, error) {
+	for 0 == len(r.plain) {
+		if r.done {
+			return 0, io.EOF
+		}
+		e := r.open()
+		if nil != e {
+			return 0, e
+		}
+	}
+	var n int = copy(buf, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// Encrypted is true if the bytes start with the Magic.
+func Encrypted(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, Magic[:])
+}
+
+// MaybeDecrypt decrypts the encrypted input; plain input is returned as is.
+func (k Keyring) MaybeDecrypt(r io.Reader) (io.Reader, error) {
+	var br *bufio.Reader = bufio.NewReader(r)
+	prefix, e := br.Peek(len(Magic))
+	if nil != e && !errors.Is(e, io.EOF) {
+		return nil, e
+	}
+	if !Encrypted(prefix) {
+		return br, nil
+	}
+	return k.NewReader(br)
+}
+
+// Encrypt encrypts the whole data.
+func (k Key) Encrypt(w io.Writer, data []byte, chunkSize int) error {
+	enc, e := k.NewWriter(w, chunkSize)
+	if nil != e {
+		return e
+	}
+	_, e = enc.Write(data)
+	if nil != e {
+		return e
+	}
+	return enc.Close()
+}
@@ -0,0 +1,125 @@
+package encryption_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	ec "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/encryption"
+)
+
+var key ec.Key = ec.Key{Id: "k1", Secret: [ec.KeySize]byte{1, 2, 3}}
+
+// The size of the header of the key.
+var headerSize int = len(ec.Magic) + 1 + len(key.Id) + 4 + 32 + 7
+
+func encrypt(t *testing.T, data []byte, chunkSize int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	e := key.Encrypt(&buf, data, chunkSize)
+	if nil != e {
+		t.Fatal(e)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(ring ec.Keyring, sealed []byte) ([]byte, error) {
+	r, e := ring.MaybeDecrypt(bytes.NewReader(sealed))
+	if nil != e {
+		return nil, e
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	var data []byte = bytes.Repeat([]byte("0123456789"), 100)
+	for _, size := range []int{0, 10, 16, 100, 1000} {
+		for _, chunkSize := range []int{1, 10, 64} {
+			var sealed []byte = encrypt(t, data[:size], chunkSize)
+			if !ec.Encrypted(sealed) {
+				t.Fatalf("not encrypted: %v", sealed)
+			}
+			plain, e := decrypt(ec.KeyringNew(key), sealed)
+			if nil != e || !bytes.Equal(data[:size], plain) {
+				t.Fatalf("size=%v chunk=%v: %v", size, chunkSize, e)
+			}
+		}
+	}
+}
+
+// Each file has its own salt, then its own key.
+func TestSaltedFiles(t *testing.T) {
+	var data []byte = []byte("same data")
+	var a []byte = encrypt(t, data, 0)
+	var b []byte = encrypt(t, data, 0)
+	if len(a) != len(b) {
+		t.Fatalf("unexpected sizes: %v %v", len(a), len(b))
+	}
+	if bytes.Equal(a[:headerSize-7], b[:headerSize-7]) {
+		t.Fatal("same salt")
+	}
+	if bytes.Equal(a[headerSize:], b[headerSize:]) {
+		t.Fatal("same ciphertext")
+	}
+
+	// the ciphertext of a can not be opened using the salt of b
+	var mixed []byte = append(bytes.Clone(b[:headerSize]), a[headerSize:]...)
+	_, e := decrypt(ec.KeyringNew(key), mixed)
+	if nil == e {
+		t.Fatal("opened using another salt")
+	}
+}
+
+func TestTampered(t *testing.T) {
+	var data []byte = bytes.Repeat([]byte("x"), 100)
+	var sealed []byte = encrypt(t, data, 10)
+	var cases = map[string][]byte{
+		"modified": func() []byte {
+			var b []byte = bytes.Clone(sealed)
+			b[headerSize+1] ^= 1
+			return b
+		}(),
+		"salt": func() []byte {
+			var b []byte = bytes.Clone(sealed)
+			b[headerSize-8] ^= 1
+			return b
+		}(),
+		// the chunks of 10 bytes and their tags(16 bytes)
+		"truncated": sealed[:len(sealed)-26],
+		"header":    sealed[:headerSize],
+	}
+	for name, b := range cases {
+		_, e := decrypt(ec.KeyringNew(key), b)
+		if nil == e {
+			t.Errorf("%s: opened", name)
+		}
+	}
+
+	_, e := decrypt(ec.KeyringNew(key), sealed[:len(sealed)-26])
+	if !errors.Is(e, ec.ErrTruncated) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+}
+
+func TestKeyNotFound(t *testing.T) {
+	var other ec.Key = ec.Key{Id: "k2", Secret: key.Secret}
+	_, e := decrypt(ec.KeyringNew(other), encrypt(t, []byte("data"), 0))
+	if !errors.Is(e, ec.ErrKeyNotFound) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+
+	// the same id with another secret
+	var wrong ec.Key = ec.Key{Id: key.Id, Secret: [ec.KeySize]byte{9}}
+	_, e = decrypt(ec.KeyringNew(wrong), encrypt(t, []byte("data"), 0))
+	if nil == e {
+		t.Fatal("opened using a wrong key")
+	}
+}
+
+func TestMaybeDecryptPlain(t *testing.T) {
+	plain, e := decrypt(ec.KeyringNew(), []byte("plain"))
+	if nil != e || "plain" != string(plain) {
+		t.Fatalf("unexpected data: %v", e)
+	}
+}
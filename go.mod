@@ -2,13 +2,31 @@ module github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey
 
 go 1.23.4
 
-require github.com/hamba/avro/v2 v2.27.0
+require (
+	github.com/dsnet/compress v0.0.1
+	github.com/hamba/avro/v2 v2.27.0
+	github.com/klauspost/compress v1.17.10
+	github.com/ulikunitz/xz v0.5.12
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/crypto v0.32.0
+	golang.org/x/sys v0.29.0
+	lukechampine.com/blake3 v1.3.0
+	modernc.org/sqlite v1.34.5
+)
 
 require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
+	github.com/google/uuid v1.6.0 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
-	github.com/klauspost/compress v1.17.10 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
 )
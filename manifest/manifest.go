@@ -0,0 +1,258 @@
+// Package manifest keeps an append-only log of the written partitions.
+package manifest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+// The name of the manifest in the output root.
+const FilenameDefault string = "_manifest.jsonl"
+
+// Entry describes a partition.
+//
+// The manifest is a json object per line; the last entry of a path wins.
+type Entry struct {
+	Key string `json:"key"`
+
+	// The slash separated path relative to the output root.
+	Path string `json:"path"`
+
+	// The sequence number of the segment of the key(0: not rolled).
+	Segment int `json:"segment,omitempty"`
+
+	Records int      `json:"records"`
+	Bytes   int64    `json:"bytes"`
+	Codec   bp.Codec `json:"codec"`
+
+	SchemaFp string `json:"schema_fp"`
+
+	// The algorithm and the hex digest(empty: not computed).
+	Checksum string `json:"checksum,omitempty"`
+
+	// RFC3339 time(empty: reproducible output).
+	WrittenAt string `json:"written_at,omitempty"`
+
+	// The partition was removed; Load drops the path.
+	Removed bool `json:"removed,omitempty"`
+}
+
+// Load reads the entries of the manifest; the last entry of a path wins.
+func Load(r io.Reader) (map[string]Entry, error) {
+	var ret map[string]Entry = map[string]Entry{}
+	var s *bufio.Scanner = bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var line []byte = s.Bytes()
+		if 0 == len(line) {
+			continue
+		}
+		var ent Entry
+		e := json.Unmarshal(line, &ent)
+		if nil != e {
+			return nil, e
+		}
+		switch ent.Removed {
+		case true:
+			delete(ret, ent.Path)
+		default:
+			ret[ent.Path] = ent
+		}
+	}
+	return ret, s.Err()
+}
+
+// LoadFile reads the manifest file(no entries if it does not exist).
+func LoadFile(filename string) (map[string]Entry, error) {
+	f, e := os.Open(filename)
+	if errors.Is(e, fs.ErrNotExist) {
+		return map[string]Entry{}, nil
+	}
+	if nil != e {
+		return nil, e
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+type Config struct {
+	// The output root; the paths are relative to it.
+	Root string
+
+	SchemaFp string
+
+	// Returns the write time(nil: no write time).
+	Clock func() time.Time
+
+	// Called on Close(nil: no sync).
+	Sync func(*os.File) error
+}
+
+// Manifest appends an entry for each partition written in a run.
+//
+// The existing entries are kept; the records of an appended partition are
+// added to the records of its last entry. A line is appended as each
+// partition is committed(or removed); the last line of a path wins.
+// Manifest is safe for concurrent use.
+type Manifest struct {
+	Config
+
+	mu       sync.Mutex
+	filename string
+	file     *os.File
+	entries  map[string]Entry
+}
+
+// Open loads the existing manifest and opens it to append the entries.
+func (c Config) Open(filename string) (*Manifest, error) {
+	entries, e := LoadFile(filename)
+	if nil != e {
+		return nil, e
+	}
+
+	e = os.MkdirAll(filepath.Dir(filename), 0o755)
+	if nil != e {
+		return nil, e
+	}
+
+	f, e := os.OpenFile(
+		filename,
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND,
+		eh.FileModeDefault,
+	)
+	if nil != e {
+		return nil, e
+	}
+	return &Manifest{
+		Config:   c,
+		filename: filename,
+		file:     f,
+		entries:  entries,
+	}, nil
+}
+
+// Name returns the filename of the manifest.
+func (m *Manifest) Name() string { return m.filename }
+
+// RelPath converts the partition filename to the path in the manifest.
+//
+// Names outside the root(e.g, keys of a database) are kept as they are.
+func (c Config) RelPath(filename string) string {
+	rel, e := filepath.Rel(c.Root, filename)
+	rel = filepath.ToSlash(rel)
+	if nil != e || ".." == rel || strings.HasPrefix(rel, "../") {
+		return filepath.ToSlash(filename)
+	}
+	return rel
+}
+
+func (m *Manifest) toEntry(s eh.PartitionStat) Entry {
+	var ent Entry = Entry{
+		Key:      s.Key,
+		Path:     m.RelPath(s.Filename),
+		Segment:  s.Segment,
+		Records:  s.Records,
+		Bytes:    s.Bytes,
+		Codec:    s.Codec,
+		SchemaFp: m.SchemaFp,
+	}
+
+	var prev Entry = m.entries[ent.Path]
+	switch nil == s.Digest {
+	case true:
+		// no way to know if the partition was appended
+	default:
+		ent.Bytes = s.Digest.Size
+		ent.Checksum = s.Digest.Checksum
+		if s.Digest.Appended {
+			ent.Records += prev.Records
+		}
+	}
+
+	if nil != m.Clock {
+		ent.WrittenAt = m.Clock().UTC().Format(time.RFC3339Nano)
+	}
+	return ent
+}
+
+// Observe appends the entry of the committed(or removed) partition.
+func (m *Manifest) Observe(s eh.PartitionStat) error {
+	if s.Skipped {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ent Entry = Entry{
+		Key:     s.Key,
+		Path:    m.RelPath(s.Filename),
+		Segment: s.Segment,
+		Removed: true,
+	}
+	if !s.Removed {
+		ent = m.toEntry(s)
+	}
+	line, e := json.Marshal(ent)
+	if nil != e {
+		return e
+	}
+	_, e = m.file.Write(append(line, '\n'))
+	if nil != e {
+		return e
+	}
+
+	switch ent.Removed {
+	case true:
+		delete(m.entries, ent.Path)
+	default:
+		m.entries[ent.Path] = ent
+	}
+	return nil
+}
+
+func (m *Manifest) ToObserver() eh.StatObserver { return m.Observe }
+
+// Entries returns the last entries sorted by path.
+func (m *Manifest) Entries() []Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ret []Entry
+	for _, path := range slices.Sorted(maps.Keys(m.entries)) {
+		if !m.entries[path].Removed {
+			ret = append(ret, m.entries[path])
+		}
+	}
+	return ret
+}
+
+// Close syncs and closes the manifest; a nil manifest is ignored.
+func (m *Manifest) Close() error {
+	if nil == m {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var e error
+	if nil != m.Sync {
+		e = m.Sync(m.file)
+	}
+	return errors.Join(e, m.file.Close())
+}
@@ -0,0 +1,110 @@
+package manifest_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+	mf "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/manifest"
+)
+
+func manifestLines(t *testing.T, filename string) []string {
+	t.Helper()
+	data, e := os.ReadFile(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestManifestIncremental(t *testing.T) {
+	var root string = t.TempDir()
+	var filename string = filepath.Join(root, mf.FilenameDefault)
+
+	for run := range 2 {
+		m, e := mf.Config{Root: root}.Open(filename)
+		if nil != e {
+			t.Fatal(e)
+		}
+		// each commit(e.g, a record without the pool) observes a stat
+		for i := range 3 {
+			for j, name := range []string{"b.avro", "a.avro"} {
+				e = m.Observe(eh.PartitionStat{
+					Filename: filepath.Join(root, name),
+					Records:  1,
+					Digest: &eh.Digest{
+						Checksum: "sha256:00",
+						Size:     int64(i),
+						Appended: 0 < run+i,
+					},
+				})
+				if nil != e {
+					t.Fatal(e)
+				}
+
+				// the line is written before the close
+				var lines []string = manifestLines(t, filename)
+				if 6*run+2*i+j+1 != len(lines) {
+					t.Fatalf("unexpected lines: %v", lines)
+				}
+				if !strings.Contains(lines[len(lines)-1], name) {
+					t.Fatalf("unexpected line: %s", lines[len(lines)-1])
+				}
+			}
+		}
+		e = m.Close()
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+
+	entries, e := mf.LoadFile(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	for _, path := range []string{"a.avro", "b.avro"} {
+		if 6 != entries[path].Records {
+			t.Fatalf("%s: %v", path, entries[path])
+		}
+	}
+}
+
+func TestManifestRemoved(t *testing.T) {
+	var root string = t.TempDir()
+	var filename string = filepath.Join(root, mf.FilenameDefault)
+	var stats []eh.PartitionStat = []eh.PartitionStat{
+		{Filename: filepath.Join(root, "k.0001.avro"), Segment: 1},
+		{Filename: filepath.Join(root, "k.0002.avro"), Segment: 2},
+		{Filename: filepath.Join(root, "k.0002.avro"), Removed: true},
+	}
+	for i, s := range [][]eh.PartitionStat{stats[:2], stats[2:]} {
+		m, e := mf.Config{Root: root}.Open(filename)
+		if nil != e {
+			t.Fatal(e)
+		}
+		for _, stat := range s {
+			e = m.Observe(stat)
+			if nil != e {
+				t.Fatal(e)
+			}
+		}
+		if 2-i != len(m.Entries()) {
+			t.Fatalf("unexpected entries: %v", m.Entries())
+		}
+		e = m.Close()
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+
+	entries, e := mf.LoadFile(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	_, found := entries["k.0002.avro"]
+	if 1 != len(entries) || found {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+}
@@ -0,0 +1,190 @@
+package pkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"iter"
+	"maps"
+	"sync"
+
+	. "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/util"
+)
+
+const QueueSizeDefault int = 64
+
+// Concurrency fans out the records to the workers by the hash of the key.
+//
+// The records are decoded while the workers save the queued records. The
+// records of a key are saved by the same worker in the input order. At most
+// Workers * QueueSize records are queued.
+type Concurrency struct {
+	Workers int
+
+	// The number of the queued records per worker(0: QueueSizeDefault).
+	QueueSize int
+}
+
+// SaverFactory creates the saver of the worker; the saver will be used only
+// by the worker.
+type SaverFactory func(worker int) IO[RecordsSaver]
+
+// KeyToWorker returns the worker of the key.
+func KeyToWorker(key string, workers int) int {
+	var h = fnv.New32a()
+	_, _ = h.Write([]byte(key)) // error is always nil
+	return int(h.Sum32() % uint32(max(1, workers)))
+}
+
+func (c Concurrency) queueSize() int {
+	if c.QueueSize <= 0 {
+		return QueueSizeDefault
+	}
+	return c.QueueSize
+}
+
+// queueToSeq yields the queued records until closed or canceled.
+func queueToSeq(
+	ctx context.Context,
+	q <-chan map[string]any,
+) iter.Seq2[map[string]any, error] {
+	return func(yield func(map[string]any, error) bool) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case row, ok := <-q:
+				if !ok || !yield(row, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func dispatch(
+	ctx context.Context,
+	m iter.Seq2[map[string]any, error],
+	map2pk MapToPrimaryKey,
+	wtr PrimaryKeyWriter,
+	queues []chan map[string]any,
+) error {
+	for row, e := range m {
+		if nil != e {
+			return e
+		}
+
+		key, e := map2pk(row)(wtr)(ctx)
+		if nil != e {
+			return e
+		}
+
+		// the decoder may reuse the map
+		var cloned map[string]any = maps.Clone(row)
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case queues[KeyToWorker(key, len(queues))] <- cloned:
+		}
+	}
+	return nil
+}
+
+// closeAll closes the savers by saving no records.
+func closeAll(ctx context.Context, savers []RecordsSaver) error {
+	var empty iter.Seq2[map[string]any, error] = func(
+		_ func(map[string]any, error) bool,
+	) {
+	}
+	var errs []error
+	for _, saver := range savers {
+		_, e := saver(empty, nil, nil)(ctx)
+		errs = append(errs, e)
+	}
+	return errors.Join(errs...)
+}
+
+// ToRecordsSaver creates a saver which saves the records using the workers.
+//
+// The first error(of the input or a worker) cancels the others; the savers
+// of the workers are closed even if canceled.
+func (c Concurrency) ToRecordsSaver(newSaver SaverFactory) RecordsSaver {
+	return func(
+		m iter.Seq2[map[string]any, error],
+		map2pk MapToPrimaryKey,
+		wtr PrimaryKeyWriter,
+	) IO[Void] {
+		return func(parent context.Context) (Void, error) {
+			ctx, cancel := context.WithCancelCause(parent)
+			defer cancel(nil)
+
+			var workers int = max(1, c.Workers)
+			var savers []RecordsSaver = make([]RecordsSaver, 0, workers)
+			for i := range workers {
+				saver, e := newSaver(i)(ctx)
+				if nil != e {
+					return Empty, errors.Join(e, closeAll(ctx, savers))
+				}
+				savers = append(savers, saver)
+			}
+
+			var queues []chan map[string]any = make(
+				[]chan map[string]any,
+				workers,
+			)
+			var errs []error = make([]error, workers)
+			var wg sync.WaitGroup
+			for i, saver := range savers {
+				queues[i] = make(chan map[string]any, c.queueSize())
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, e := saver(queueToSeq(ctx, queues[i]), map2pk, wtr)(ctx)
+					if nil != e {
+						errs[i] = fmt.Errorf("worker %v: %w", i, e)
+						cancel(errs[i])
+					}
+				}()
+			}
+
+			e := dispatch(ctx, m, map2pk, wtr, queues)
+			if nil != e {
+				cancel(e)
+			}
+			for _, q := range queues {
+				close(q)
+			}
+			wg.Wait()
+
+			if nil == ctx.Err() {
+				return Empty, errors.Join(errs...)
+			}
+
+			// the first error and the errors of the other workers(e.g, close)
+			var cause error = context.Cause(ctx)
+			var rest []error = []error{cause}
+			for _, err := range errs {
+				if nil != err && cause != err && !errors.Is(err, context.Canceled) {
+					rest = append(rest, err)
+				}
+			}
+			return Empty, errors.Join(rest...)
+		}
+	}
+}
+
+// WithCloser creates a saver which calls the closer after saving all
+// records(even if canceled).
+func (s RecordsSaver) WithCloser(closer func() error) RecordsSaver {
+	return func(
+		m iter.Seq2[map[string]any, error],
+		map2pk MapToPrimaryKey,
+		wtr PrimaryKeyWriter,
+	) IO[Void] {
+		return func(ctx context.Context) (Void, error) {
+			_, e := s(m, map2pk, wtr)(ctx)
+			return Empty, errors.Join(e, closer())
+		}
+	}
+}
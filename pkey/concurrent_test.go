@@ -0,0 +1,229 @@
+package pkey_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+	. "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/util"
+
+	dh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/dec/hamba"
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+	pk "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/pkey"
+)
+
+const testSchema string = `{
+	"type": "record",
+	"name": "Row",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "seq", "type": "long"}
+	]
+}`
+
+// testRows yields the rows of the keys(0, 1, ..., keys-1, 0, 1, ...).
+func testRows(n int, keys int) iter.Seq2[map[string]any, error] {
+	return func(yield func(map[string]any, error) bool) {
+		// the decoder may reuse the map
+		var row map[string]any = map[string]any{}
+		for i := range n {
+			row["id"] = int64(i % keys)
+			row["seq"] = int64(i)
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+// endlessRows yields the rows until the consumer stops.
+func endlessRows(yield func(map[string]any, error) bool) {
+	for i := int64(0); yield(map[string]any{"id": i % 8, "seq": i}, nil); i++ {
+	}
+}
+
+var errSave error = errors.New("save failed")
+
+// saved keeps the saved sequences by the keys.
+type saved struct {
+	mu      sync.Mutex
+	seqs    map[string][]int64
+	workers map[string]int
+	closed  atomic.Int32
+}
+
+func (s *saved) factory(fail string) pk.SaverFactory {
+	return func(worker int) IO[pk.RecordsSaver] {
+		var saver pk.RecordSaver = func(
+			key pk.PrimaryKey,
+			wtr pk.PrimaryKeyWriter,
+			m map[string]any,
+		) IO[Void] {
+			return func(ctx context.Context) (Void, error) {
+				k, e := key(wtr)(ctx)
+				if nil != e {
+					return Empty, e
+				}
+				if fail == k {
+					return Empty, errSave
+				}
+
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				s.seqs[k] = append(s.seqs[k], m["seq"].(int64))
+				prev, found := s.workers[k]
+				if found && prev != worker {
+					return Empty, errors.New("key saved by two workers")
+				}
+				s.workers[k] = worker
+				return Empty, nil
+			}
+		}
+		return Of(saver.WithCloser(func() error {
+			s.closed.Add(1)
+			return nil
+		}))
+	}
+}
+
+func savedNew() *saved {
+	return &saved{seqs: map[string][]int64{}, workers: map[string]int{}}
+}
+
+func TestConcurrencyOrder(t *testing.T) {
+	var s *saved = savedNew()
+	var c pk.Concurrency = pk.Concurrency{Workers: 4, QueueSize: 3}
+	_, e := c.ToRecordsSaver(s.factory(""))(
+		testRows(1000, 10),
+		pk.MapToKeyNew("id"),
+		&pk.StringKeyWriterDefault,
+	)(context.Background())
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	if 10 != len(s.seqs) || 4 != s.closed.Load() {
+		t.Fatalf("keys: %v, closed: %v", len(s.seqs), s.closed.Load())
+	}
+	for key, seqs := range s.seqs {
+		if 100 != len(seqs) {
+			t.Fatalf("%s: %v records", key, len(seqs))
+		}
+		// the records of a key are saved in the input order
+		for i, seq := range seqs[1:] {
+			if seq <= seqs[i] {
+				t.Fatalf("%s: unordered: %v", key, seqs)
+			}
+		}
+	}
+}
+
+// The first error of a worker cancels the input and the other workers; all
+// the savers are closed.
+func TestConcurrencyWorkerError(t *testing.T) {
+	var s *saved = savedNew()
+	var c pk.Concurrency = pk.Concurrency{Workers: 4}
+	_, e := c.ToRecordsSaver(s.factory("0000000000000003"))(
+		endlessRows,
+		pk.MapToKeyNew("id"),
+		&pk.StringKeyWriterDefault,
+	)(context.Background())
+	if !errors.Is(e, errSave) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if errors.Is(e, context.Canceled) {
+		t.Fatalf("cancellation reported: %v", e)
+	}
+	if 4 != s.closed.Load() {
+		t.Fatalf("closed: %v", s.closed.Load())
+	}
+}
+
+func TestConcurrencyInputError(t *testing.T) {
+	var errInput error = errors.New("invalid input")
+	var s *saved = savedNew()
+	var c pk.Concurrency = pk.Concurrency{Workers: 2}
+	_, e := c.ToRecordsSaver(s.factory(""))(
+		func(yield func(map[string]any, error) bool) {
+			_ = yield(map[string]any{"id": int64(1), "seq": int64(0)}, nil) &&
+				yield(nil, errInput)
+		},
+		pk.MapToKeyNew("id"),
+		&pk.StringKeyWriterDefault,
+	)(context.Background())
+	if !errors.Is(e, errInput) || 2 != s.closed.Load() {
+		t.Fatalf("unexpected error: %v, closed: %v", e, s.closed.Load())
+	}
+}
+
+// The workers share the sink, the stats and the limiter(run with -race).
+func TestConcurrencySharedSinks(t *testing.T) {
+	var sink *eh.MemSink = eh.MemSinkNew()
+	var stats *eh.Stats = eh.StatsNew()
+	var fc eh.FsConfig = eh.FsConfig{
+		Config: eh.Config{
+			Schema:       testSchema,
+			EncodeConfig: bp.EncodeConfigDefault,
+		},
+		Sink:            sink,
+		StatObserver:    stats.ToObserver(),
+		DigestAlgorithm: eh.DigestSha256,
+		Digests:         eh.DigestStatesNew(),
+		Limiter:         eh.Limits{MaxPartitions: 16}.ToLimiter(""),
+	}
+
+	var c pk.Concurrency = pk.Concurrency{Workers: 4, QueueSize: 1}
+	_, e := c.ToRecordsSaver(func(_ int) IO[pk.RecordsSaver] {
+		// the evicted partitions are reopened
+		pool, e := fc.ToPool(2)
+		if nil != e {
+			return Err[pk.RecordsSaver](e)
+		}
+		var saver pk.RecordSaver = pool.ToSaver(eh.KeyAsFilename)
+		return Of(saver.WithCloser(pool.Close))
+	})(
+		testRows(800, 16),
+		pk.MapToKeyNew("id"),
+		&pk.StringKeyWriterDefault,
+	)(context.Background())
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	var names []string = sink.Names()
+	if 16 != len(names) {
+		t.Fatalf("partitions: %v", names)
+	}
+	for _, name := range names {
+		data, _ := sink.Get(name)
+		var prev int64 = -1
+		var records int
+		for row, e := range dh.ReaderToMaps(
+			bytes.NewReader(data),
+			bp.DecodeConfigDefault,
+		) {
+			if nil != e {
+				t.Fatal(e)
+			}
+			var seq int64 = row["seq"].(int64)
+			var key string = fmt.Sprintf("%016x", row["id"].(int64))
+			if seq <= prev || name != key {
+				t.Fatalf("%s: unexpected row: %v", name, row)
+			}
+			prev = seq
+			records++
+		}
+		if 50 != records {
+			t.Fatalf("%s: %v records", name, records)
+		}
+	}
+	if 800 != stats.ByCodec[bp.CodecNull].Records {
+		t.Fatalf("unexpected stats: %v", stats.ByCodec)
+	}
+}
@@ -67,6 +67,30 @@ func (s RecordSaver) SaveAll(
 	}
 }
 
+// RecordsSaver saves all the records.
+type RecordsSaver func(
+	iter.Seq2[map[string]any, error],
+	MapToPrimaryKey,
+	PrimaryKeyWriter,
+) IO[Void]
+
+func (s RecordSaver) ToRecordsSaver() RecordsSaver { return s.SaveAll }
+
+// WithCloser creates a saver which calls the closer after saving all
+// records(even if canceled).
+func (s RecordSaver) WithCloser(closer func() error) RecordsSaver {
+	return func(
+		m iter.Seq2[map[string]any, error],
+		map2pk MapToPrimaryKey,
+		wtr PrimaryKeyWriter,
+	) IO[Void] {
+		return func(ctx context.Context) (Void, error) {
+			_, e := s.SaveAll(m, map2pk, wtr)(ctx)
+			return Empty, errors.Join(e, closer())
+		}
+	}
+}
+
 func MapToKeyNew(keyname string) MapToPrimaryKey {
 	return func(m map[string]any) PrimaryKey {
 		val, found := m[keyname]
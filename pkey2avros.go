@@ -1,5 +1,15 @@
 package pkey2avros
 
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownCodec          error = errors.New("unknown codec")
+	ErrUnknownCodecSelection error = errors.New("unknown codec selection")
+)
+
 const BlobSizeMaxDefault int = 1048576
 
 type DecodeConfig struct {
@@ -21,6 +31,9 @@ const (
 	CodecXz      Codec = "xz"
 )
 
+// CodecFromString converts the name to the codec(null for unknown names).
+//
+// Use ParseCodec to reject unknown names.
 func CodecFromString(s string) Codec {
 	switch s {
 	case "deflate":
@@ -38,14 +51,89 @@ func CodecFromString(s string) Codec {
 	}
 }
 
+// ParseCodec converts the name to the codec.
+//
+// An empty name is the null codec.
+func ParseCodec(s string) (Codec, error) {
+	switch s {
+	case "", "null":
+		return CodecNull, nil
+	case "deflate", "snappy", "zstandard", "bzip2", "xz":
+		return CodecFromString(s), nil
+	default:
+		return CodecNull, fmt.Errorf("%w: %s", ErrUnknownCodec, s)
+	}
+}
+
 const BlockLengthDefault int = 100
 
+// CompressionLevelDefault uses the default level of the codec.
+const CompressionLevelDefault int = 0
+
+// CodecSelection decides the codec of each partition file.
+type CodecSelection string
+
+const (
+	// CodecSelectionFixed always uses the Codec.
+	CodecSelectionFixed CodecSelection = "fixed"
+
+	// CodecSelectionSize uses the SmallCodec if the (uncompressed) partition
+	// is smaller than the Threshold; the blocks are kept until the Threshold
+	// is reached.
+	CodecSelectionSize CodecSelection = "size"
+
+	// CodecSelectionTrial compresses the first block using both codecs and
+	// uses the one which produced the smaller output.
+	CodecSelectionTrial CodecSelection = "trial"
+)
+
+func StringToCodecSelection(s string) (CodecSelection, error) {
+	switch s {
+	case "", "fixed":
+		return CodecSelectionFixed, nil
+	case "size":
+		return CodecSelectionSize, nil
+	case "trial":
+		return CodecSelectionTrial, nil
+	default:
+		return CodecSelectionFixed, fmt.Errorf(
+			"%w: %s", ErrUnknownCodecSelection, s,
+		)
+	}
+}
+
+const AdaptiveThresholdDefault int = 4096
+
+type AdaptiveConfig struct {
+	CodecSelection
+
+	// The codec for small partitions(default compression level).
+	SmallCodec Codec
+
+	// The size of the partition in bytes.
+	Threshold int
+}
+
+var AdaptiveConfigDefault AdaptiveConfig = AdaptiveConfig{
+	CodecSelection: CodecSelectionFixed,
+	SmallCodec:     CodecNull,
+	Threshold:      AdaptiveThresholdDefault,
+}
+
 type EncodeConfig struct {
 	BlockLength int
 	Codec
+
+	// Compression level for deflate(1-9), zstandard(1-22) and bzip2(1-9).
+	// Ignored by other codecs.
+	CompressionLevel int
+
+	Adaptive AdaptiveConfig
 }
 
 var EncodeConfigDefault EncodeConfig = EncodeConfig{
-	BlockLength: BlockLengthDefault,
-	Codec:       CodecNull,
+	BlockLength:      BlockLengthDefault,
+	Codec:            CodecNull,
+	CompressionLevel: CompressionLevelDefault,
+	Adaptive:         AdaptiveConfigDefault,
 }
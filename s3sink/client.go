@@ -0,0 +1,406 @@
+// Package s3sink writes partitions to S3-compatible object storage.
+package s3sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoBucket       error = errors.New("bucket missing")
+	ErrObjectNotFound error = errors.New("object not found")
+	ErrUnexpectedResp error = errors.New("unexpected response")
+)
+
+const RegionDefault string = "us-east-1"
+
+// 5 MiB is the minimum size of a part(except the last part).
+const (
+	PartSizeMin     int64 = 5 * 1024 * 1024
+	PartSizeDefault int64 = 8 * 1024 * 1024
+)
+
+type Credentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Config describes the bucket to be written.
+type Config struct {
+	// e.g, http://localhost:9000 (empty: https://s3.<region>.amazonaws.com)
+	Endpoint string
+
+	Region string
+	Bucket string
+
+	// Prepended to the object keys.
+	Prefix string
+
+	// The local output root removed from the partition paths(e.g,
+	// root/ab/key.avro -> prefix/ab/key.avro).
+	Root string
+
+	// Uses endpoint/bucket/key instead of bucket.endpoint/key if true.
+	PathStyle bool
+
+	Credentials
+
+	// Partitions larger than this will be uploaded using multipart uploads.
+	PartSize int64
+
+	// nil: http.DefaultClient
+	Client *http.Client
+
+	// nil: time.Now
+	Clock func() time.Time
+}
+
+// Client sends the signed requests to the bucket.
+type Client struct {
+	Config
+	endpoint *url.URL
+}
+
+func (c Config) ToClient() (*Client, error) {
+	if "" == c.Bucket {
+		return nil, ErrNoBucket
+	}
+	if "" == c.Region {
+		c.Region = RegionDefault
+	}
+	if "" == c.Endpoint {
+		c.Endpoint = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	switch {
+	case c.PartSize <= 0:
+		c.PartSize = PartSizeDefault
+	case c.PartSize < PartSizeMin:
+		c.PartSize = PartSizeMin
+	}
+	if nil == c.Client {
+		c.Client = http.DefaultClient
+	}
+	if nil == c.Clock {
+		c.Clock = time.Now
+	}
+
+	parsed, e := url.Parse(c.Endpoint)
+	if nil != e {
+		return nil, e
+	}
+	return &Client{Config: c, endpoint: parsed}, nil
+}
+
+// relPath removes the root from the path(paths outside the root are kept).
+func (c *Client) relPath(path string) string {
+	if "" == c.Root {
+		return path
+	}
+	rel, e := filepath.Rel(c.Root, path)
+	if nil != e || !filepath.IsLocal(rel) {
+		return path
+	}
+	return rel
+}
+
+// ObjectKey converts the partition path to the key of the object.
+func (c *Client) ObjectKey(path string) string {
+	var trimmed string = strings.TrimPrefix(
+		strings.TrimPrefix(
+			strings.ReplaceAll(c.relPath(path), "\\", "/"),
+			"./",
+		),
+		"/",
+	)
+	switch c.Prefix {
+	case "":
+		return trimmed
+	default:
+		return strings.TrimSuffix(c.Prefix, "/") + "/" + trimmed
+	}
+}
+
+// uriEncode encodes the string as required by the signature version 4.
+func uriEncode(s string, keepSlash bool) string {
+	var buf strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			'-' == b, '.' == b, '_' == b, '~' == b:
+			_ = buf.WriteByte(b) // error is always nil or OOM
+		case '/' == b && keepSlash:
+			_ = buf.WriteByte(b) // error is always nil or OOM
+		default:
+			_, _ = fmt.Fprintf(&buf, "%%%02X", b) // error is always nil or OOM
+		}
+	}
+	return buf.String()
+}
+
+func canonicalQuery(q url.Values) string {
+	var pairs []string
+	for _, key := range slices.Sorted(maps.Keys(q)) {
+		for _, val := range q[key] {
+			pairs = append(pairs, uriEncode(key, false)+"="+uriEncode(val, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	var h = hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data)) // never returns an error
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	var d [32]byte = sha256.Sum256(data)
+	return hex.EncodeToString(d[:])
+}
+
+func (c *Client) objectUrl(key string, q url.Values) (host string, u string) {
+	var path string = "/" + c.Bucket + "/" + key
+	host = c.endpoint.Host
+	if !c.PathStyle {
+		host = c.Bucket + "." + host
+		path = "/" + key
+	}
+	u = c.endpoint.Scheme + "://" + host + uriEncode(path, true)
+	if 0 < len(q) {
+		u += "?" + canonicalQuery(q)
+	}
+	return host, u
+}
+
+// sign adds the signature version 4 headers.
+func (c *Client) sign(req *http.Request, path string, body []byte) {
+	var now time.Time = c.Clock().UTC()
+	var amzDate string = now.Format("20060102T150405Z")
+	var date string = now.Format("20060102")
+	var payloadHash string = sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if "" != c.SessionToken {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	var names []string = []string{"host"}
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	slices.Sort(names)
+
+	var headers strings.Builder
+	for _, name := range names {
+		var val string = req.Host
+		if "host" != name {
+			val = strings.TrimSpace(req.Header.Get(name))
+		}
+		_, _ = headers.WriteString(name + ":" + val + "\n") // nil or OOM
+	}
+	var signed string = strings.Join(names, ";")
+
+	var canonical string = strings.Join([]string{
+		req.Method,
+		uriEncode(path, true),
+		canonicalQuery(req.URL.Query()),
+		headers.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+
+	var scope string = date + "/" + c.Region + "/s3/aws4_request"
+	var toSign string = strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonical)),
+	}, "\n")
+
+	var key []byte = []byte("AWS4" + c.SecretAccessKey)
+	for _, part := range []string{date, c.Region, "s3", "aws4_request"} {
+		key = hmacSha256(key, part)
+	}
+	var signature string = hex.EncodeToString(hmacSha256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKeyId,
+		scope,
+		signed,
+		signature,
+	))
+}
+
+// Do sends the signed request and returns the body of a 2xx response.
+func (c *Client) Do(
+	ctx context.Context,
+	method string,
+	key string,
+	q url.Values,
+	body []byte,
+) ([]byte, http.Header, error) {
+	host, u := c.objectUrl(key, q)
+	req, e := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if nil != e {
+		return nil, nil, e
+	}
+	req.Host = host
+	req.ContentLength = int64(len(body))
+
+	var path string = "/" + c.Bucket + "/" + key
+	if !c.PathStyle {
+		path = "/" + key
+	}
+	c.sign(req, path, body)
+
+	res, e := c.Client.Do(req)
+	if nil != e {
+		return nil, nil, e
+	}
+	defer res.Body.Close()
+
+	content, e := io.ReadAll(res.Body)
+	if nil != e {
+		return nil, nil, e
+	}
+
+	switch {
+	case http.StatusNotFound == res.StatusCode:
+		return nil, res.Header, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	case res.StatusCode < 200 || 300 <= res.StatusCode:
+		return nil, res.Header, fmt.Errorf(
+			"%w: %s %s: %s: %s",
+			ErrUnexpectedResp, method, key, res.Status, content,
+		)
+	default:
+		return content, res.Header, nil
+	}
+}
+
+func (c *Client) PutObject(ctx context.Context, key string, data []byte) error {
+	_, _, e := c.Do(ctx, http.MethodPut, key, nil, data)
+	return e
+}
+
+func (c *Client) GetObject(ctx context.Context, key string) ([]byte, error) {
+	data, _, e := c.Do(ctx, http.MethodGet, key, nil, nil)
+	return data, e
+}
+
+// Exists returns true if the object exists.
+func (c *Client) Exists(ctx context.Context, key string) (bool, error) {
+	_, _, e := c.Do(ctx, http.MethodHead, key, nil, nil)
+	switch {
+	case nil == e:
+		return true, nil
+	case errors.Is(e, ErrObjectNotFound):
+		return false, nil
+	default:
+		return false, e
+	}
+}
+
+type initiateResult struct {
+	UploadId string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// Multipart is an ongoing multipart upload.
+type Multipart struct {
+	client   *Client
+	key      string
+	uploadId string
+	parts    []completedPart
+}
+
+func (c *Client) CreateMultipart(
+	ctx context.Context,
+	key string,
+) (*Multipart, error) {
+	res, _, e := c.Do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if nil != e {
+		return nil, e
+	}
+
+	var parsed initiateResult
+	e = xml.Unmarshal(res, &parsed)
+	if nil != e {
+		return nil, e
+	}
+	return &Multipart{client: c, key: key, uploadId: parsed.UploadId}, nil
+}
+
+func (m *Multipart) UploadPart(ctx context.Context, data []byte) error {
+	var number int = len(m.parts) + 1
+	_, header, e := m.client.Do(
+		ctx,
+		http.MethodPut,
+		m.key,
+		url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {m.uploadId},
+		},
+		data,
+	)
+	if nil != e {
+		return e
+	}
+	m.parts = append(m.parts, completedPart{
+		PartNumber: number,
+		ETag:       header.Get("ETag"),
+	})
+	return nil
+}
+
+func (m *Multipart) Complete(ctx context.Context) error {
+	body, e := xml.Marshal(completeUpload{Parts: m.parts})
+	if nil != e {
+		return e
+	}
+	_, _, e = m.client.Do(
+		ctx,
+		http.MethodPost,
+		m.key,
+		url.Values{"uploadId": {m.uploadId}},
+		body,
+	)
+	return e
+}
+
+func (m *Multipart) Abort(ctx context.Context) error {
+	_, _, e := m.client.Do(
+		ctx,
+		http.MethodDelete,
+		m.key,
+		url.Values{"uploadId": {m.uploadId}},
+		nil,
+	)
+	return e
+}
@@ -0,0 +1,142 @@
+package s3sink
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FakeServer is an in-memory S3-compatible server for tests.
+//
+// Only the path-style requests used by the Sink are supported. The
+// signatures are not verified.
+type FakeServer struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	next    int
+}
+
+func FakeServerNew() *FakeServer {
+	return &FakeServer{
+		objects: map[string][]byte{},
+		uploads: map[string]map[int][]byte{},
+	}
+}
+
+// Get returns the object stored as bucket/key.
+func (f *FakeServer) Get(path string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, found := f.objects[path]
+	return slices.Clone(data), found
+}
+
+// Names returns the sorted bucket/key of the objects.
+func (f *FakeServer) Names() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Sorted(maps.Keys(f.objects))
+}
+
+// Uploads returns the number of the ongoing multipart uploads.
+func (f *FakeServer) Uploads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.uploads)
+}
+
+func etag(data []byte) string {
+	var d [16]byte = md5.Sum(data)
+	return `"` + hex.EncodeToString(d[:]) + `"`
+}
+
+func (f *FakeServer) complete(uploadId string, body []byte) (int, []byte) {
+	var parsed completeUpload
+	e := xml.Unmarshal(body, &parsed)
+	if nil != e {
+		return http.StatusBadRequest, nil
+	}
+
+	parts, found := f.uploads[uploadId]
+	if !found {
+		return http.StatusNotFound, nil
+	}
+
+	var data []byte
+	for _, part := range parsed.Parts {
+		content, found := parts[part.PartNumber]
+		if !found || etag(content) != part.ETag {
+			return http.StatusBadRequest, nil
+		}
+		data = append(data, content...)
+	}
+	delete(f.uploads, uploadId)
+	return http.StatusOK, data
+}
+
+func (f *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, e := io.ReadAll(r.Body)
+	if nil != e {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var path string = strings.TrimPrefix(r.URL.Path, "/")
+	var q = r.URL.Query()
+	var uploadId string = q.Get("uploadId")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case http.MethodPut == r.Method && "" != uploadId:
+		parts, found := f.uploads[uploadId]
+		number, e := strconv.Atoi(q.Get("partNumber"))
+		if !found || nil != e {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		parts[number] = body
+		w.Header().Set("ETag", etag(body))
+	case http.MethodPut == r.Method:
+		f.objects[path] = body
+		w.Header().Set("ETag", etag(body))
+	case http.MethodGet == r.Method, http.MethodHead == r.Method:
+		data, found := f.objects[path]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if http.MethodGet == r.Method {
+			_, _ = w.Write(data) // the client may be gone
+		}
+	case http.MethodPost == r.Method && q.Has("uploads"):
+		f.next++
+		var id string = strconv.Itoa(f.next)
+		f.uploads[id] = map[int][]byte{}
+		res, _ := xml.Marshal(struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadId string   `xml:"UploadId"`
+		}{UploadId: id}) // never fails
+		_, _ = w.Write(res) // the client may be gone
+	case http.MethodPost == r.Method && "" != uploadId:
+		status, data := f.complete(uploadId, body)
+		if http.StatusOK == status {
+			f.objects[path] = data
+		}
+		w.WriteHeader(status)
+	case http.MethodDelete == r.Method && "" != uploadId:
+		delete(f.uploads, uploadId)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,163 @@
+package s3sink
+
+import (
+	"context"
+	"errors"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+var ErrUnsupportedPolicy error = eh.ErrUnsupportedPolicy
+
+// Sink uploads each partition as an object.
+//
+// The partitions are streamed using multipart uploads unless they are small.
+// Appended partitions(ExistAppend or reopened partitions) are buffered
+// entirely and uploaded again on commit.
+type Sink struct {
+	*Client
+	eh.ExistPolicy
+
+	// nil: context.Background()
+	Ctx context.Context
+}
+
+func (c *Client) ToSink(policy eh.ExistPolicy) (Sink, error) {
+	e := policy.CheckValue()
+	if nil != e {
+		return Sink{}, e
+	}
+	return Sink{Client: c, ExistPolicy: policy}, nil
+}
+
+func (s Sink) ctx() context.Context {
+	if nil == s.Ctx {
+		return context.Background()
+	}
+	return s.Ctx
+}
+
+// streamPartition uploads the full parts while written.
+type streamPartition struct {
+	sink Sink
+	name string
+	key  string
+	buf  []byte
+	mp   *Multipart
+}
+
+func (p *streamPartition) Name() string { return p.name }
+
+func (p *streamPartition) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	for p.sink.PartSize <= int64(len(p.buf)) {
+		if nil == p.mp {
+			mp, e := p.sink.CreateMultipart(p.sink.ctx(), p.key)
+			if nil != e {
+				return 0, e
+			}
+			p.mp = mp
+		}
+
+		e := p.mp.UploadPart(p.sink.ctx(), p.buf[:p.sink.PartSize])
+		if nil != e {
+			return 0, e
+		}
+		p.buf = append(p.buf[:0], p.buf[p.sink.PartSize:]...)
+	}
+	return len(data), nil
+}
+
+func (p *streamPartition) Commit() error {
+	if nil == p.mp {
+		return p.sink.PutObject(p.sink.ctx(), p.key, p.buf)
+	}
+	if 0 < len(p.buf) {
+		e := p.mp.UploadPart(p.sink.ctx(), p.buf)
+		if nil != e {
+			return errors.Join(e, p.Abort())
+		}
+	}
+	return p.mp.Complete(p.sink.ctx())
+}
+
+func (p *streamPartition) Abort() error {
+	if nil == p.mp {
+		return nil
+	}
+	return p.mp.Abort(p.sink.ctx())
+}
+
+// bufferedPartition is an in-memory seekable object.
+type bufferedPartition struct {
+	eh.MemFile
+	stream streamPartition
+}
+
+func (b *bufferedPartition) Name() string { return b.stream.name }
+func (b *bufferedPartition) Abort() error { return b.stream.Abort() }
+
+// Commit uploads the whole object.
+func (b *bufferedPartition) Commit() error {
+	_, e := b.stream.Write(b.Data)
+	if nil != e {
+		return errors.Join(e, b.stream.Abort())
+	}
+	return b.stream.Commit()
+}
+
+func (s Sink) load(key string) ([]byte, bool, error) {
+	data, e := s.GetObject(s.ctx(), key)
+	if errors.Is(e, ErrObjectNotFound) {
+		return nil, false, nil
+	}
+	return data, nil == e, e
+}
+
+func (s Sink) exists(key string) (bool, error) {
+	return s.Exists(s.ctx(), key)
+}
+
+func (s Sink) buffered(path string, data []byte) *bufferedPartition {
+	return &bufferedPartition{
+		MemFile: eh.MemFile{Data: data},
+		stream:  streamPartition{sink: s, name: path, key: s.ObjectKey(path)},
+	}
+}
+
+func (s Sink) Create(path string) (eh.PartitionWriter, error) {
+	var key string = s.ObjectKey(path)
+	data, e := s.ExistPolicy.CreateValue(key, s.load, s.exists)
+	if nil != e {
+		return nil, e
+	}
+	if eh.ExistAppend == s.ExistPolicy {
+		return s.buffered(path, data), nil
+	}
+	return &streamPartition{sink: s, name: path, key: key}, nil
+}
+
+// Reopen downloads the object to append to it.
+func (s Sink) Reopen(name string) (eh.PartitionWriter, error) {
+	data, _, e := s.load(s.ObjectKey(name))
+	if nil != e {
+		return nil, e
+	}
+	return s.buffered(name, data), nil
+}
+
+// WriteFile uploads the file of the local path(e.g, a schema) as an object.
+func (s Sink) WriteFile(name string, data []byte) error {
+	return s.PutObject(s.ctx(), s.ObjectKey(name), data)
+}
+
+// WriteFileOnce uploads the file unless the object exists(e.g, a blob of
+// the content-addressed store).
+func (s Sink) WriteFileOnce(name string, data []byte) error {
+	var key string = s.ObjectKey(name)
+	found, e := s.Exists(s.ctx(), key)
+	if nil != e || found {
+		return e
+	}
+	return s.PutObject(s.ctx(), key, data)
+}
@@ -0,0 +1,275 @@
+package s3sink_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	bp "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey"
+
+	bs "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/blobstore"
+	s3 "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/s3sink"
+	ss "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/schemastore"
+
+	dh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/dec/hamba"
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+const root string = "/tmp/out"
+
+const testSchema string = `{
+	"type": "record",
+	"name": "Row",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "data", "type": "bytes"}
+	]
+}`
+
+func newSink(
+	t *testing.T,
+	policy eh.ExistPolicy,
+) (s3.Sink, *s3.FakeServer) {
+	t.Helper()
+	var fake *s3.FakeServer = s3.FakeServerNew()
+	var server *httptest.Server = httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, e := s3.Config{
+		Endpoint:  server.URL,
+		Bucket:    "bucket",
+		Prefix:    "pfx",
+		Root:      root,
+		PathStyle: true,
+		Credentials: s3.Credentials{
+			AccessKeyId:     "id",
+			SecretAccessKey: "secret",
+		},
+		PartSize: s3.PartSizeMin,
+	}.ToClient()
+	if nil != e {
+		t.Fatal(e)
+	}
+	sink, e := client.ToSink(policy)
+	if nil != e {
+		t.Fatal(e)
+	}
+	return sink, fake
+}
+
+func put(t *testing.T, sink eh.Sink, path string, data []byte) error {
+	t.Helper()
+	w, e := sink.Create(path)
+	if nil != e {
+		return e
+	}
+	_, e = w.Write(data)
+	if nil != e {
+		return errors.Join(e, w.Abort())
+	}
+	return w.Commit()
+}
+
+func TestObjectKey(t *testing.T) {
+	sink, _ := newSink(t, eh.ExistOverwrite)
+	var cases = map[string]string{
+		filepath.Join(root, "ab", "k.avro"): "pfx/ab/k.avro",
+		"/elsewhere/k.avro":                 "pfx/elsewhere/k.avro",
+		"k.avro":                            "pfx/k.avro",
+	}
+	for path, expected := range cases {
+		if expected != sink.ObjectKey(path) {
+			t.Errorf("%s: %s", path, sink.ObjectKey(path))
+		}
+	}
+}
+
+func TestSinkMultipart(t *testing.T) {
+	sink, fake := newSink(t, eh.ExistOverwrite)
+	var small []byte = []byte("small")
+	var large []byte = bytes.Repeat([]byte("0123456789"), 1200*1024)
+
+	for name, data := range map[string][]byte{"s.avro": small, "l.avro": large} {
+		e := put(t, sink, filepath.Join(root, name), data)
+		if nil != e {
+			t.Fatal(e)
+		}
+		stored, found := fake.Get("bucket/pfx/" + name)
+		if !found || !bytes.Equal(data, stored) {
+			t.Fatalf("%s: found=%v size=%v", name, found, len(stored))
+		}
+	}
+	if 0 != fake.Uploads() {
+		t.Fatalf("ongoing uploads: %v", fake.Uploads())
+	}
+}
+
+func TestSinkAbortMultipart(t *testing.T) {
+	sink, fake := newSink(t, eh.ExistOverwrite)
+	w, e := sink.Create(filepath.Join(root, "l.avro"))
+	if nil != e {
+		t.Fatal(e)
+	}
+	_, e = w.Write(bytes.Repeat([]byte{1}, int(s3.PartSizeMin)+1))
+	if nil != e {
+		t.Fatal(e)
+	}
+	if 1 != fake.Uploads() {
+		t.Fatalf("uploads: %v", fake.Uploads())
+	}
+	e = w.Abort()
+	if nil != e {
+		t.Fatal(e)
+	}
+	if 0 != fake.Uploads() || 0 != len(fake.Names()) {
+		t.Fatalf("uploads=%v objects=%v", fake.Uploads(), fake.Names())
+	}
+}
+
+func TestSinkExistPolicy(t *testing.T) {
+	var path string = filepath.Join(root, "k.avro")
+	for _, policy := range []eh.ExistPolicy{eh.ExistSkip, eh.ExistFail} {
+		sink, _ := newSink(t, policy)
+		e := put(t, sink, path, []byte("old"))
+		if nil != e {
+			t.Fatal(e)
+		}
+		e = put(t, sink, path, []byte("new"))
+		if nil == e {
+			t.Fatalf("%s: expected an error", policy)
+		}
+		var skipped bool = errors.Is(e, eh.ErrSkipPartition)
+		if skipped != (eh.ExistSkip == policy) {
+			t.Fatalf("%s: unexpected error: %v", policy, e)
+		}
+	}
+
+	// rejected before any request
+	client, e := s3.Config{Bucket: "bucket", Endpoint: "http://x"}.ToClient()
+	if nil != e {
+		t.Fatal(e)
+	}
+	_, e = client.ToSink(eh.ExistVersion)
+	if !errors.Is(e, s3.ErrUnsupportedPolicy) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	var direct s3.Sink = s3.Sink{Client: client, ExistPolicy: eh.ExistVersion}
+	_, e = direct.Create(path)
+	if !errors.Is(e, s3.ErrUnsupportedPolicy) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+}
+
+func TestSinkAppendPool(t *testing.T) {
+	sink, fake := newSink(t, eh.ExistAppend)
+	var fc eh.FsConfig = eh.FsConfig{
+		Config: eh.Config{
+			Schema:       testSchema,
+			EncodeConfig: bp.EncodeConfigDefault,
+		},
+		Sink: sink,
+	}
+	var name string = filepath.Join(root, "k.avro")
+
+	// two runs append to the same object
+	for range 2 {
+		pool, e := fc.ToPool(1)
+		if nil != e {
+			t.Fatal(e)
+		}
+		for i := range 3 {
+			var other string = filepath.Join(root, "o.avro")
+			for _, n := range []string{name, other} {
+				e = pool.WriteMap(
+					map[string]any{"id": int64(i), "data": []byte("d")},
+					n,
+					eh.PartitionHeader{},
+				)
+				if nil != e {
+					t.Fatal(e)
+				}
+			}
+		}
+		e = pool.Close()
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+
+	data, found := fake.Get("bucket/pfx/k.avro")
+	if !found {
+		t.Fatalf("objects: %v", fake.Names())
+	}
+	var count int
+	var rdr *bytes.Reader = bytes.NewReader(data)
+	for _, e := range dh.ReaderToMaps(rdr, bp.DecodeConfigDefault) {
+		if nil != e {
+			t.Fatal(e)
+		}
+		count++
+	}
+	if 6 != count {
+		t.Fatalf("records: %v", count)
+	}
+}
+
+func TestSinkStores(t *testing.T) {
+	sink, fake := newSink(t, eh.ExistOverwrite)
+
+	var blobs bs.Store = bs.Store{
+		Root:   filepath.Join(root, bs.DirnameDefault),
+		FanOut: bs.FanOutDefault,
+		Write:  sink.WriteFileOnce,
+	}
+	digest, e := blobs.Put([]byte("blob"))
+	if nil != e {
+		t.Fatal(e)
+	}
+	_, e = blobs.Put([]byte("blob"))
+	if nil != e {
+		t.Fatal(e)
+	}
+	var key string = "bucket/pfx/_blobs/" + digest[:2] + "/" + digest[2:4] +
+		"/" + digest
+	stored, found := fake.Get(key)
+	if !found || "blob" != string(stored) {
+		t.Fatalf("objects: %v", fake.Names())
+	}
+
+	var schemas ss.Store = ss.Store{
+		Root:  filepath.Join(root, ss.DirnameDefault),
+		Write: sink.WriteFile,
+	}
+	fc, e := eh.FsConfig{
+		Config: eh.Config{
+			Schema:       testSchema,
+			EncodeConfig: bp.EncodeConfigDefault,
+			OutputFormat: eh.OutputRaw,
+		},
+		Sink: sink,
+	}.WithSchemaStore(schemas)
+	if nil != e {
+		t.Fatal(e)
+	}
+	e = fc.WriteMap(
+		map[string]any{"id": int64(1), "data": []byte("d")},
+		filepath.Join(root, "k.bin"),
+	)
+	if nil != e {
+		t.Fatal(e)
+	}
+
+	var names []string = fake.Names()
+	for _, expected := range []string{
+		"bucket/pfx/_schemas/CURRENT",
+		"bucket/pfx/k.bin",
+		"bucket/pfx/k.bin.fp",
+	} {
+		_, found := fake.Get(expected)
+		if !found {
+			t.Errorf("%s not found: %v", expected, names)
+		}
+	}
+}
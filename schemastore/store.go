@@ -0,0 +1,240 @@
+// Package schemastore stores schemas by their CRC-64-AVRO fingerprint.
+package schemastore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	ha "github.com/hamba/avro/v2"
+)
+
+var (
+	ErrSchemaNotFound     error = errors.New("schema not found")
+	ErrInvalidFingerprint error = errors.New("invalid fingerprint")
+)
+
+const DirnameDefault string = "_schemas"
+
+// The name of the file which keeps the fingerprint of the schema used by
+// the partitions without any header(e.g, raw datums).
+//
+// The partitions of a later run may use another schema; the fingerprint of
+// each partition is kept in its sidecar(see PartitionFingerprintName).
+const CurrentName string = "CURRENT"
+
+// The extension of the sidecar which keeps the fingerprint of a partition.
+const FingerprintExt string = ".fp"
+
+const SchemaExt string = ".avsc"
+
+// SingleObjectMarker is the first 2 bytes of the single object encoding
+// which is followed by the little-endian fingerprint.
+var SingleObjectMarker [2]byte = [2]byte{0xc3, 0x01}
+
+// Fingerprint is the CRC-64-AVRO(Rabin) fingerprint of a schema.
+type Fingerprint uint64
+
+func FingerprintOf(s ha.Schema) (Fingerprint, error) {
+	fp, e := s.FingerprintUsing(ha.CRC64Avro)
+	if nil != e {
+		return 0, e
+	}
+
+	// hamba returns the fingerprint as big-endian bytes
+	return Fingerprint(binary.BigEndian.Uint64(fp)), nil
+}
+
+// String returns the fingerprint as 16 hex chars.
+func (f Fingerprint) String() string { return fmt.Sprintf("%016x", uint64(f)) }
+
+func StringToFingerprint(s string) (Fingerprint, error) {
+	u, e := strconv.ParseUint(s, 16, 64)
+	if nil != e || 16 != len(s) {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidFingerprint, s)
+	}
+	return Fingerprint(u), nil
+}
+
+// Store keeps each schema once as a file named by its fingerprint.
+//
+// e.g, root/0123456789abcdef.avsc
+type Store struct {
+	Root string
+
+	Sync func(*os.File) error
+
+	// Writes the file elsewhere(nil: local files), e.g, to a bucket.
+	Write func(name string, data []byte) error
+}
+
+func (s Store) sync(f *os.File) error {
+	if nil == s.Sync {
+		return nil
+	}
+	return s.Sync(f)
+}
+
+// SchemaPath returns the path of the schema.
+func (s Store) SchemaPath(fp Fingerprint) string {
+	return filepath.Join(s.Root, fp.String()+SchemaExt)
+}
+
+// writeFile writes the file using a temporary file.
+func (s Store) writeFile(name string, data []byte) error {
+	if nil != s.Write {
+		return s.Write(name, data)
+	}
+	return writeFile(name, data, s.sync)
+}
+
+func writeFile(name string, data []byte, sync func(*os.File) error) error {
+	var dir string = filepath.Dir(name)
+	e := os.MkdirAll(dir, 0o755)
+	if nil != e {
+		return e
+	}
+
+	tmp, e := os.CreateTemp(dir, filepath.Base(name)+".tmp.*")
+	if nil != e {
+		return e
+	}
+	_, e = tmp.Write(data)
+	e = errors.Join(e, sync(tmp), tmp.Close())
+	if nil == e {
+		e = os.Rename(tmp.Name(), name)
+	}
+	if nil != e {
+		return errors.Join(e, os.Remove(tmp.Name()))
+	}
+	return nil
+}
+
+// Put stores the schema unless it is already stored.
+func (s Store) Put(schema ha.Schema) (Fingerprint, error) {
+	fp, e := FingerprintOf(schema)
+	if nil != e {
+		return 0, e
+	}
+
+	var name string = s.SchemaPath(fp)
+	_, e = os.Stat(name)
+	if nil == e {
+		return fp, nil
+	}
+
+	return fp, s.writeFile(name, []byte(schema.String()))
+}
+
+// Get parses the stored schema.
+func (s Store) Get(fp Fingerprint) (ha.Schema, error) {
+	content, e := os.ReadFile(s.SchemaPath(fp))
+	if errors.Is(e, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSchemaNotFound, fp)
+	}
+	if nil != e {
+		return nil, e
+	}
+	return ha.ParseBytes(content)
+}
+
+// SetCurrent saves the fingerprint of the schema of the headerless
+// partitions.
+func (s Store) SetCurrent(fp Fingerprint) error {
+	return s.writeFile(
+		filepath.Join(s.Root, CurrentName),
+		[]byte(fp.String()+"\n"),
+	)
+}
+
+// Current returns the fingerprint saved by SetCurrent.
+func (s Store) Current() (Fingerprint, error) {
+	content, e := os.ReadFile(filepath.Join(s.Root, CurrentName))
+	if nil != e {
+		return 0, e
+	}
+	return StringToFingerprint(strings.TrimSpace(string(content)))
+}
+
+// PutCurrent stores the schema and saves it as the current schema.
+func (s Store) PutCurrent(schema ha.Schema) (Fingerprint, error) {
+	fp, e := s.Put(schema)
+	if nil != e {
+		return 0, e
+	}
+	return fp, s.SetCurrent(fp)
+}
+
+// Resolver finds the schema by its fingerprint.
+type Resolver func(Fingerprint) (ha.Schema, error)
+
+// ToResolver creates a resolver which caches the parsed schemas.
+//
+// The resolver is safe for concurrent use.
+func (s Store) ToResolver() Resolver {
+	var mu sync.Mutex
+	var cache map[Fingerprint]ha.Schema = map[Fingerprint]ha.Schema{}
+	return func(fp Fingerprint) (ha.Schema, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		cached, found := cache[fp]
+		if found {
+			return cached, nil
+		}
+
+		parsed, e := s.Get(fp)
+		if nil != e {
+			return nil, e
+		}
+		cache[fp] = parsed
+		return parsed, nil
+	}
+}
+
+// PartitionFingerprintName returns the name of the sidecar of the partition.
+//
+// e.g, path/to/key.bin -> path/to/key.bin.fp
+func PartitionFingerprintName(partition string) string {
+	return partition + FingerprintExt
+}
+
+// PutPartition saves the fingerprint of the schema of the partition.
+func (s Store) PutPartition(partition string, fp Fingerprint) error {
+	return s.writeFile(
+		PartitionFingerprintName(partition),
+		[]byte(fp.String()+"\n"),
+	)
+}
+
+// PartitionFingerprint returns the fingerprint saved by PutPartition.
+//
+// The error wraps fs.ErrNotExist if the partition has no sidecar.
+func PartitionFingerprint(partition string) (Fingerprint, error) {
+	content, e := os.ReadFile(PartitionFingerprintName(partition))
+	if nil != e {
+		return 0, e
+	}
+	return StringToFingerprint(strings.TrimSpace(string(content)))
+}
+
+// PartitionSchema finds the schema of the headerless partition.
+//
+// The current schema is used if the partition has no sidecar(e.g, written
+// by an older version).
+func (s Store) PartitionSchema(partition string) (ha.Schema, error) {
+	fp, e := PartitionFingerprint(partition)
+	if errors.Is(e, fs.ErrNotExist) {
+		fp, e = s.Current()
+	}
+	if nil != e {
+		return nil, e
+	}
+	return s.Get(fp)
+}
@@ -0,0 +1,187 @@
+// Package signature signs the partition files using Ed25519ph.
+//
+// The signatures are detached raw 64-byte files(e.g, key.avro.sig) of the
+// SHA-512 digests of the files(RFC 8032 Ed25519ph with an empty context);
+// the files are streamed instead of being read into memory.
+package signature
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+var (
+	ErrInvalidKey      error = errors.New("invalid ed25519 key")
+	ErrUnknownMode     error = errors.New("unknown signing mode")
+	ErrUnsupportedSink error = errors.New("signing requires local files")
+)
+
+// Mode decides what to sign.
+type Mode string
+
+const (
+	// ModePartition signs each partition file.
+	ModePartition Mode = "partition"
+
+	// ModeManifest signs the manifest(which has the partition checksums).
+	ModeManifest Mode = "manifest"
+)
+
+func StringToMode(s string) (Mode, error) {
+	switch s {
+	case "", "partition":
+		return ModePartition, nil
+	case "manifest":
+		return ModeManifest, nil
+	default:
+		return ModePartition, fmt.Errorf("%w: %s", ErrUnknownMode, s)
+	}
+}
+
+// The extension of the detached signatures.
+const Ext string = ".sig"
+
+// SignatureName returns the name of the signature of the file.
+func SignatureName(filename string) string { return filename + Ext }
+
+// ParsePrivateKey parses a PKCS#8 PEM or a raw seed(32 bytes) or key(64).
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if nil != block {
+		parsed, e := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if nil != e {
+			return nil, e
+		}
+		key, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrInvalidKey, parsed)
+		}
+		return key, nil
+	}
+
+	switch len(data) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(data), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(data), nil
+	default:
+		return nil, fmt.Errorf("%w: size=%v", ErrInvalidKey, len(data))
+	}
+}
+
+// ParsePublicKey parses a PKIX PEM or a raw key(32 bytes).
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if nil != block {
+		parsed, e := x509.ParsePKIXPublicKey(block.Bytes)
+		if nil != e {
+			return nil, e
+		}
+		key, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrInvalidKey, parsed)
+		}
+		return key, nil
+	}
+
+	switch len(data) {
+	case ed25519.PublicKeySize:
+		return ed25519.PublicKey(data), nil
+	default:
+		return nil, fmt.Errorf("%w: size=%v", ErrInvalidKey, len(data))
+	}
+}
+
+func LoadPrivateKey(filename string) (ed25519.PrivateKey, error) {
+	data, e := os.ReadFile(filename)
+	if nil != e {
+		return nil, e
+	}
+	return ParsePrivateKey(data)
+}
+
+func LoadPublicKey(filename string) (ed25519.PublicKey, error) {
+	data, e := os.ReadFile(filename)
+	if nil != e {
+		return nil, e
+	}
+	return ParsePublicKey(data)
+}
+
+// Signer writes the detached signatures.
+type Signer struct {
+	Key ed25519.PrivateKey
+
+	// Called before renaming the signature(nil: no sync).
+	Sync func(*os.File) error
+}
+
+func writeFile(filename string, data []byte, sync func(*os.File) error) error {
+	var tmp string = filename + ".tmp"
+	f, e := os.Create(tmp)
+	if nil != e {
+		return e
+	}
+	_, e = f.Write(data)
+	if nil == e && nil != sync {
+		e = sync(f)
+	}
+	e = errors.Join(e, f.Close())
+	if nil != e {
+		return errors.Join(e, os.Remove(tmp))
+	}
+	return os.Rename(tmp, filename)
+}
+
+// The options of the Ed25519ph signatures.
+var options *ed25519.Options = &ed25519.Options{Hash: crypto.SHA512}
+
+// FileDigest computes the SHA-512 digest of the file to be signed.
+func FileDigest(filename string) ([]byte, error) {
+	f, e := os.Open(filename)
+	if nil != e {
+		return nil, e
+	}
+	defer f.Close()
+
+	var h hash.Hash = sha512.New()
+	_, e = io.Copy(h, f)
+	return h.Sum(nil), e
+}
+
+// SignFile signs the digest of the file and writes the signature next to it.
+func (s Signer) SignFile(filename string) error {
+	digest, e := FileDigest(filename)
+	if nil != e {
+		return e
+	}
+	sig, e := s.Key.Sign(nil, digest, options)
+	if nil != e {
+		return e
+	}
+	return writeFile(SignatureName(filename), sig, s.Sync)
+}
+
+// ToObserver signs each written partition file; the signatures of the
+// removed partitions are removed.
+func (s Signer) ToObserver() eh.StatObserver {
+	return func(stat eh.PartitionStat) error {
+		switch {
+		case stat.Skipped:
+			return nil
+		case stat.Removed:
+			return eh.RemoveFile(SignatureName(stat.Filename))
+		}
+		return s.SignFile(stat.Filename)
+	}
+}
@@ -0,0 +1,234 @@
+package signature_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+	mf "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/manifest"
+	sg "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/signature"
+)
+
+var partitions []string = []string{"a.avro", filepath.Join("b", "c.avro")}
+
+type tree struct {
+	root     string
+	signer   sg.Signer
+	verifier sg.Verifier
+}
+
+func newTree(t *testing.T) tree {
+	t.Helper()
+	var root string = t.TempDir()
+	for _, name := range append(partitions, filepath.Join("_schemas", "CURRENT")) {
+		var filename string = filepath.Join(root, name)
+		e := os.MkdirAll(filepath.Dir(filename), 0o755)
+		if nil != e {
+			t.Fatal(e)
+		}
+		e = os.WriteFile(filename, []byte(name), 0o644)
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+	var seed [ed25519.SeedSize]byte = [ed25519.SeedSize]byte{1, 2, 3}
+	var key ed25519.PrivateKey = ed25519.NewKeyFromSeed(seed[:])
+	return tree{
+		root:     root,
+		signer:   sg.Signer{Key: key},
+		verifier: sg.Verifier{Key: key.Public().(ed25519.PublicKey)},
+	}
+}
+
+func (tr tree) path(name string) string { return filepath.Join(tr.root, name) }
+
+func (tr tree) signPartitions(t *testing.T) {
+	t.Helper()
+	for _, name := range partitions {
+		e := tr.signer.SignFile(tr.path(name))
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+}
+
+// signManifest lists the partitions in the signed manifest.
+func (tr tree) signManifest(t *testing.T) {
+	t.Helper()
+	m, e := mf.Config{Root: tr.root}.Open(tr.path(mf.FilenameDefault))
+	if nil != e {
+		t.Fatal(e)
+	}
+	for _, name := range partitions {
+		data, e := os.ReadFile(tr.path(name))
+		if nil != e {
+			t.Fatal(e)
+		}
+		var sum [32]byte = sha256.Sum256(data)
+		e = m.Observe(eh.PartitionStat{
+			Filename: tr.path(name),
+			Records:  1,
+			Digest: &eh.Digest{
+				Checksum: "sha256:" + hex.EncodeToString(sum[:]),
+				Size:     int64(len(data)),
+			},
+		})
+		if nil != e {
+			t.Fatal(e)
+		}
+	}
+	e = m.Close()
+	if nil != e {
+		t.Fatal(e)
+	}
+	e = tr.signer.SignFile(tr.path(mf.FilenameDefault))
+	if nil != e {
+		t.Fatal(e)
+	}
+}
+
+func statuses(t *testing.T, results []sg.Result) map[string]sg.Status {
+	t.Helper()
+	var ret map[string]sg.Status = map[string]sg.Status{}
+	for _, res := range results {
+		ret[res.Path] = res.Status
+	}
+	return ret
+}
+
+func (tr tree) expect(t *testing.T, failed bool, path string, s sg.Status) {
+	t.Helper()
+	results, e := tr.verifier.VerifyTree(tr.root)
+	if failed != errors.Is(e, sg.ErrVerification) {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	if !failed && nil != e {
+		t.Fatal(e)
+	}
+	var actual sg.Status = statuses(t, results)[path]
+	if s != actual {
+		t.Fatalf("%s: %s", path, actual)
+	}
+}
+
+func TestVerifyPartitions(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var tr tree = newTree(t)
+		tr.signPartitions(t)
+		tr.expect(t, false, "b/c.avro", sg.StatusOk)
+
+		// the files other than the partitions are only reported
+		tr.expect(t, false, "_schemas/CURRENT", sg.StatusUnsigned)
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		var tr tree = newTree(t)
+		tr.signPartitions(t)
+		e := os.WriteFile(tr.path("a.avro"), []byte("modified"), 0o644)
+		if nil != e {
+			t.Fatal(e)
+		}
+		tr.expect(t, true, "a.avro", sg.StatusBadSignature)
+	})
+
+	t.Run("signature removed", func(t *testing.T) {
+		var tr tree = newTree(t)
+		tr.signPartitions(t)
+		e := os.WriteFile(tr.path("a.avro"), []byte("modified"), 0o644)
+		if nil == e {
+			e = os.Remove(sg.SignatureName(tr.path("a.avro")))
+		}
+		if nil != e {
+			t.Fatal(e)
+		}
+		tr.expect(t, true, "a.avro", sg.StatusUnsigned)
+
+		tr.verifier.AllowUnsigned = true
+		tr.expect(t, false, "a.avro", sg.StatusUnsigned)
+	})
+}
+
+func TestVerifyManifest(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var tr tree = newTree(t)
+		tr.signManifest(t)
+		tr.expect(t, false, mf.FilenameDefault, sg.StatusOk)
+		tr.expect(t, false, "b/c.avro", sg.StatusOk)
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		var tr tree = newTree(t)
+		tr.signManifest(t)
+		e := os.WriteFile(tr.path("a.avro"), []byte("modified"), 0o644)
+		if nil != e {
+			t.Fatal(e)
+		}
+		tr.expect(t, true, "a.avro", sg.StatusChecksumMismatch)
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		var tr tree = newTree(t)
+		tr.signManifest(t)
+		e := os.Remove(tr.path("a.avro"))
+		if nil != e {
+			t.Fatal(e)
+		}
+		tr.expect(t, true, "a.avro", sg.StatusMissing)
+	})
+
+	t.Run("signature removed", func(t *testing.T) {
+		var tr tree = newTree(t)
+		tr.signManifest(t)
+		e := os.Remove(sg.SignatureName(tr.path(mf.FilenameDefault)))
+		if nil != e {
+			t.Fatal(e)
+		}
+		tr.expect(t, true, "a.avro", sg.StatusUnsigned)
+	})
+
+	t.Run("manifest removed", func(t *testing.T) {
+		var tr tree = newTree(t)
+		tr.signManifest(t)
+		e := errors.Join(
+			os.Remove(tr.path(mf.FilenameDefault)),
+			os.Remove(sg.SignatureName(tr.path(mf.FilenameDefault))),
+		)
+		if nil != e {
+			t.Fatal(e)
+		}
+		tr.expect(t, true, "b/c.avro", sg.StatusUnsigned)
+	})
+}
+
+// The signatures are Ed25519ph signatures of the SHA-512 digests.
+func TestSignFilePrehash(t *testing.T) {
+	var tr tree = newTree(t)
+	tr.signPartitions(t)
+
+	var filename string = tr.path("a.avro")
+	data, e := os.ReadFile(filename)
+	if nil != e {
+		t.Fatal(e)
+	}
+	sig, e := os.ReadFile(sg.SignatureName(filename))
+	if nil != e {
+		t.Fatal(e)
+	}
+	var digest [64]byte = sha512.Sum512(data)
+	e = ed25519.VerifyWithOptions(
+		tr.verifier.Key,
+		digest[:],
+		sig,
+		&ed25519.Options{Hash: crypto.SHA512},
+	)
+	if nil != e {
+		t.Fatal(e)
+	}
+}
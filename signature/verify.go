@@ -0,0 +1,248 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+	mf "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/manifest"
+	ss "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/schemastore"
+)
+
+var ErrVerification error = errors.New("verification failure")
+
+// Status is the result of the verification of a file.
+type Status string
+
+const (
+	// StatusOk: a valid signature or a checksum in the signed manifest.
+	StatusOk Status = "ok"
+
+	// StatusUnsigned: neither signed nor listed in the signed manifest(a
+	// failure of a partition unless the Verifier allows it).
+	StatusUnsigned Status = "unsigned"
+
+	StatusBadSignature     Status = "bad-signature"
+	StatusChecksumMismatch Status = "checksum-mismatch"
+
+	// StatusMissing: listed in the signed manifest but not found.
+	StatusMissing Status = "missing"
+)
+
+// Failed is true if the file was modified(or removed).
+//
+// Unsigned files are not failures by themselves; see Verifier.Failed.
+func (s Status) Failed() bool {
+	switch s {
+	case StatusOk, StatusUnsigned:
+		return false
+	default:
+		return true
+	}
+}
+
+type Result struct {
+	// The slash separated path relative to the root.
+	Path string `json:"path"`
+
+	Status `json:"status"`
+}
+
+// VerifyFile checks the detached signature of the file.
+func VerifyFile(filename string, key ed25519.PublicKey) (Status, error) {
+	sig, e := os.ReadFile(SignatureName(filename))
+	if errors.Is(e, fs.ErrNotExist) {
+		return StatusUnsigned, nil
+	}
+	if nil != e {
+		return StatusBadSignature, e
+	}
+
+	digest, e := FileDigest(filename)
+	if nil != e {
+		return StatusBadSignature, e
+	}
+	if nil != ed25519.VerifyWithOptions(key, digest, sig, options) {
+		return StatusBadSignature, nil
+	}
+	return StatusOk, nil
+}
+
+// FileChecksum computes the checksum of the file using the same algorithm
+// as the expected checksum(e.g, sha256:e3b0...).
+func FileChecksum(filename string, expected string) (string, error) {
+	algo, e := eh.StringToDigestAlgorithm(
+		string(eh.Digest{Checksum: expected}.Algorithm()),
+	)
+	if nil != e {
+		return "", e
+	}
+
+	f, e := os.Open(filename)
+	if nil != e {
+		return "", e
+	}
+	defer f.Close()
+
+	var w *eh.DigestWriter = algo.NewWriter(io.Discard)
+	if nil == w {
+		return "", fmt.Errorf("%w: %s", eh.ErrUnknownDigest, expected)
+	}
+	_, e = io.Copy(w, f)
+	return w.Digest().Checksum, e
+}
+
+type Verifier struct {
+	Key ed25519.PublicKey
+
+	// The name of the manifest in the root(empty: mf.FilenameDefault).
+	ManifestName string
+
+	// Reports the unsigned partitions without failing(e.g, a partially
+	// signed tree); the tree is expected to be signed by default.
+	AllowUnsigned bool
+}
+
+// Failed is true if the file was modified(or removed), or the partition is
+// neither signed nor listed in the signed manifest.
+//
+// Other unsigned files(e.g, the schemas and the blobs) are only reported.
+func (v Verifier) Failed(res Result) bool {
+	if res.Status.Failed() {
+		return true
+	}
+	_, partition := eh.FilenameToOutputFormat(res.Path)
+	return StatusUnsigned == res.Status && partition && !v.AllowUnsigned
+}
+
+func (v Verifier) manifestName() string {
+	if "" == v.ManifestName {
+		return mf.FilenameDefault
+	}
+	return v.ManifestName
+}
+
+// auxiliary files are neither partitions nor signed.
+func auxiliary(path string) bool {
+	switch filepath.Ext(path) {
+	case Ext, eh.DigestSha256.Ext(), eh.DigestBlake3.Ext(), ".tmp",
+		ss.FingerprintExt:
+		return true
+	default:
+		return false
+	}
+}
+
+// signedEntries returns the entries of the manifest if the signature is
+// valid(nil if the manifest is not signed).
+func (v Verifier) signedEntries(
+	root string,
+) (map[string]mf.Entry, Result, error) {
+	var filename string = filepath.Join(root, v.manifestName())
+	var res Result = Result{Path: filepath.ToSlash(v.manifestName())}
+
+	status, e := VerifyFile(filename, v.Key)
+	res.Status = status
+	if nil != e || StatusOk != status {
+		return nil, res, e
+	}
+
+	entries, e := mf.LoadFile(filename)
+	return entries, res, e
+}
+
+func (v Verifier) verifyPartition(
+	root string,
+	path string,
+	entry mf.Entry,
+	listed bool,
+) (Status, error) {
+	var filename string = filepath.Join(root, filepath.FromSlash(path))
+
+	status, e := VerifyFile(filename, v.Key)
+	if nil != e || status.Failed() || !listed || "" == entry.Checksum {
+		return status, e
+	}
+
+	actual, e := FileChecksum(filename, entry.Checksum)
+	if nil != e {
+		return StatusChecksumMismatch, e
+	}
+	if actual != entry.Checksum {
+		return StatusChecksumMismatch, nil
+	}
+	return StatusOk, nil
+}
+
+// VerifyTree checks all the files in the root.
+//
+// The files are checked using their signatures and the checksums of the
+// signed manifest. The error wraps ErrVerification if any file was modified
+// or removed, or any partition is unsigned(see Failed); removing the
+// signatures(or the signature of the manifest) does not hide a change.
+func (v Verifier) VerifyTree(root string) ([]Result, error) {
+	entries, mres, e := v.signedEntries(root)
+	if nil != e {
+		return nil, e
+	}
+
+	var results []Result
+	var failed bool = mres.Failed()
+	if StatusUnsigned != mres.Status {
+		results = append(results, mres)
+	}
+
+	var found map[string]struct{} = map[string]struct{}{}
+	e = filepath.WalkDir(root, func(p string, d fs.DirEntry, e error) error {
+		if nil != e || d.IsDir() || auxiliary(p) {
+			return e
+		}
+
+		rel, e := filepath.Rel(root, p)
+		if nil != e {
+			return e
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == mres.Path {
+			return nil
+		}
+
+		entry, listed := entries[rel]
+		found[rel] = struct{}{}
+		status, e := v.verifyPartition(root, rel, entry, listed)
+		if nil != e {
+			return e
+		}
+		var res Result = Result{Path: rel, Status: status}
+		failed = failed || v.Failed(res)
+		results = append(results, res)
+		return nil
+	})
+	if nil != e {
+		return nil, e
+	}
+
+	for path := range entries {
+		// the absolute names(outside the root) are ignored
+		_, exists := found[path]
+		if !exists && filepath.IsLocal(filepath.FromSlash(path)) {
+			failed = true
+			results = append(results, Result{Path: path, Status: StatusMissing})
+		}
+	}
+
+	slices.SortFunc(results, func(a, b Result) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	if failed {
+		return results, ErrVerification
+	}
+	return results, nil
+}
@@ -0,0 +1,221 @@
+//go:build (darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64 || loong64 || ppc64le || riscv64 || s390x)) || (openbsd && (amd64 || arm64)) || (windows && (386 || amd64 || arm64))
+
+// Package sqlitesink stores each partition as a row of a SQLite database.
+package sqlitesink
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	eh "github.com/takanoriyanagitani/go-avro-blob-partition-by-pkey/avro/enc/hamba"
+)
+
+var (
+	ErrInvalidTable      error = errors.New("invalid table name")
+	ErrUnsupportedPolicy error = eh.ErrUnsupportedPolicy
+)
+
+const (
+	TableDefault     string = "partitions"
+	BatchSizeDefault int    = 1000
+)
+
+var validTable *regexp.Regexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type Config struct {
+	// The name of the database file.
+	Filename string
+
+	Table string
+
+	eh.ExistPolicy
+
+	// The fingerprint of the schema of the partitions.
+	SchemaFp string
+
+	// The number of the rows written in a transaction.
+	BatchSize int
+
+	// nil: time.Now
+	Clock func() time.Time
+}
+
+// Sink upserts the partitions keyed by the encoded primary keys.
+//
+// The rows are written in transactions of BatchSize rows; Close commits the
+// last transaction. Sink is safe for concurrent use.
+type Sink struct {
+	Config
+
+	mu      sync.Mutex
+	db      *sql.DB
+	tx      *sql.Tx
+	pending int
+}
+
+func (c Config) Open() (*Sink, error) {
+	if "" == c.Table {
+		c.Table = TableDefault
+	}
+	if !validTable.MatchString(c.Table) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTable, c.Table)
+	}
+	e := c.ExistPolicy.CheckValue()
+	if nil != e {
+		return nil, e
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = BatchSizeDefault
+	}
+	if nil == c.Clock {
+		c.Clock = time.Now
+	}
+
+	db, e := sql.Open("sqlite", c.Filename)
+	if nil != e {
+		return nil, e
+	}
+
+	// a single connection keeps the transaction visible to all the queries
+	db.SetMaxOpenConns(1)
+
+	_, e = db.Exec(`PRAGMA journal_mode=WAL`)
+	if nil == e {
+		_, e = db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s(
+			key        TEXT PRIMARY KEY,
+			data       BLOB NOT NULL,
+			schema_fp  TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		)`, c.Table))
+	}
+	if nil != e {
+		return nil, errors.Join(e, db.Close())
+	}
+	return &Sink{Config: c, db: db}, nil
+}
+
+// begin starts a transaction unless started.
+func (s *Sink) begin() (*sql.Tx, error) {
+	if nil != s.tx {
+		return s.tx, nil
+	}
+	tx, e := s.db.Begin()
+	s.tx = tx
+	return tx, e
+}
+
+func (s *Sink) commit() error {
+	if nil == s.tx {
+		return nil
+	}
+	var tx *sql.Tx = s.tx
+	s.tx = nil
+	s.pending = 0
+	return tx.Commit()
+}
+
+// Get returns the data of the partition.
+func (s *Sink) Get(key string) (data []byte, found bool, e error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, e := s.begin()
+	if nil != e {
+		return nil, false, e
+	}
+
+	e = tx.QueryRow(
+		fmt.Sprintf(`SELECT data FROM %s WHERE key=?`, s.Table),
+		key,
+	).Scan(&data)
+	switch {
+	case errors.Is(e, sql.ErrNoRows):
+		return nil, false, nil
+	case nil != e:
+		return nil, false, e
+	default:
+		return data, true, nil
+	}
+}
+
+// Put upserts the partition.
+func (s *Sink) Put(key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, e := s.begin()
+	if nil != e {
+		return e
+	}
+
+	_, e = tx.Exec(
+		fmt.Sprintf(`INSERT INTO %s(key, data, schema_fp, updated_at)
+			VALUES(?, ?, ?, ?)
+			ON CONFLICT(key) DO UPDATE SET
+				data=excluded.data,
+				schema_fp=excluded.schema_fp,
+				updated_at=excluded.updated_at`, s.Table),
+		key,
+		data,
+		s.SchemaFp,
+		s.Clock().UTC().Format(time.RFC3339Nano),
+	)
+	if nil != e {
+		return e
+	}
+
+	s.pending++
+	if s.pending < s.BatchSize {
+		return nil
+	}
+	return s.commit()
+}
+
+// Close commits the pending rows and closes the database.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.commit(), s.db.Close())
+}
+
+// rowPartition is a partition which will be upserted on commit.
+type rowPartition struct {
+	eh.MemFile
+	sink *Sink
+	key  string
+}
+
+func (r *rowPartition) Name() string  { return r.key }
+func (r *rowPartition) Commit() error { return r.sink.Put(r.key, r.Data) }
+func (r *rowPartition) Abort() error  { return nil }
+
+func (s *Sink) Create(key string) (eh.PartitionWriter, error) {
+	data, e := s.ExistPolicy.CreateValue(key, s.Get, nil)
+	if nil != e {
+		return nil, e
+	}
+	return &rowPartition{
+		MemFile: eh.MemFile{Data: data},
+		sink:    s,
+		key:     key,
+	}, nil
+}
+
+// Reopen reads the row to append to it.
+func (s *Sink) Reopen(key string) (eh.PartitionWriter, error) {
+	data, _, e := s.Get(key)
+	if nil != e {
+		return nil, e
+	}
+	return &rowPartition{
+		MemFile: eh.MemFile{Data: data},
+		sink:    s,
+		key:     key,
+	}, nil
+}